@@ -3,13 +3,15 @@ module github.com/heliorosa/gen_must
 go 1.21.5
 
 require (
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/tools v0.16.1
+	gopkg.in/yaml.v3 v3.0.1
+	mvdan.cc/gofumpt v0.5.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
 	golang.org/x/mod v0.14.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
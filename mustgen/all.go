@@ -0,0 +1,91 @@
+package mustgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// allName returns the name of the slice helper of w, set by the all option of the directive, empty if there is
+// none. all=true names it after the wrapper: MustParse gets MustParseAll.
+func (w *FuncSpec) allName() string {
+	switch name := w.Options["all"]; name {
+	case "", "false":
+		return ""
+	case "true":
+		return w.NewName + "All"
+	default:
+		return name
+	}
+}
+
+// generateAll writes the slice helper of w, calling the wrapper with each element of a slice, when enabled.
+func (g *Generator) generateAll(w *FuncSpec, v *WrapperView) error {
+	name := w.allName()
+	if name == "" {
+		return nil
+	}
+	params := w.wrapperParams()
+	param := params[len(params)-1]
+	used := map[string]bool{param.Name: true}
+	if w.Recv != nil {
+		used[w.Recv.Name] = true
+	}
+	for _, tp := range w.TypeParams {
+		used[tp.Name] = true
+	}
+	local := func(name string) string {
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+		}
+		used[name] = true
+		return name
+	}
+	res, elem := local("res"), local("elem")
+	var fun ast.Expr
+	args := idents(elem)
+	switch {
+	case w.RecvParam:
+		fun = selector(w.NewName)
+		args = idents(w.Recv.Name, elem)
+	case w.Recv != nil:
+		fun = selector(w.Recv.Name, w.NewName)
+	default:
+		fun = selector(w.NewName)
+	}
+	resType, err := parseType("[]" + v.ResultTypes[0])
+	if err != nil {
+		return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
+	// res := make([]T, 0, len(param))
+	// for _, elem := range param {
+	// 	res = append(res, wrapper(elem))
+	// }
+	// return res
+	decl, err := funcDecl(w, name, append(params[:len(params)-1:len(params)-1], Field{Name: param.Name, Type: "[]" + param.Type}),
+		[]string{"[]" + v.ResultTypes[0]},
+		&ast.AssignStmt{Lhs: idents(res), Tok: token.DEFINE, Rhs: []ast.Expr{
+			callExpr(ast.NewIdent("make"), resType, &ast.BasicLit{Kind: token.INT, Value: "0"}, callExpr(ast.NewIdent("len"), ast.NewIdent(param.Name))),
+		}},
+		&ast.RangeStmt{
+			Key:   ast.NewIdent("_"),
+			Value: ast.NewIdent(elem),
+			Tok:   token.DEFINE,
+			X:     ast.NewIdent(param.Name),
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.AssignStmt{Lhs: idents(res), Tok: token.ASSIGN, Rhs: []ast.Expr{
+				callExpr(ast.NewIdent("append"), ast.NewIdent(res), callExpr(instance(fun, w), args...)),
+			}}}},
+		},
+		&ast.ReturnStmt{Results: idents(res)},
+	)
+	if err != nil {
+		return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
+	fmt.Fprintf(g, "// %s calls %s with each element of %s, it panics on the first error\n", name, w.NewName, param.Name)
+	if err = printNode(g, decl); err != nil {
+		return err
+	}
+	fmt.Fprintf(g, "\n\n")
+	return nil
+}
@@ -0,0 +1,260 @@
+// Package analyzer implements an analysis.Analyzer reporting gen_must directives without a generated wrapper
+// and generated wrappers that don't match the signature of the wrapped function anymore.
+// The diagnostics carry suggested fixes, so editors using gopls can generate the wrappers.
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"github.com/heliorosa/gen_must/mustgen"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name: "mustgen",
+	Doc:  "report gen_must directives without an up to date Must wrapper",
+	Run:  run,
+}
+
+var tag string
+
+func init() {
+	Analyzer.Flags.StringVar(&tag, "tag", mustgen.DefaultTag, "directive marking the functions to be wrapped")
+}
+
+// wrapperDocRe matches the doc comment of the generated wrappers.
+var wrapperDocRe = regexp.MustCompile(`^(\w+) has the behavior of (\w+), except it panics on error`)
+
+type analyzer struct {
+	pass  *analysis.Pass
+	gen   *mustgen.Gen
+	decls map[string]*ast.FuncDecl
+	// generated are the function declarations in files generated by gen_must
+	generated []*ast.FuncDecl
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	a := &analyzer{
+		pass:  pass,
+		gen:   mustgen.New(mustgen.WithTag(tag)),
+		decls: make(map[string]*ast.FuncDecl),
+	}
+	for _, f := range pass.Files {
+		gen := isGenerated(f)
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			a.decls[declKey(recvTypeName(fd.Recv), fd.Name.Name)] = fd
+			if gen {
+				a.generated = append(a.generated, fd)
+			}
+		}
+	}
+	pkg := &packages.Package{
+		Name:      pass.Pkg.Name(),
+		PkgPath:   pass.Pkg.Path(),
+		Fset:      pass.Fset,
+		Syntax:    pass.Files,
+		Types:     pass.Pkg,
+		TypesInfo: pass.TypesInfo,
+	}
+	plan, err := a.gen.Plan(context.Background(), pkg)
+	if err != nil {
+		var posErr *mustgen.PosError
+		if !errors.As(err, &posErr) {
+			return nil, err
+		}
+		pass.Reportf(a.tokenPos(posErr.Pos), "%s: %s", posErr.Func, posErr.Err)
+		return nil, nil
+	}
+	wrapped := make(map[string]bool, len(plan.Funcs))
+	for _, spec := range plan.Funcs {
+		recv := ""
+		if spec.Recv != nil {
+			recv = baseTypeName(spec.Recv.Type)
+		}
+		wrapped[declKey(recv, spec.NewName)] = true
+		a.check(recv, spec)
+	}
+	for _, fd := range a.generated {
+		if !wrapped[declKey(recvTypeName(fd.Recv), fd.Name.Name)] {
+			a.checkOrphan(fd)
+		}
+	}
+	return nil, nil
+}
+
+func declKey(recv string, name string) string { return recv + "." + name }
+
+func baseTypeName(typ string) string {
+	typ = strings.TrimPrefix(typ, "*")
+	if i := strings.IndexByte(typ, '['); i != -1 {
+		typ = typ[:i]
+	}
+	return typ
+}
+
+// recvTypeName returns the name of the receiver type, without pointer and type parameters.
+func recvTypeName(recv *ast.FieldList) string {
+	if recv == nil || len(recv.List) == 0 {
+		return ""
+	}
+	typ := recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func isGenerated(f *ast.File) bool {
+	var header strings.Builder
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			header.WriteString(c.Text + "\n")
+		}
+	}
+	gen, _ := mustgen.IsGenerated(strings.NewReader(header.String()))
+	return gen
+}
+
+// tokenPos converts a position back to a token.Pos of the files of pass.
+func (a *analyzer) tokenPos(pos token.Position) token.Pos {
+	for _, f := range a.pass.Files {
+		tf := a.pass.Fset.File(f.Pos())
+		if tf != nil && tf.Name() == pos.Filename && pos.Line > 0 && pos.Line <= tf.LineCount() {
+			return tf.LineStart(pos.Line) + token.Pos(pos.Column-1)
+		}
+	}
+	return token.NoPos
+}
+
+func (a *analyzer) signature(fd *ast.FuncDecl) *types.Signature {
+	fn, ok := a.pass.TypesInfo.Defs[fd.Name].(*types.Func)
+	if !ok {
+		return nil
+	}
+	return fn.Type().(*types.Signature)
+}
+
+// wrapperCode returns the formatted code of the wrapper of spec.
+func wrapperCode(spec *mustgen.FuncSpec) (string, error) {
+	buffer := bytes.NewBufferString("package p\n\n")
+	if err := mustgen.NewGenerator(buffer).GenerateWrapper(spec); err != nil {
+		return "", err
+	}
+	b, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(b), "package p\n")), nil
+}
+
+func (a *analyzer) check(recv string, spec *mustgen.FuncSpec) {
+	orig := a.decls[declKey(recv, spec.Name)]
+	if orig == nil {
+		return
+	}
+	code, err := wrapperCode(spec)
+	if err != nil {
+		a.pass.Reportf(orig.Pos(), "%s: %s", spec.Name, err)
+		return
+	}
+	wrapper := a.decls[declKey(recv, spec.NewName)]
+	if wrapper == nil {
+		a.pass.Report(analysis.Diagnostic{
+			Pos:     orig.Name.Pos(),
+			Message: fmt.Sprintf("%s has a gen_must directive but no %s wrapper, run gen_must", spec.Name, spec.NewName),
+			SuggestedFixes: []analysis.SuggestedFix{{
+				Message:   "Generate Must wrapper",
+				TextEdits: []analysis.TextEdit{{Pos: orig.End(), End: orig.End(), NewText: []byte("\n\n" + code)}},
+			}},
+		})
+		return
+	}
+	origSig, wrapperSig := a.signature(orig), a.signature(wrapper)
+	if origSig == nil || wrapperSig == nil {
+		return
+	}
+	want := expectedSignature(a.pass.Pkg, origSig)
+	got := signatureString(a.pass.Pkg, wrapperSig)
+	if want == got {
+		return
+	}
+	start := wrapper.Pos()
+	if wrapper.Doc != nil {
+		start = wrapper.Doc.Pos()
+	}
+	a.pass.Report(analysis.Diagnostic{
+		Pos: wrapper.Name.Pos(),
+		Message: fmt.Sprintf("%s doesn't match %s anymore, run gen_must: want func%s, got func%s",
+			spec.NewName, spec.Name, want, got),
+		SuggestedFixes: []analysis.SuggestedFix{{
+			Message:   "Regenerate Must wrapper",
+			TextEdits: []analysis.TextEdit{{Pos: start, End: wrapper.End(), NewText: []byte(code)}},
+		}},
+	})
+}
+
+// checkOrphan reports a generated wrapper whose wrapped function doesn't have a directive anymore.
+func (a *analyzer) checkOrphan(wrapper *ast.FuncDecl) {
+	if wrapper.Doc == nil {
+		return
+	}
+	m := wrapperDocRe.FindStringSubmatch(wrapper.Doc.Text())
+	if m == nil || m[1] != wrapper.Name.Name {
+		return
+	}
+	orig := a.decls[declKey(recvTypeName(wrapper.Recv), m[2])]
+	if orig == nil || orig.Body == nil {
+		return
+	}
+	directive := "//" + tag
+	if m[1] != a.gen.Options().Naming(m[2]) {
+		directive += ": " + m[1]
+	}
+	a.pass.Report(analysis.Diagnostic{
+		Pos:     wrapper.Name.Pos(),
+		Message: fmt.Sprintf("%s wraps %s, which doesn't have a gen_must directive", m[1], m[2]),
+		SuggestedFixes: []analysis.SuggestedFix{{
+			Message: "Add gen_must directive to " + m[2],
+			TextEdits: []analysis.TextEdit{{
+				Pos:     orig.Body.Lbrace + 1,
+				End:     orig.Body.Lbrace + 1,
+				NewText: []byte("\n\t" + directive),
+			}},
+		}},
+	})
+}
+
+// expectedSignature returns the signature of the wrapper of a function with signature sig.
+func expectedSignature(pkg *types.Package, sig *types.Signature) string {
+	return mustgen.TupleString(pkg, sig.Params(), sig.Variadic()) + " " + mustgen.TupleString(pkg, mustgen.WrapperResults(sig), false)
+}
+
+func signatureString(pkg *types.Package, sig *types.Signature) string {
+	return mustgen.TupleString(pkg, sig.Params(), sig.Variadic()) + " " + mustgen.TupleString(pkg, sig.Results(), false)
+}
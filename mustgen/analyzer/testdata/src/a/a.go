@@ -0,0 +1,27 @@
+package a
+
+type Client struct{}
+
+func Parse(s string) (int, error) /* want `Parse has a gen_must directive but no MustParse wrapper, run gen_must` */ {
+	//@gen_must
+	return 0, nil
+}
+
+func Load(name string, n int) (string, error) {
+	//@gen_must
+	return name, nil
+}
+
+func (c *Client) Get(key string) (string, error) {
+	//@gen_must
+	return "", nil
+}
+
+func Open(name string) (*Client, error) {
+	//@gen_must
+	return nil, nil
+}
+
+func Close(c *Client) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,6 @@
+package b
+
+func noError() int /* want `noError: no error returned` */ {
+	//@gen_must
+	return 0
+}
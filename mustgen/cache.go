@@ -0,0 +1,256 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Cache remembers the inputs each output file was generated from and the plans of the packages,
+// so unchanged packages don't have to be loaded and generated again.
+type Cache struct {
+	Dir   string
+	Files Files
+}
+
+// PackageFiles returns the go files of the package matching patterns, without type checking it.
+func PackageFiles(ctx context.Context, patterns []string, buildFlags ...string) ([]string, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{Context: ctx, Mode: packages.NeedName | packages.NeedFiles, BuildFlags: buildFlags},
+		patterns...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, ErrNoPackageFound
+	}
+	return pkgs[0].GoFiles, nil
+}
+
+// inputFiles returns the files the plan of the package matching patterns is read from: its go files, and the go.mod
+// of its module, telling the go version of the output.
+func inputFiles(ctx context.Context, patterns []string, buildFlags ...string) ([]string, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{Context: ctx, Mode: packages.NeedName | packages.NeedFiles | packages.NeedModule, BuildFlags: buildFlags},
+		patterns...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, ErrNoPackageFound
+	}
+	files := pkgs[0].GoFiles
+	if m := pkgs[0].Module; m != nil && m.GoMod != "" {
+		files = append(files, m.GoMod)
+	}
+	return files, nil
+}
+
+// HashInputs returns a digest of the content of files (in any order) and of extra.
+func HashInputs(files []string, extra ...string) (string, error) {
+	return Files{}.HashInputs(files, extra...)
+}
+
+// HashInputs is like the HashInputs function, reading from f.
+func (fsys Files) HashInputs(files []string, extra ...string) (string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	h := sha256.New()
+	for _, name := range files {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, filepath.Base(name)+"\x00")
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	for _, e := range extra {
+		io.WriteString(h, e+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SourceDigest returns the digest of the files containing the tag comment, skipping generated files.
+func SourceDigest(files []string, tag string) (string, error) {
+	return Files{}.SourceDigest(files, tag)
+}
+
+// SourceDigest is like the SourceDigest function, reading from f.
+func (fsys Files) SourceDigest(files []string, tag string) (string, error) {
+	return fsys.sourceDigest(files, func(b []byte) bool { return bytes.Contains(b, []byte("//"+tag)) })
+}
+
+// sourceDigest returns the digest of the files kept by keep, skipping generated files.
+func (fsys Files) sourceDigest(files []string, keep func(src []byte) bool) (string, error) {
+	tagged := make([]string, 0, len(files))
+	for _, name := range files {
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		if !keep(b) {
+			continue
+		}
+		gen, err := IsGenerated(bytes.NewReader(b))
+		if err != nil {
+			return "", err
+		}
+		if !gen {
+			tagged = append(tagged, name)
+		}
+	}
+	return fsys.HashInputs(tagged)
+}
+
+// Digests returns the source digest stamped in outPath and the digest of the current source of the package
+// matching patterns, without type checking it. stamped is empty if outPath doesn't exist or has no digest.
+func (g *Gen) Digests(ctx context.Context, outPath string, patterns []string, buildFlags ...string) (stamped string, current string, err error) {
+	f, err := g.opts.Files.Open(outPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	if stamped, err = ReadDigest(f); err != nil || stamped == "" {
+		return "", "", err
+	}
+	files := g.opts.ScanFiles
+	if len(files) == 0 {
+		if files, err = PackageFiles(ctx, patterns, buildFlags...); err != nil {
+			return "", "", err
+		}
+	}
+	if current, err = g.sourceDigest(files); err != nil {
+		return "", "", err
+	}
+	return stamped, current, nil
+}
+
+// sourceDigest returns the SourceDigest of files. With targets or funcs, the functions to wrap may be in any file,
+// all of them are hashed.
+func (g *Gen) sourceDigest(files []string) (string, error) {
+	if len(g.opts.Targets) == 0 && len(g.opts.Funcs) == 0 {
+		return g.opts.Files.sourceDigest(files, g.directiveTag().mayContain)
+	}
+	return g.opts.Files.sourceDigest(files, func([]byte) bool { return true })
+}
+
+func (fsys Files) hashFile(name string) (string, error) {
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *Cache) entry(outPath string) (string, error) {
+	abs, err := filepath.Abs(outPath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])), nil
+}
+
+// Fresh reports whether outPath was generated from inputs hashing to key and neither it nor the other files
+// written with it, recorded by Store, have changed since.
+func (c *Cache) Fresh(outPath string, key string) (bool, error) {
+	entry, err := c.entry(outPath)
+	if err != nil {
+		return false, err
+	}
+	b, err := c.Files.ReadFile(entry)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// the key, then the hash and the path of each file
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if lines[0] != key || len(lines) < 2 {
+		return false, nil
+	}
+	for _, line := range lines[1:] {
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return false, nil
+		}
+		current, err := c.Files.hashFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if current != hash {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Store records that outPath and the other files written with it, as they are now, were generated from inputs
+// hashing to key.
+func (c *Cache) Store(outPath string, key string, files ...string) error {
+	entry, err := c.entry(outPath)
+	if err != nil {
+		return err
+	}
+	b := []byte(key + "\n")
+	for _, name := range append([]string{outPath}, files...) {
+		if name, err = filepath.Abs(name); err != nil {
+			return err
+		}
+		hash, err := c.Files.hashFile(name)
+		if err != nil {
+			return err
+		}
+		b = append(b, hash+" "+name+"\n"...)
+	}
+	return c.Files.Write(entry, b, 0o644)
+}
+
+func (c *Cache) planEntry(key string) string {
+	return filepath.Join(c.Dir, "plan-"+key+".json")
+}
+
+// Plan returns the plan stored with key by StorePlan, nil if there is none.
+func (c *Cache) Plan(key string) (*Plan, error) {
+	b, err := c.Files.ReadFile(c.planEntry(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ReadPlan(bytes.NewReader(b))
+}
+
+// StorePlan stores plan, planned from inputs hashing to key, so it doesn't have to be loaded again.
+func (c *Cache) StorePlan(key string, plan *Plan) error {
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err := plan.Write(buffer); err != nil {
+		return err
+	}
+	return c.Files.Write(c.planEntry(key), buffer.Bytes(), 0o644)
+}
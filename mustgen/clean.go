@@ -0,0 +1,70 @@
+package mustgen
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const generatedBy = "// This file is auto generated by gen_must"
+
+// IsGenerated reports whether the go source read from r carries the gen_must header.
+// Files with a custom marker are recognized if the "Code generated" line mentions gen_must.
+func IsGenerated(r io.Reader) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if strings.HasPrefix(line, generatedBy) ||
+			generatedMarkerRe.MatchString(line) && strings.Contains(line, "gen_must") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func isGeneratedFile(name string) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return IsGenerated(f)
+}
+
+// GeneratedFiles returns the files generated by gen_must in the packages matching patterns,
+// including the ones excluded by build constraints.
+func GeneratedFiles(ctx context.Context, patterns []string) ([]string, error) {
+	pkgs, err := packages.Load(&packages.Config{Context: ctx, Mode: packages.NeedName | packages.NeedFiles}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, ErrNoPackageFound
+	}
+	var files []string
+	for _, pkg := range pkgs {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, name := range append(pkg.GoFiles, pkg.IgnoredFiles...) {
+			gen, err := isGeneratedFile(name)
+			if err != nil {
+				return nil, err
+			}
+			if gen {
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
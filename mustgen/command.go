@@ -0,0 +1,745 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// command is the command line of Run generating the wrappers of a package, see parseCommand.
+type command struct {
+	flags *flag.FlagSet
+	// module generates each package with directives of args, see runModule
+	module bool
+	// args are the arguments after the flags: the files or the patterns of the package
+	args []string
+	// cmdFlags are the flags, given again to the run of each package when args match several ones
+	cmdFlags []string
+	// files are the files read and written by the command, the OS file system for Run
+	files Files
+
+	outFile  string
+	outDir   string
+	check    bool
+	diffOut  bool
+	planIn   string
+	planOut  string
+	version  bool
+	tags     string
+	modMode  string
+	modFile  string
+	header   string
+	marker   string
+	outPkg   string
+	format   string
+	merge    bool
+	cacheDir string
+	tmplFile string
+	verbose  bool
+	jsonDiag bool
+	keepGo   bool
+	strict   bool
+	recvName string
+	methFns  bool
+	ignored  bool
+	ifEmpty  string
+	goGen    bool
+	typesMod bool
+	typeChk  bool
+	verify   bool
+	lineDirs bool
+	window   int
+	layout   string
+	reexport bool
+	looseDir bool
+	panicArg bool
+	redact   string
+	stack    bool
+	mustErr  bool
+	metrics  bool
+	tracing  bool
+	factory  bool
+	lang     string
+	plugins  []Option
+	targets  []Target
+	funcs    []string
+	wrapFile string
+	manifest string
+	docFile  string
+	sarif    string
+	tests    string
+	benches  string
+
+	// the values read from the flags
+	toStdout   bool
+	headerText string
+	tmplText   string
+	targetList []string
+	buildFlags []string
+}
+
+// newFlagSet returns the flags of gen_must, set in c when parsed.
+func (c *command) newFlagSet(output io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("gen_must", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&c.outFile, "out", "-", "output `file`, in the package directory unless it's a path. default is stdout")
+	flags.StringVar(&c.outDir, "outdir", "", "`dir`ectory of the output files. default is the directory of the loaded package")
+	flags.BoolVar(&c.check, "check", false, "don't write the output file, fail if it is out of date")
+	flags.BoolVar(&c.diffOut, "diff", false, "don't write the output file, print a unified diff between it and the generated code")
+	flags.StringVar(&c.planIn, "plan-in", "", "generate from a plan `file` instead of loading the package")
+	flags.StringVar(&c.planOut, "plan-out", "", "write the plan to a `file` (- for stdout) instead of generating")
+	flags.StringVar(&c.tags, "tags", "", "comma-separated list of build `tags` used to load the package")
+	flags.StringVar(&c.modMode, "mod", "", "module download `mode` used to load the package: readonly, vendor or mod")
+	flags.StringVar(&c.modFile, "modfile", "", "alternate go.mod `file` used to load the package, instead of the one of the module")
+	flags.StringVar(&c.header, "header-file", "", "`file` with a header (eg: a license) written before the generated code marker")
+	flags.StringVar(&c.marker, "marker", "", "text/`template` of the generated code marker, {{.Version}} and {{.Package}} are available")
+	flags.StringVar(&c.outPkg, "package", "", "package `name` of the generated file. default is the name of the loaded package")
+	flags.StringVar(&c.format, "format", "goimports", "`formatter` of the output: "+strings.Join(Formatters(), ", "))
+	flags.BoolVar(&c.merge, "merge", false, "replace only the gen_must region of the output file, keeping the rest of it")
+	flags.StringVar(&c.cacheDir, "cache", "", "`dir`ectory of the cache used to skip packages that didn't change since the last run")
+	flags.StringVar(&c.tmplFile, "template", "", "`file` with the text/template of the wrappers")
+	flags.BoolVar(&c.typesMod, "types", false, "wrap every exported function returning an error, using only the type information of the package")
+	flags.BoolVar(&c.typeChk, "typecheck", false, "type-check the package: accept concrete error types and check the signatures of hand-written wrappers")
+	flags.BoolVar(&c.verify, "verify", false, "type-check the generated files with the package, and don't write them if they don't compile")
+	flags.IntVar(&c.window, "window", 64, "number `n` of wrappers generated, formatted and written at once, bounding the memory used on large packages")
+	flags.StringVar(&c.layout, "layout", LayoutPackage, "`layout` of the output, where the wrappers are written: "+strings.Join(Layouts(), ", ")+
+		", internal writes them to internal/must/<path> in the module, importing the package, receiver writes the "+
+		"wrappers of the methods of each type to a file of their own")
+	flags.BoolVar(&c.reexport, "reexport", false, "with -package or -layout internal, declare again the exported types, constants and variables of the package, so the callers only import the output")
+	flags.BoolVar(&c.panicArg, "panic-args", false, "panic with an error describing the call: the name of the wrapper and its arguments")
+	flags.StringVar(&c.redact, "redact-types", "", "comma-separated list of parameter `types` written as *** by -panic-args")
+	flags.BoolVar(&c.stack, "stack", false, "panic with an error carrying the stack of the failed call")
+	flags.BoolVar(&c.mustErr, "must-error", false, "panic with a *MustError, declared in the output, holding the name of the wrapper and the error")
+	flags.BoolVar(&c.metrics, "metrics", false, "call the OnMustFailure hook, declared in the output, before panicking")
+	flags.BoolVar(&c.tracing, "tracing", false, "call the RecordMustError hook, declared in the output, with the context of the wrapper before panicking")
+	flags.BoolVar(&c.factory, "factory", false, "write a MustFactory type with a method calling the wrapper of each constructor (New...)")
+	flags.Var(&repeatedFlag{parse: func(s string) error {
+		variant, command, ok := strings.Cut(s, "=")
+		if !ok || variant == "" || strings.TrimSpace(command) == "" || variant == VariantMust || variant == VariantOnce {
+			return fmt.Errorf("expected variant=command, with another variant than %s and %s", VariantMust, VariantOnce)
+		}
+		c.plugins = append(c.plugins, WithPlugin(variant, command))
+		return nil
+	}}, "plugin", "`variant=command`: generate the wrappers of the variant with the plugin command, repeatable")
+	flags.Var(&repeatedFlag{parse: func(s string) error {
+		t, err := ParseTarget(s)
+		c.targets = append(c.targets, t)
+		return err
+	}}, "wrap", "`target`, a pattern [key=value ...]: wrap the functions matching the pattern (eg: Get*, or Queries.Get* for methods) without a directive, with the options of a directive, repeatable")
+	flags.StringVar(&c.wrapFile, "wrap-file", "", "`file` listing the -wrap targets, one per line")
+	flags.Var(&repeatedFlag{parse: func(s string) error {
+		if !ValidFuncName(s) {
+			return fmt.Errorf("expected a function name or Type.Method: %s", s)
+		}
+		c.funcs = append(c.funcs, s)
+		return nil
+	}}, "func", "`name`: wrap only the function name, or Type.Method, tagged or not, repeatable")
+	flags.StringVar(&c.lang, "lang", "", "go `version` of the output, eg: go1.17: the wrappers needing a newer one (generic functions, iterators, the once variant) are errors. default is the go version of the module, disabling them with a warning")
+	flags.BoolVar(&c.lineDirs, "line", false, "write //line directives pointing the wrappers to the wrapped functions")
+	flags.StringVar(&c.manifest, "manifest", "", "write a JSON manifest of the generated wrappers to a `file` (- for stdout)")
+	flags.StringVar(&c.docFile, "doc", "", "write a markdown summary of the generated wrappers to a `file`")
+	flags.StringVar(&c.sarif, "sarif", "", "with -check, write the stale wrappers and the unsupported functions to a SARIF `file`")
+	flags.StringVar(&c.tests, "tests", "", "write a test `file` for the wrappers, to be completed by hand, unless it already exists (- for stdout)")
+	flags.StringVar(&c.benches, "bench", "", "write benchmarks of the wrappers against the direct calls to a `file`, unless it already exists (- for stdout)")
+	flags.BoolVar(&c.goGen, "go-generate", false, "write the command line, relative to the output directory, in a //go:generate directive of the output")
+	flags.BoolVar(&c.strict, "strict", false, "fail on a function with an unsupported signature, instead of skipping it with a warning")
+	flags.StringVar(&c.recvName, "recv-name", DefaultRecvName, "`name` of the blank or unnamed receivers in the wrappers")
+	flags.BoolVar(&c.methFns, "method-funcs", false, "generate the wrappers of the methods as functions taking the receiver as first parameter")
+	flags.BoolVar(&c.ignored, "scan-ignored", false, "scan the files guarded by //go:build ignore too")
+	flags.BoolVar(&c.looseDir, "loose-directives", false, "accept the near misses of the directives: // @gen_must, /*@gen_must*/ and the tags of another case")
+	flags.StringVar(&c.ifEmpty, "if-empty", IfEmptyWarn, "`mode` of a package without tagged functions: "+strings.Join(IfEmptyModes(), ", ")+
+		", warn writes the output with the header only, skip doesn't write it, fail exits with an error")
+	flags.BoolVar(&c.keepGo, "keep-going", false, "report all the functions that can't be wrapped, and still generate the wrappers of the other ones")
+	flags.BoolVar(&c.jsonDiag, "json", false, "write the warnings and the errors to stderr as JSON objects, one per line")
+	flags.BoolVar(&c.verbose, "v", false, "log the wrapped functions and timings to stderr")
+	flags.BoolVar(&c.version, "version", false, "print the version and exit")
+	flags.Usage = func() { usage(flags) }
+	return flags
+}
+
+// synopsis returns the flags of flags as written in a usage line, by name, eg: [-check] [-out file]. The name of
+// the value of a flag is the back-quoted word of its usage.
+func synopsis(flags *flag.FlagSet) string {
+	var list []string
+	flags.VisitAll(func(f *flag.Flag) {
+		if name, _ := flag.UnquoteUsage(f); name != "" {
+			list = append(list, fmt.Sprintf("[-%s %s]", f.Name, name))
+			return
+		}
+		list = append(list, fmt.Sprintf("[-%s]", f.Name))
+	})
+	return strings.Join(list, " ")
+}
+
+// parseCommand parses and checks args, the command line of Run. The command is nil when Run returns code: the
+// usage or an error was written to stderr, or the version to stdout.
+func parseCommand(args []string, stdout, stderr io.Writer) (c *command, code int) {
+	c = &command{}
+	// module generates the packages with directives of the patterns, ./... by default
+	if c.module = len(args) > 0 && args[0] == "module"; c.module {
+		args = args[1:]
+	}
+	c.flags = c.newFlagSet(stderr)
+	if err := c.flags.Parse(args); err != nil {
+		return nil, parseExitCode(err)
+	}
+	if c.version {
+		fmt.Fprintln(stdout, "gen_must", toolVersion())
+		return nil, ExitOK
+	}
+	if _, ok := stderr.(*diagnosticWriter); c.jsonDiag && !ok {
+		stderr = &diagnosticWriter{w: stderr}
+	}
+	c.args = c.flags.Args()
+	c.cmdFlags = args[:len(args)-c.flags.NArg()]
+	if c.module && c.planIn != "" {
+		return nil, fail(stderr, ExitUsage, errors.New("module can't be used with -plan-in"))
+	}
+	if c.module && len(c.args) == 0 {
+		c.args = []string{"./..."}
+	}
+	if len(c.args) == 0 && c.planIn == "" {
+		c.flags.Usage()
+		return nil, ExitUsage
+	}
+	if err := c.validate(); err != nil {
+		return nil, fail(stderr, ExitUsage, err)
+	}
+	if c.tags != "" {
+		c.buildFlags = append(c.buildFlags, "-tags="+c.tags)
+	}
+	if c.modMode != "" {
+		c.buildFlags = append(c.buildFlags, "-mod="+c.modMode)
+	}
+	if c.modFile != "" {
+		c.buildFlags = append(c.buildFlags, "-modfile="+c.modFile)
+	}
+	return c, ExitOK
+}
+
+// validate returns the error of the flags that can't be used together, or of an invalid value.
+func (c *command) validate() error {
+	c.toStdout = c.outFile == "" || c.outFile == "-"
+	switch {
+	case c.check && c.toStdout:
+		return errors.New("-check requires -out")
+	case c.diffOut && c.toStdout:
+		return errors.New("-diff requires -out")
+	case c.sarif != "" && !c.check:
+		return errors.New("-sarif requires -check")
+	case c.verify && c.toStdout:
+		return errors.New("-verify requires -out")
+	case c.merge && c.toStdout:
+		return errors.New("-merge requires -out")
+	case !slices.Contains(Formatters(), c.format):
+		return fmt.Errorf("%w: %s", ErrUnknownFormatter, c.format)
+	case c.typesMod && c.planIn != "":
+		return errors.New("-types can't be used with -plan-in")
+	case c.typeChk && (c.typesMod || c.planIn != ""):
+		return errors.New("-typecheck can't be used with -types or -plan-in")
+	case c.lang != "" && !ValidLang(c.lang):
+		return fmt.Errorf("%w: %s", ErrInvalidLang, c.lang)
+	case !token.IsIdentifier(c.recvName) || c.recvName == "_":
+		return fmt.Errorf("invalid -recv-name: %s", c.recvName)
+	case !slices.Contains(IfEmptyModes(), c.ifEmpty):
+		return fmt.Errorf("invalid -if-empty: %s", c.ifEmpty)
+	case !slices.Contains(Layouts(), c.layout):
+		return fmt.Errorf("unknown layout: %s", c.layout)
+	case c.layout == LayoutInternal && (c.typesMod || c.planIn != "" || c.outDir != ""):
+		return errors.New("-layout internal can't be used with -types, -plan-in or -outdir")
+	case c.layout == LayoutReceiver && (c.toStdout || c.outPkg != ""):
+		return errors.New("-layout receiver requires -out and can't be used with -package")
+	case c.reexport && c.outPkg == "" && c.layout != LayoutInternal:
+		return errors.New("-reexport requires -package or -layout internal")
+	case c.modMode != "" && !slices.Contains([]string{"readonly", "vendor", "mod"}, c.modMode):
+		return fmt.Errorf("invalid -mod: %s", c.modMode)
+	case c.planIn != "" && c.outPkg != "":
+		return errors.New("-package can't be used with -plan-in")
+	}
+	return nil
+}
+
+// readFiles reads the header, the template and the targets of the files of the flags.
+func (c *command) readFiles() error {
+	if c.header != "" {
+		b, err := c.files.ReadFile(c.header)
+		if err != nil {
+			return err
+		}
+		c.headerText = string(b)
+	}
+	if c.wrapFile != "" {
+		f, err := c.files.Open(c.wrapFile)
+		if err != nil {
+			return err
+		}
+		fileTargets, err := ReadTargets(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.wrapFile, err)
+		}
+		c.targets = append(c.targets, fileTargets...)
+	}
+	if c.tmplFile != "" {
+		b, err := c.files.ReadFile(c.tmplFile)
+		if err != nil {
+			return err
+		}
+		c.tmplText = string(b)
+	}
+	for _, t := range c.targets {
+		c.targetList = append(c.targetList, t.String())
+	}
+	return nil
+}
+
+// options returns the options of the generator told by the flags, logging to stderr.
+func (c *command) options(stderr io.Writer) []Option {
+	logLevel := slog.LevelWarn
+	if c.verbose {
+		logLevel = slog.LevelDebug
+	}
+	var logHandler slog.Handler = slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: logLevel})
+	if c.jsonDiag {
+		logHandler = NewDiagnosticHandler(stderr, &slog.HandlerOptions{Level: logLevel})
+	}
+	var redactTypes []string
+	if c.redact != "" {
+		redactTypes = strings.Split(c.redact, ",")
+	}
+	return append(slices.Clone(c.plugins),
+		WithFS(c.files.FS),
+		WithWriteFile(c.files.WriteFile),
+		WithLogger(slog.New(logHandler)),
+		WithPackage(c.outPkg),
+		WithFormatter(c.format),
+		WithVersion(toolVersion()),
+		WithHeader(c.headerText),
+		WithMarker(c.marker),
+		WithTemplate(c.tmplText),
+		WithTypeCheck(c.typeChk),
+		WithWindow(c.window),
+		WithLayout(c.layout),
+		WithReexport(c.reexport),
+		WithKeepGoing(c.keepGo),
+		WithLenient(!c.strict),
+		WithRecvName(c.recvName),
+		WithMethodFuncs(c.methFns),
+		WithScanIgnored(c.ignored),
+		WithLooseDirectives(c.looseDir),
+		WithIfEmpty(c.ifEmpty),
+		WithPanicArgs(c.panicArg),
+		WithRedactTypes(redactTypes...),
+		WithStack(c.stack),
+		WithMustError(c.mustErr),
+		WithMetrics(c.metrics),
+		WithTracing(c.tracing),
+		WithFactory(c.factory),
+		WithLang(c.lang),
+		WithLineDirectives(c.lineDirs),
+		WithTargets(c.targets...),
+		WithFuncs(c.funcs...),
+	)
+}
+
+// outputFlags are the flags only changing how the plan is generated and where it's written, not the plan.
+var outputFlags = map[string]bool{
+	"out": true, "outdir": true, "check": true, "diff": true, "plan-out": true, "header-file": true, "marker": true,
+	"format": true, "merge": true, "cache": true, "template": true, "verify": true, "window": true, "panic-args": true,
+	"redact-types": true, "stack": true, "must-error": true, "metrics": true, "tracing": true, "factory": true,
+	"line": true, "manifest": true, "doc": true, "sarif": true, "tests": true, "bench": true, "go-generate": true,
+	"keep-going": true, "json": true, "v": true,
+}
+
+// keyInputs returns the inputs of the cache keys besides the files of the package: the version, the flags given on
+// the command line, but the skipped ones, and the content of the files they name. Skipping too few flags only makes
+// the cache miss.
+func (c *command) keyInputs(skip map[string]bool) []string {
+	inputs := []string{toolVersion()}
+	c.flags.Visit(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		if r, ok := f.Value.(*repeatedFlag); ok {
+			for _, v := range r.values {
+				inputs = append(inputs, "-"+f.Name+"="+v)
+			}
+		} else {
+			inputs = append(inputs, "-"+f.Name+"="+f.Value.String())
+		}
+		switch f.Name {
+		case "header-file":
+			inputs = append(inputs, c.headerText)
+		case "template":
+			inputs = append(inputs, c.tmplText)
+		case "wrap-file":
+			inputs = append(inputs, c.targetList...)
+		}
+	})
+	return inputs
+}
+
+// run generates the wrappers of the command line, writing the generated code and the messages to stdout and stderr.
+// It returns the exit code.
+func (c *command) run(ctx context.Context, stdout, stderr io.Writer) int {
+	if _, ok := stderr.(*diagnosticWriter); c.jsonDiag && !ok {
+		stderr = &diagnosticWriter{w: stderr}
+	}
+	if err := c.readFiles(); err != nil {
+		return fail(stderr, ExitUsage, err)
+	}
+	args := c.args
+	// the package of a list of files is loaded, only the files are scanned. The files excluded by the build
+	// constraints are only loaded when named, without the rest of the package
+	var scanFiles []string
+	if c.planIn == "" && !c.typesMod {
+		pattern, isFiles, err := FilesPackage(args)
+		if err != nil {
+			return fail(stderr, ExitUsage, err)
+		}
+		if isFiles {
+			scanFiles = args
+			files, err := inputFiles(ctx, []string{pattern}, c.buildFlags...)
+			if err == nil && containsFiles(files, scanFiles) {
+				args = []string{pattern}
+			}
+		}
+	}
+	if c.planIn == "" {
+		patterns, err := WorkspacePatterns(ctx, args)
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		var paths []string
+		if c.module {
+			paths, err = New(WithLooseDirectives(c.looseDir), WithFS(c.files.FS)).DirectivePackages(ctx, patterns, c.buildFlags...)
+		} else {
+			paths, err = PackagePaths(ctx, patterns, c.buildFlags...)
+		}
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		runPkg := func(path string) int { return c.runPackage(ctx, path, stdout, stderr) }
+		if len(paths) > 1 || c.module {
+			if c.toStdout || outputPath(".", c.outFile) != c.outFile || c.outDir != "" || c.typesMod || c.planOut != "" ||
+				c.manifest != "" || c.docFile != "" || c.sarif != "" {
+				return fail(stderr, ExitUsage, errors.New("module, and patterns matching several packages, require an -out "+
+					"file name and can't be used with -outdir, -types, -plan-out, -manifest, -doc or -sarif"))
+			}
+			if c.module {
+				return runModule(paths, runPkg, stderr)
+			}
+			return runPackages(paths, runPkg)
+		}
+		args = patterns
+	}
+	// the outputs go to the directory of the loaded package, or to the working directory when there is none
+	// (with -plan-in) or it's a dependency (with -types)
+	outFileDir := c.outDir
+	switch {
+	case outFileDir != "":
+	case c.layout == LayoutInternal:
+		dir, err := InternalDir(ctx, args, c.buildFlags...)
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		outFileDir = relativeDir(dir)
+	case c.planIn == "" && !c.typesMod:
+		dir, err := packageDir(ctx, args, c.buildFlags)
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		outFileDir = dir
+	default:
+		outFileDir = "."
+	}
+	var outPath string
+	if !c.toStdout {
+		outPath = outputPath(outFileDir, c.outFile)
+	}
+	var goGenArgs string
+	if c.goGen {
+		// go generate runs the directive from the directory of the output
+		genDir := outFileDir
+		if outPath != "" {
+			genDir = filepath.Dir(outPath)
+		}
+		goGenArgs = goGenerateArgs(c.flags, genDir)
+	}
+	g := New(append(c.options(stderr), WithScanFiles(scanFiles...), WithGoGenerate(goGenArgs))...)
+	var (
+		cache    *Cache
+		cacheKey string
+	)
+	if c.cacheDir != "" && c.planIn == "" && c.planOut == "" && !c.toStdout {
+		cache = &Cache{Dir: c.cacheDir, Files: c.files}
+		files, err := inputFiles(ctx, args, c.buildFlags...)
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		files = slices.DeleteFunc(files, func(name string) bool { return sameFile(name, outPath) })
+		if cacheKey, err = c.files.HashInputs(files, append(c.keyInputs(nil), c.args...)...); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		fresh, err := cache.Fresh(outPath, cacheKey)
+		if err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		if fresh {
+			return ExitOK
+		}
+	}
+	// a digest other than the one of the source tells the output is stale without loading the package. The same
+	// digest doesn't tell it's up to date: the flags or the output may have changed, it's compared with the code
+	// generated now. Nor does it tell which wrappers are stale, nor whether the files of the receiver types are
+	if c.check && !c.merge && c.planIn == "" && c.sarif == "" && c.layout != LayoutReceiver {
+		stamped, current, err := g.Digests(ctx, outPath, args, c.buildFlags...)
+		if err != nil {
+			return fail(stderr, ExitLoad, err)
+		}
+		if stamped != "" && stamped != current {
+			return fail(stderr, ExitCheck, fmt.Errorf("%s is out of date: source digest is %s, %s was generated from %s",
+				outPath, current, c.outFile, stamped))
+		}
+	}
+	plan, planErr, code := c.plan(ctx, g, args, scanFiles, stderr)
+	if plan == nil {
+		return code
+	}
+	if c.planOut != "" {
+		if err := writeJSON(c.files, stdout, c.planOut, plan); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		return code
+	}
+	if c.ifEmpty == IfEmptySkip && plan.empty() && !c.check && !c.diffOut {
+		g.opts.Logger.Info("output not written", "out", c.outFile)
+		return code
+	}
+	files := []PlanFile{{Path: outPath, Plan: plan}}
+	if c.layout == LayoutReceiver {
+		files = ReceiverFiles(plan, outPath)
+	}
+	if !c.toStdout {
+		// the wrappers of functions with different build constraints go to a file per constraint
+		var split []PlanFile
+		for _, f := range files {
+			split = append(split, ConstraintFiles(f.Plan, f.Path)...)
+		}
+		files = split
+	}
+	if writeCode := c.write(ctx, g, files, planErr, stdout, stderr); writeCode != ExitOK || c.check || c.diffOut {
+		if writeCode == ExitOK {
+			return code
+		}
+		return writeCode
+	}
+	if err := writeSideOutputs(g, stdout, c.manifest, c.docFile, plan, files); err != nil {
+		return fail(stderr, ExitError, err)
+	}
+	if err := writeSkeletons(g, stdout, outFileDir, c.tests, c.benches, plan); err != nil {
+		return fail(stderr, ExitError, err)
+	}
+	if cache != nil && planErr == nil {
+		// the run is skipped only if none of the files it writes changed, the skeletons are edited by hand
+		var written []string
+		for _, f := range files[1:] {
+			written = append(written, f.Path)
+		}
+		for _, name := range []string{c.manifest, c.docFile} {
+			if name != "" && name != "-" {
+				written = append(written, name)
+			}
+		}
+		if err := cache.Store(outPath, cacheKey, written...); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+	}
+	return code
+}
+
+// runPackage runs gen_must with the flags of c for the package path, reading and writing the files of c.
+func (c *command) runPackage(ctx context.Context, path string, stdout, stderr io.Writer) int {
+	pc, code := parseCommand(append(slices.Clip(c.cmdFlags), path), stdout, stderr)
+	if pc == nil {
+		return code
+	}
+	pc.files = c.files
+	return pc.run(ctx, stdout, stderr)
+}
+
+// plan returns the plan of the package of args: read from -plan-in or from the cache, or planned by g. With
+// -keep-going, planErr are the errors of the functions that can't be wrapped, and code their exit code. The plan is
+// nil when Run returns code, the error already written to stderr.
+func (c *command) plan(ctx context.Context, g *Gen, args, scanFiles []string, stderr io.Writer) (plan *Plan, planErr error, code int) {
+	var (
+		err     error
+		planKey string
+	)
+	if c.cacheDir != "" && c.planIn == "" {
+		// the plan only depends on the files of the package and on the options used to load and scan it
+		files, err := inputFiles(ctx, args, c.buildFlags...)
+		if err != nil {
+			return nil, nil, fail(stderr, ExitLoad, err)
+		}
+		if planKey, err = c.files.HashInputs(files, append(c.keyInputs(outputFlags), scanFiles...)...); err != nil {
+			return nil, nil, fail(stderr, ExitError, err)
+		}
+		if plan, err = (&Cache{Dir: c.cacheDir, Files: c.files}).Plan(planKey); err != nil {
+			return nil, nil, fail(stderr, ExitError, err)
+		}
+	}
+	cachedPlan := plan != nil
+	if cachedPlan {
+		g.opts.Logger.Debug("plan loaded from the cache", "key", planKey)
+	} else if c.planIn != "" {
+		if plan, err = readPlan(c.files, c.planIn); err != nil {
+			return nil, nil, fail(stderr, ExitLoad, err)
+		}
+	} else if c.typesMod {
+		pkg, err := LoadTypes(ctx, args, c.buildFlags...)
+		if err != nil {
+			return nil, nil, fail(stderr, ExitLoad, err)
+		}
+		if plan, err = g.PlanTypes(pkg); err != nil {
+			return nil, nil, fail(stderr, generateExitCode(err), err)
+		}
+	} else {
+		pkg, err := g.Load(ctx, args, c.buildFlags...)
+		if err != nil {
+			return nil, nil, fail(stderr, ExitLoad, err)
+		}
+		plan, err = g.Plan(ctx, pkg)
+		switch {
+		case err != nil && c.keepGo && plan != nil:
+			// the wrappers of the other functions are still generated, the errors are reported once the rest is
+			planErr = err
+			code = fail(stderr, generateExitCode(err), err)
+		case err != nil:
+			if findings := unsupportedFindings(err); c.sarif != "" && len(findings) > 0 {
+				if err := writeSARIF(c.files, c.sarif, findings); err != nil {
+					return nil, nil, fail(stderr, ExitError, err)
+				}
+			}
+			return nil, nil, fail(stderr, generateExitCode(err), err)
+		}
+	}
+	if planKey != "" && !cachedPlan && planErr == nil {
+		if err = (&Cache{Dir: c.cacheDir, Files: c.files}).StorePlan(planKey, plan); err != nil {
+			return nil, nil, fail(stderr, ExitError, err)
+		}
+	}
+	return plan, planErr, code
+}
+
+// write writes the code of files: to their path or to stdout, compared with their content with -check and -diff,
+// written once they compile with -verify. planErr are the errors of the plan, written to the SARIF file of -check.
+func (c *command) write(ctx context.Context, g *Gen, files []PlanFile, planErr error, stdout, stderr io.Writer) int {
+	// with -check and -diff, the out of date files. With -verify, the files waiting for the type-check
+	var (
+		stale     []string
+		findings  []Finding
+		generated = make(map[string][]byte)
+	)
+	for _, file := range files {
+		if !c.merge && !c.check && !c.diffOut && !c.verify && g.CanStream() {
+			// the wrappers are formatted one at a time and written as they are generated
+			if err := streamOutput(g, stdout, file.Path, file.Plan); err != nil {
+				return fail(stderr, generateExitCode(err), err)
+			}
+			continue
+		}
+		buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+		gen := g.Generator(buffer)
+		var err error
+		if c.merge {
+			src, readErr := g.ReadFile(file.Path)
+			if errors.Is(readErr, os.ErrNotExist) {
+				src, readErr = []byte("package "+file.Plan.Package+"\n"), nil
+			}
+			if readErr != nil {
+				return fail(stderr, ExitError, readErr)
+			}
+			err = gen.Merge(src, file.Plan)
+		} else {
+			err = gen.Emit(file.Plan)
+		}
+		if err != nil {
+			return fail(stderr, generateExitCode(err), err)
+		}
+		fmtCode := bytes.NewBuffer(make([]byte, 0, buffer.Len()))
+		if err = g.Format(file.Path, buffer, fmtCode); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		if c.check || c.diffOut {
+			current, err := g.ReadFile(file.Path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fail(stderr, ExitError, err)
+			}
+			if bytes.Equal(current, fmtCode.Bytes()) {
+				continue
+			}
+			stale = append(stale, file.Path)
+			if c.diffOut {
+				if err = writeDiff(stdout, file.Path, current, fmtCode.Bytes()); err != nil {
+					return fail(stderr, ExitError, err)
+				}
+			}
+			if c.sarif != "" {
+				staleFound, err := staleFindings(g, file.Plan, current, file.Path)
+				if err != nil {
+					return fail(stderr, ExitError, err)
+				}
+				findings = append(findings, staleFound...)
+			}
+			continue
+		}
+		if c.verify {
+			generated[file.Path] = fmtCode.Bytes()
+			continue
+		}
+		if c.toStdout {
+			_, err = stdout.Write(fmtCode.Bytes())
+		} else {
+			err = g.WriteFile(file.Path, fmtCode.Bytes())
+		}
+		if err != nil {
+			return fail(stderr, ExitError, err)
+		}
+	}
+	if c.check || c.diffOut {
+		if c.sarif != "" {
+			if err := writeSARIF(c.files, c.sarif, append(unsupportedFindings(planErr), findings...)); err != nil {
+				return fail(stderr, ExitError, err)
+			}
+		}
+		if len(stale) > 0 && !c.check {
+			return ExitCheck
+		}
+		if len(stale) > 0 {
+			return fail(stderr, ExitCheck, fmt.Errorf("%s is out of date", strings.Join(stale, ", ")))
+		}
+		return ExitOK
+	}
+	if len(generated) > 0 {
+		// the files are only written once they compile with the package
+		if err := VerifyFiles(ctx, generated, c.buildFlags...); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		for _, file := range files {
+			if err := g.WriteFile(file.Path, generated[file.Path]); err != nil {
+				return fail(stderr, ExitError, err)
+			}
+		}
+	}
+	return ExitOK
+}
@@ -0,0 +1,118 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config lists the wrappers of several packages, generated in one run by gen_must config. It's read from YAML or
+// JSON.
+type Config struct {
+	// Flags are the command line flags of every package, eg: [-typecheck, -panic-args]
+	Flags    []string        `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Packages []ConfigPackage `json:"packages" yaml:"packages"`
+}
+
+// ConfigPackage is a package of a Config.
+type ConfigPackage struct {
+	// Path is the package, a directory (eg: ./store) or an import path
+	Path string `json:"path" yaml:"path"`
+	// Out is the output file, like -out: a file name is in the directory of the package
+	Out string `json:"out" yaml:"out"`
+	// Flags are the command line flags of the package, after the ones of the Config
+	Flags []string `json:"flags,omitempty" yaml:"flags,omitempty"`
+	// Funcs select the functions wrapped without a directive, the tagged ones are wrapped too
+	Funcs []ConfigFunc `json:"funcs,omitempty" yaml:"funcs,omitempty"`
+}
+
+// ConfigFunc selects functions of a ConfigPackage, see Target.
+type ConfigFunc struct {
+	// Select is a function name, Type.Method, or a pattern matching them
+	Select string `json:"select" yaml:"select"`
+	// Name is the name of the wrapper, only for a function name
+	Name    string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Variant string            `json:"variant,omitempty" yaml:"variant,omitempty"`
+	Options map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// ReadConfig reads a Config from r, YAML or JSON. The unknown fields are errors.
+func ReadConfig(r io.Reader) (*Config, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	c := &Config{}
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	for i, pkg := range c.Packages {
+		if pkg.Path == "" || pkg.Out == "" {
+			return nil, fmt.Errorf("%w: package %d: path and out are required", ErrInvalidConfig, i+1)
+		}
+		if _, err := pkg.targets(); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, pkg.Path, err)
+		}
+	}
+	return c, nil
+}
+
+// targets returns the targets of the functions of p.
+func (p ConfigPackage) targets() ([]Target, error) {
+	targets := make([]Target, 0, len(p.Funcs))
+	for _, f := range p.Funcs {
+		fields := []string{f.Select}
+		if f.Name != "" {
+			fields = append(fields, f.Name)
+		}
+		if f.Variant != "" {
+			fields = append(fields, "variant="+f.Variant)
+		}
+		keys := make([]string, 0, len(f.Options))
+		for key := range f.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fields = append(fields, key+"="+f.Options[key])
+		}
+		t, err := ParseTarget(strings.Join(fields, " "))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// Args returns the command line arguments of Run generating the wrappers of the package i of c. The relative paths
+// of the packages and of the outputs are relative to dir, the directory of the config file.
+func (c *Config) Args(i int, dir string) ([]string, error) {
+	p := c.Packages[i]
+	targets, err := p.targets()
+	if err != nil {
+		return nil, err
+	}
+	args := append(append([]string{}, c.Flags...), p.Flags...)
+	out := p.Out
+	if strings.ContainsAny(out, `/`+string(filepath.Separator)) && !filepath.IsAbs(out) {
+		out = filepath.Join(dir, out)
+	}
+	args = append(args, "-out", out)
+	for _, t := range targets {
+		args = append(args, "-wrap", t.String())
+	}
+	path := p.Path
+	if path == "." || path == ".." || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		// a local path must start with ./ or ../, not to be taken for an import path
+		if path = filepath.Join(dir, path); !filepath.IsAbs(path) && !strings.HasPrefix(path, ".") {
+			path = "./" + path
+		}
+	}
+	return append(args, path), nil
+}
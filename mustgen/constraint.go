@@ -0,0 +1,320 @@
+package mustgen
+
+import (
+	"errors"
+	"go/ast"
+	"go/build/constraint"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var ErrMixedConstraints = errors.New("wrappers of functions with different build constraints can't be written to the same file")
+
+// GOOS and GOARCH values recognized in file names, see go/build.
+var (
+	knownOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios", "js", "linux", "nacl",
+		"netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows", "zos",
+	}
+	knownArch = []string{
+		"386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "loong64", "mips", "mipsle", "mips64",
+		"mips64le", "mips64p32", "mips64p32le", "ppc", "ppc64", "ppc64le", "riscv", "riscv64", "s390", "s390x",
+		"sparc", "sparc64", "wasm",
+	}
+)
+
+// fileConstraint returns the build constraint of file, combining its //go:build line and the GOOS/GOARCH
+// suffixes of filename. It's empty if the file isn't constrained.
+func fileConstraint(file *ast.File, filename string) string {
+	var expr constraint.Expr
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+			if x, err := constraint.Parse(c.Text); err == nil {
+				expr = x
+			}
+		}
+	}
+	for _, tag := range fileNameTags(filename) {
+		t := &constraint.TagExpr{Tag: tag}
+		switch {
+		case expr == nil:
+			expr = t
+		case !satisfiable(expr, &constraint.NotExpr{X: t}):
+			// the //go:build line already requires it, eg: linux in foo_linux.go
+		default:
+			expr = &constraint.AndExpr{X: expr, Y: t}
+		}
+	}
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
+
+// impliedOS are the GOOS whose builds satisfy the tag of another one too, see go/build.
+var impliedOS = map[string]string{"android": "linux", "illumos": "solaris", "ios": "darwin"}
+
+// exclusiveConstraints reports whether the build constraints a and b can't be satisfied by the same build. The empty
+// constraint is always satisfied.
+func exclusiveConstraints(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	x, errX := constraint.Parse("//go:build " + a)
+	y, errY := constraint.Parse("//go:build " + b)
+	if errX != nil || errY != nil {
+		return false
+	}
+	return !satisfiable(x, y)
+}
+
+// satisfiable reports whether a build satisfies all of exprs: a build has a single GOOS and GOARCH, the other tags
+// may be set or not. The expressions with too many tags to try every build are deemed satisfiable.
+func satisfiable(exprs ...constraint.Expr) bool {
+	// the GOOS and GOARCH of the builds: the ones of the tags, or another one
+	oses, arches := []string{""}, []string{""}
+	var tags []string
+	collect := func(tag string) bool {
+		switch {
+		case slices.Contains(knownOS, tag):
+			if !slices.Contains(oses, tag) {
+				oses = append(oses, tag)
+			}
+		case slices.Contains(knownArch, tag):
+			if !slices.Contains(arches, tag) {
+				arches = append(arches, tag)
+			}
+		case !slices.Contains(tags, tag):
+			tags = append(tags, tag)
+		}
+		return true
+	}
+	// Eval calls collect with every tag of the expression
+	for _, x := range exprs {
+		x.Eval(collect)
+	}
+	if len(tags) > 16 {
+		return true
+	}
+	for _, goos := range oses {
+		for _, goarch := range arches {
+			for set := 0; set < 1<<len(tags); set++ {
+				satisfied := func(tag string) bool {
+					switch {
+					case slices.Contains(knownOS, tag):
+						return tag == goos || impliedOS[goos] == tag
+					case slices.Contains(knownArch, tag):
+						return tag == goarch
+					}
+					return set&(1<<slices.Index(tags, tag)) != 0
+				}
+				all := true
+				for _, x := range exprs {
+					all = all && x.Eval(satisfied)
+				}
+				if all {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// constraintKey returns the build constraint c in a normal form, telling apart the constraints that don't read the
+// same: the operands of the chains of && and || sorted, without duplicates, eg: amd64 && linux for linux && amd64
+// && linux.
+func constraintKey(c string) string {
+	if c == "" {
+		return ""
+	}
+	expr, err := constraint.Parse("//go:build " + c)
+	if err != nil {
+		return c
+	}
+	return normalExpr(expr).String()
+}
+
+// normalExpr returns x with the operands of its chains of && and || sorted and without duplicates.
+func normalExpr(x constraint.Expr) constraint.Expr {
+	switch x := x.(type) {
+	case *constraint.NotExpr:
+		return &constraint.NotExpr{X: normalExpr(x.X)}
+	case *constraint.AndExpr, *constraint.OrExpr:
+		_, and := x.(*constraint.AndExpr)
+		var operands []constraint.Expr
+		var flatten func(constraint.Expr)
+		flatten = func(y constraint.Expr) {
+			switch y := y.(type) {
+			case *constraint.AndExpr:
+				if and {
+					flatten(y.X)
+					flatten(y.Y)
+					return
+				}
+			case *constraint.OrExpr:
+				if !and {
+					flatten(y.X)
+					flatten(y.Y)
+					return
+				}
+			}
+			operands = append(operands, normalExpr(y))
+		}
+		flatten(x)
+		sort.Slice(operands, func(i, j int) bool { return operands[i].String() < operands[j].String() })
+		operands = slices.CompactFunc(operands, func(a, b constraint.Expr) bool { return a.String() == b.String() })
+		expr := operands[0]
+		for _, y := range operands[1:] {
+			if and {
+				expr = &constraint.AndExpr{X: expr, Y: y}
+			} else {
+				expr = &constraint.OrExpr{X: expr, Y: y}
+			}
+		}
+		return expr
+	}
+	return x
+}
+
+// fileNameTags returns the GOOS and GOARCH implied by the name of a file, eg: foo_linux_amd64.go.
+func fileNameTags(filename string) []string {
+	name := strings.TrimSuffix(filepath.Base(filename), ".go")
+	name = strings.TrimSuffix(name, "_test")
+	parts := strings.Split(name, "_")
+	if len(parts) < 2 {
+		return nil
+	}
+	n := len(parts)
+	if n >= 3 && slices.Contains(knownOS, parts[n-2]) && slices.Contains(knownArch, parts[n-1]) {
+		return []string{parts[n-2], parts[n-1]}
+	}
+	if slices.Contains(knownOS, parts[n-1]) || slices.Contains(knownArch, parts[n-1]) {
+		return []string{parts[n-1]}
+	}
+	return nil
+}
+
+// planConstraint returns the build constraint shared by the wrappers of plan.
+func planConstraint(plan *Plan) (string, error) {
+	constraints := make([]string, 0, len(plan.Funcs)+len(plan.Decorators))
+	for _, w := range plan.Funcs {
+		constraints = append(constraints, w.Constraint)
+	}
+	for _, d := range plan.Decorators {
+		constraints = append(constraints, d.Constraint)
+	}
+	if len(constraints) == 0 {
+		return "", nil
+	}
+	for _, c := range constraints[1:] {
+		if constraintKey(c) != constraintKey(constraints[0]) {
+			return "", ErrMixedConstraints
+		}
+	}
+	return constraints[0], nil
+}
+
+// ConstraintFiles splits plan, written to outPath, by the build constraint of its wrappers when they differ: the
+// unconstrained ones stay in outPath, the other ones are written to a file per constraint carrying it, named after
+// its tags, eg: must_linux.go. A plan with a single constraint isn't split.
+func ConstraintFiles(plan *Plan, outPath string) []PlanFile {
+	if _, err := planConstraint(plan); err == nil {
+		return []PlanFile{{Path: outPath, Plan: plan}}
+	}
+	main := &Plan{Package: plan.Package, Digest: plan.Digest, Funcs: []*FuncSpec{}, Parts: slices.Clone(plan.Parts), Part: plan.Part,
+		Reexports: plan.Reexports}
+	// the parts by the key of their constraint, the one of their first wrapper
+	parts := make(map[string]*Plan)
+	part := func(c string) *Plan {
+		if c == "" {
+			return main
+		}
+		p, ok := parts[constraintKey(c)]
+		if !ok {
+			p = &Plan{Package: plan.Package, Digest: plan.Digest, Funcs: []*FuncSpec{}, Part: c}
+			parts[constraintKey(c)] = p
+			main.Parts = append(main.Parts, c)
+		}
+		return p
+	}
+	for _, w := range plan.Funcs {
+		p := part(w.Constraint)
+		p.Funcs = append(p.Funcs, w)
+	}
+	for _, d := range plan.Decorators {
+		p := part(d.Constraint)
+		p.Decorators = append(p.Decorators, d)
+	}
+	files := []PlanFile{{Path: outPath, Plan: main}}
+	for _, p := range parts {
+		files = append(files, PlanFile{Path: constraintFile(outPath, p.Part), Plan: p})
+	}
+	sort.Slice(files[1:], func(i, j int) bool { return files[i+1].Path < files[j+1].Path })
+	for _, f := range files {
+		f.Plan.Imports = usedImports(plan.Imports, f.Plan)
+	}
+	return files
+}
+
+// constraintFile returns the name of the file of the wrappers of outPath with the build constraint c: the tags of c
+// suffixing outPath, eg: must_linux_amd64.go, must_not_windows_build.go. The _build suffix keeps the GOOS or GOARCH
+// ending the name from constraining the file more than c, see fileNameTags.
+func constraintFile(outPath, c string) string {
+	expr, err := constraint.Parse("//go:build " + c)
+	if err != nil {
+		return outPath
+	}
+	var words []string
+	var walk func(constraint.Expr)
+	walk = func(x constraint.Expr) {
+		switch x := x.(type) {
+		case *constraint.TagExpr:
+			words = append(words, strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+					return r
+				}
+				return -1
+			}, x.Tag))
+		case *constraint.NotExpr:
+			words = append(words, "not")
+			walk(x.X)
+		case *constraint.AndExpr:
+			walk(x.X)
+			walk(x.Y)
+		case *constraint.OrExpr:
+			walk(x.X)
+			words = append(words, "or")
+			walk(x.Y)
+		}
+	}
+	walk(expr)
+	base := strings.TrimSuffix(filepath.Base(outPath), ".go") + "_" + strings.Join(words, "_")
+	required := make(map[string]bool)
+	var conjuncts func(constraint.Expr)
+	conjuncts = func(x constraint.Expr) {
+		switch x := x.(type) {
+		case *constraint.TagExpr:
+			required[x.Tag] = true
+		case *constraint.AndExpr:
+			conjuncts(x.X)
+			conjuncts(x.Y)
+		}
+	}
+	conjuncts(expr)
+	for _, tag := range fileNameTags(base + ".go") {
+		if !required[tag] {
+			base += "_build"
+			break
+		}
+	}
+	return filepath.Join(filepath.Dir(outPath), base+".go")
+}
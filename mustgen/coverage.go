@@ -0,0 +1,85 @@
+package mustgen
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Coverage is the number of exported functions and methods of a package returning an error, and of the ones having
+// a Must counterpart.
+type Coverage struct {
+	PkgPath string `json:"pkgPath"`
+	Funcs   int    `json:"funcs"`
+	Covered int    `json:"covered"`
+	// Missing are the functions without counterpart, eg: Client.Get for a method
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Percent returns the percentage of the functions having a counterpart, 100 without functions.
+func (c Coverage) Percent() float64 {
+	if c.Funcs == 0 {
+		return 100
+	}
+	return 100 * float64(c.Covered) / float64(c.Funcs)
+}
+
+// Coverage returns the coverage of pkg: its exported functions, and the methods of its exported types, whose last
+// result is an error, and the ones tagged by a directive or whose wrapper is declared in the package, generated or
+// written by hand, under the name given by Naming. The functions are found from the syntax.
+func (g *Gen) Coverage(ctx context.Context, pkg *packages.Package) (Coverage, error) {
+	cov := Coverage{PkgPath: pkg.PkgPath}
+	scanned, err := g.scanned(pkg)
+	if err != nil {
+		return cov, err
+	}
+	covered := make(map[*ast.FuncDecl]bool)
+	err = walkPackage(ctx, scanned, g.directiveTag(), g.opts.Naming, g.opts.Targets, func(_ *directive, fnDecl *ast.FuncDecl) error {
+		covered[fnDecl] = true
+		return nil
+	})
+	if err != nil {
+		return cov, err
+	}
+	declared := make(map[string]bool)
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				declared[funcKey(recvName(fn.Recv), fn.Name.Name)] = true
+			}
+		}
+	}
+	for _, file := range scanned.Syntax {
+		if isGeneratedSyntax(file) {
+			continue
+		}
+		regions := generatedRegions(file)
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || inRegions(regions, fn.Pos()) || !fn.Name.IsExported() || !returnsError(fn) {
+				continue
+			}
+			recv := recvName(fn.Recv)
+			if fn.Recv != nil && !token.IsExported(recv) {
+				continue
+			}
+			cov.Funcs++
+			wrapper := g.opts.Naming(fn.Name.Name)
+			switch {
+			case covered[fn], declared[funcKey(recv, wrapper)]:
+			case recv != "" && declared[funcKey("", g.opts.Naming(methodFuncName(recv, fn.Name.Name)))]:
+			default:
+				name := fn.Name.Name
+				if recv != "" {
+					name = recv + "." + name
+				}
+				cov.Missing = append(cov.Missing, name)
+				continue
+			}
+			cov.Covered++
+		}
+	}
+	return cov, nil
+}
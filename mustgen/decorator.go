@@ -0,0 +1,181 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"slices"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var ErrGenericDecorator = errors.New("generic types can't be decorated")
+
+// DecoratorSpec is a type embedding a pointer to a struct of the package, with a method panicking on error for
+// each of its methods and func-typed fields returning an error. It's generated for the structs tagged by a directive, written as the
+// first comment of the struct: type Client struct { //@gen_must [newName] ...
+type DecoratorSpec struct {
+	// Type is the name of the struct, NewName the name of the decorator, Type+"Must" by default
+	Type    string `json:"type"`
+	NewName string `json:"newName"`
+	// Methods are the wrappers of the methods and of the func-typed fields, their receiver is the decorator and
+	// their Name the selector of the method or of the field through the embedded field, eg: Client.Get
+	Methods []*FuncSpec `json:"methods"`
+	// Pos is the position of the struct, Constraint the build constraint of its file
+	Pos        token.Position `json:"pos"`
+	Constraint string         `json:"constraint,omitempty"`
+	// directivePos is the position of the directive, unknown for a plan read from JSON
+	directivePos token.Position
+}
+
+// taggedStruct returns the directive of spec, written as the first comment of its struct type.
+func taggedStruct(file *ast.File, spec *ast.TypeSpec, tag directiveTag) (directive, bool) {
+	if c := structComment(file, spec); c != nil {
+		d, ok := tag.parse(c.Text)
+		d.comment = c
+		return d, ok
+	}
+	return directive{}, false
+}
+
+// structComment returns the first comment of the struct type of spec, before its first field. nil if there is none.
+func structComment(file *ast.File, spec *ast.TypeSpec) *ast.Comment {
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok || st.Fields == nil {
+		return nil
+	}
+	end := st.Fields.Closing
+	if len(st.Fields.List) > 0 {
+		end = st.Fields.List[0].Pos()
+	}
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			if c.Pos() > st.Fields.Opening && c.Pos() < end {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// planDecorators adds to plan the decorators of the structs of pkg tagged in the files of scanned. The errors are
+// passed to report, planDecorators stops if it returns one.
+func (g *Gen) planDecorators(pkg, scanned *packages.Package, plan *Plan, qual string, constraints map[string]string, report func(error) error) error {
+	methods := make(map[string][]*ast.FuncDecl)
+	var tagged []*ast.TypeSpec
+	directives := make(map[*ast.TypeSpec]directive)
+	for _, file := range pkg.Syntax {
+		if isGeneratedSyntax(file) {
+			continue
+		}
+		scan := slices.Contains(scanned.Syntax, file)
+		regions := generatedRegions(file)
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv != nil && !inRegions(regions, decl.Pos()) {
+					recv := recvName(decl.Recv)
+					methods[recv] = append(methods[recv], decl)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					if d, ok := taggedStruct(file, ts, g.directiveTag()); ok && scan {
+						tagged = append(tagged, ts)
+						directives[ts] = d
+					}
+				}
+			}
+		}
+	}
+	for _, ts := range tagged {
+		d := directives[ts]
+		pos := pkg.Fset.Position(ts.Pos())
+		var err error
+		switch {
+		case qual != "":
+			err = &PosError{Pos: pos, Func: ts.Name.Name, Err: ErrForeignReceiver}
+		case ts.TypeParams != nil:
+			err = &PosError{Pos: pos, Func: ts.Name.Name, Err: ErrGenericDecorator}
+		}
+		if err != nil {
+			if err = report(err); err != nil {
+				return err
+			}
+			continue
+		}
+		dec := &DecoratorSpec{
+			Type:       ts.Name.Name,
+			NewName:    d.name,
+			Pos:        pos,
+			Constraint: constraints[pos.Filename],
+			Methods:    []*FuncSpec{},
+		}
+		if d.comment != nil {
+			dec.directivePos = pkg.Fset.Position(d.comment.Pos())
+		}
+		if dec.NewName == "" {
+			dec.NewName = dec.Type + "Must"
+		}
+		for _, fnDecl := range append(methods[dec.Type], funcFields(ts)...) {
+			p := &planner{fset: pkg.Fset, fn: fnDecl, info: pkg.TypesInfo, file: fileOf(pkg.Syntax, fnDecl)}
+			w, err := p.planWrapper(&directive{name: fnDecl.Name.Name})
+			if errors.Is(err, ErrNoErrorReturn) || errors.Is(err, ErrNoReturnValues) {
+				continue
+			}
+			if err == nil {
+				err = plan.addImports(p.imports)
+			}
+			if err != nil {
+				g.opts.Logger.Warn("method not decorated", "type", dec.Type, "method", fnDecl.Name.Name, "err", err)
+				continue
+			}
+			w.Name = dec.Type + "." + w.Name
+			w.Recv = &Field{Name: "m", Type: dec.NewName}
+			for i := 1; slices.ContainsFunc(w.Params, func(f Field) bool { return f.Name == w.Recv.Name }); i++ {
+				w.Recv.Name = fmt.Sprintf("m%d", i)
+			}
+			dec.Methods = append(dec.Methods, w)
+		}
+		sort.Slice(dec.Methods, func(i, j int) bool { return dec.Methods[i].NewName < dec.Methods[j].NewName })
+		g.opts.Logger.Debug("struct decorated", "type", dec.Type, "decorator", dec.NewName, "methods", len(dec.Methods))
+		plan.Decorators = append(plan.Decorators, dec)
+	}
+	sort.Slice(plan.Decorators, func(i, j int) bool { return plan.Decorators[i].NewName < plan.Decorators[j].NewName })
+	return nil
+}
+
+// funcFields returns the func-typed fields of the struct of spec as functions, the decorator calls them like its
+// methods.
+func funcFields(spec *ast.TypeSpec) []*ast.FuncDecl {
+	var decls []*ast.FuncDecl
+	for _, field := range spec.Type.(*ast.StructType).Fields.List {
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok {
+			continue
+		}
+		for _, name := range field.Names {
+			decls = append(decls, &ast.FuncDecl{Name: name, Type: ft})
+		}
+	}
+	return decls
+}
+
+// generateDecorator writes the decorator d, the Must method of its struct returning it, and its methods.
+func (g *Generator) generateDecorator(d *DecoratorSpec) error {
+	fmt.Fprintf(g, "// %s has the methods of %s, except the ones returning an error panic on error.\n", d.NewName, d.Type)
+	fmt.Fprintf(g, "type %s struct{ *%s }\n\n", d.NewName, d.Type)
+	fmt.Fprintf(g, "// Must returns the methods of v panicking on error.\n")
+	fmt.Fprintf(g, "func (v *%s) Must() %s { return %s{v} }\n\n", d.Type, d.NewName, d.NewName)
+	for _, w := range d.Methods {
+		if err := g.GenerateWrapper(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
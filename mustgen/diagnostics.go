@@ -0,0 +1,188 @@
+package mustgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Diagnostic is a warning or an error of gen_must, written as a JSON object by -json.
+type Diagnostic struct {
+	// Level is error, warn, info or debug
+	Level string `json:"level"`
+	// Code identifies the kind of diagnostic: the kind of failure for the errors (usage, load, unsupported,
+	// out-of-date or error), the message in kebab case for the others, eg: no-tagged-functions-found
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// failureCodes are the codes of the diagnostics of the failures, by exit code.
+var failureCodes = map[int]string{
+	ExitError:       "error",
+	ExitUsage:       "usage",
+	ExitLoad:        "load",
+	ExitUnsupported: "unsupported",
+	ExitCheck:       "out-of-date",
+	ExitCoverage:    "coverage",
+}
+
+// setPos sets the position of d.
+func (d *Diagnostic) setPos(pos token.Position) {
+	d.File, d.Line, d.Column = pos.Filename, pos.Line, pos.Column
+}
+
+// setErr adds err to the message of d, and takes the position and the function of a *PosError.
+func (d *Diagnostic) setErr(err error) {
+	var posErr *PosError
+	if errors.As(err, &posErr) {
+		d.setPos(posErr.Pos)
+		d.Function = posErr.Func
+		err = posErr.Err
+	}
+	if d.Message == "" {
+		d.Message = err.Error()
+		return
+	}
+	d.Message += ": " + err.Error()
+}
+
+// diagnosticWriter writes diagnostics, one JSON object per line.
+type diagnosticWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (dw *diagnosticWriter) Write(p []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	return dw.w.Write(p)
+}
+
+func (dw *diagnosticWriter) write(d Diagnostic) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = dw.Write(append(b, '\n'))
+	return err
+}
+
+// failure writes the diagnostic of a failure with the exit code, returned by fail in -json mode.
+func (dw *diagnosticWriter) failure(code int, err error) {
+	errs := []error{err}
+	var list ErrorList
+	if errors.As(err, &list) {
+		errs = list
+	}
+	for _, err := range errs {
+		d := Diagnostic{Level: "error", Code: failureCodes[code]}
+		d.setErr(err)
+		dw.write(d)
+	}
+}
+
+type diagnosticHandler struct {
+	w     *diagnosticWriter
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+// NewDiagnosticHandler returns a slog.Handler writing the records to w as Diagnostic JSON objects, one per line.
+// The func, file, pos and err attributes fill the function and the position of the diagnostic, the other ones are
+// appended to its message.
+func NewDiagnosticHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	h := &diagnosticHandler{w: &diagnosticWriter{w: w}, level: slog.LevelInfo}
+	if dw, ok := w.(*diagnosticWriter); ok {
+		h.w = dw
+	}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+func (h *diagnosticHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *diagnosticHandler) Handle(_ context.Context, r slog.Record) error {
+	d := Diagnostic{Level: strings.ToLower(r.Level.String()), Code: kebabCase(r.Message), Message: r.Message}
+	var errs []error
+	add := func(a slog.Attr) bool {
+		switch v := a.Value.Resolve(); {
+		case a.Key == "func":
+			d.Function = v.String()
+		case a.Key == "file":
+			d.File = v.String()
+		case a.Key == "pos":
+			d.setPos(parsePosition(v.String()))
+		case a.Key == "err" && v.Kind() == slog.KindAny:
+			if err, ok := v.Any().(error); ok {
+				errs = append(errs, err)
+				break
+			}
+			fallthrough
+		default:
+			d.Message += fmt.Sprintf(" %s=%s", a.Key, v)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	for _, err := range errs {
+		d.setErr(err)
+	}
+	return h.w.write(d)
+}
+
+func (h *diagnosticHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &diagnosticHandler{w: h.w, level: h.level, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+// WithGroup returns h, the diagnostics are flat.
+func (h *diagnosticHandler) WithGroup(string) slog.Handler { return h }
+
+// kebabCase returns msg in lower case, its words separated by dashes.
+func kebabCase(msg string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "-")
+}
+
+// parsePosition parses the file:line:column form of token.Position.String.
+func parsePosition(s string) token.Position {
+	var pos token.Position
+	rest, col, ok := cutLast(s, ":")
+	if !ok {
+		return token.Position{Filename: s}
+	}
+	if rest, line, ok := cutLast(rest, ":"); ok {
+		pos.Filename = rest
+		pos.Line, _ = strconv.Atoi(line)
+		pos.Column, _ = strconv.Atoi(col)
+		return pos
+	}
+	pos.Filename = rest
+	pos.Line, _ = strconv.Atoi(col)
+	return pos
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,107 @@
+package mustgen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// directive is a parsed tag comment: //@gen_must[:] [newName] [key=value ...]
+type directive struct {
+	name    string
+	options map[string]string
+	// comment is the comment of the directive, nil for a target
+	comment *ast.Comment
+}
+
+// directiveTag is the tag of the directives, see Options.Tag. loose accepts their near misses too, see
+// Options.LooseDirectives.
+type directiveTag struct {
+	tag   string
+	loose bool
+}
+
+func (g *Gen) directiveTag() directiveTag {
+	return directiveTag{tag: g.opts.Tag, loose: g.opts.LooseDirectives}
+}
+
+// parse parses the text of a comment, ok is false if it isn't a directive for t.
+func (t directiveTag) parse(text string) (d directive, ok bool) {
+	if t.loose {
+		text = looseText(text, t.tag)
+	}
+	return parseDirective(text, t.tag)
+}
+
+// mayContain reports whether src may have a directive for t, without parsing it.
+func (t directiveTag) mayContain(src []byte) bool {
+	if t.loose {
+		return bytes.Contains(bytes.ToLower(src), bytes.ToLower([]byte(t.tag)))
+	}
+	return bytes.Contains(src, []byte("//"+t.tag))
+}
+
+// warnNearMisses logs the near misses of the directives of files, when they aren't read, see looseText.
+func (g *Gen) warnNearMisses(fset *token.FileSet, files []*ast.File) {
+	tag := g.directiveTag()
+	if tag.loose {
+		return
+	}
+	near := directiveTag{tag: tag.tag, loose: true}
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if _, ok := tag.parse(c.Text); ok {
+					continue
+				}
+				if _, ok := near.parse(c.Text); ok {
+					g.opts.Logger.Warn(ErrNearMissDirective.Error(), "comment", c.Text, "pos", fset.Position(c.Pos()).String())
+				}
+			}
+		}
+	}
+}
+
+// looseText returns the text of a comment written like a directive for tag when it's a near miss of one: with
+// spaces after //, a block comment (/*@gen_must*/), or a tag of another case (//@Gen_Must). Otherwise it returns
+// text.
+func looseText(text, tag string) string {
+	var body string
+	switch {
+	case strings.HasPrefix(text, "//"):
+		body = text[2:]
+	case strings.HasPrefix(text, "/*") && strings.HasSuffix(text, "*/"):
+		body = text[2 : len(text)-2]
+	default:
+		return text
+	}
+	body = strings.TrimLeftFunc(body, unicode.IsSpace)
+	if len(body) < len(tag) || !strings.EqualFold(body[:len(tag)], tag) {
+		return text
+	}
+	return "//" + tag + body[len(tag):]
+}
+
+// parseDirective parses the text of a comment, ok is false if it isn't a directive for tag.
+func parseDirective(text string, tag string) (d directive, ok bool) {
+	rest, ok := strings.CutPrefix(text, "//"+tag)
+	if !ok {
+		return d, false
+	}
+	if rest != "" && rest[0] != ':' && !unicode.IsSpace(rune(rest[0])) {
+		return d, false
+	}
+	for _, field := range strings.Fields(strings.TrimPrefix(rest, ":")) {
+		if k, v, isOpt := strings.Cut(field, "="); isOpt {
+			if d.options == nil {
+				d.options = make(map[string]string)
+			}
+			d.options[k] = v
+		} else if d.name == "" {
+			d.name = field
+		}
+	}
+	return d, true
+}
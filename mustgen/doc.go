@@ -0,0 +1,55 @@
+package mustgen
+
+import (
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// EmitDoc writes a markdown summary of the declarations written to files, grouped by the type they belong to,
+// linking each one to the wrapped function or type. docPath is the path of the markdown file, the links are relative
+// to it.
+func (g *Generator) EmitDoc(docPath string, files []PlanFile) error {
+	var groups []string
+	rows := make(map[string][]emittedDecl)
+	for _, f := range files {
+		decls, err := g.emittedDecls(f.Plan)
+		if err != nil {
+			return err
+		}
+		for _, d := range decls {
+			if _, ok := rows[d.group]; !ok {
+				groups = append(groups, d.group)
+			}
+			rows[d.group] = append(rows[d.group], d)
+		}
+	}
+	fmt.Fprintf(g, "# Must API of package %s\n", files[0].Plan.Package)
+	for _, group := range groups {
+		if group == "" {
+			fmt.Fprintf(g, "\n## Functions\n\n")
+		} else {
+			fmt.Fprintf(g, "\n## %s\n\n", group)
+		}
+		fmt.Fprintf(g, "| Declaration | Signature | Wraps |\n|---|---|---|\n")
+		for _, d := range rows[group] {
+			fmt.Fprintf(g, "| %s | `%s` | %s |\n", d.name, escapeCell(d.sig), docLink(d.fn, d.pos, docPath))
+		}
+	}
+	return nil
+}
+
+// docLink returns a markdown link to the function or type name, declared at pos.
+func docLink(name string, pos token.Position, docPath string) string {
+	if !pos.IsValid() {
+		return name
+	}
+	target, err := filepath.Rel(filepath.Dir(docPath), pos.Filename)
+	if err != nil || !filepath.IsAbs(pos.Filename) {
+		target = pos.Filename
+	}
+	return fmt.Sprintf("[%s](%s#L%d)", name, filepath.ToSlash(target), pos.Line)
+}
+
+func escapeCell(s string) string { return strings.ReplaceAll(s, "|", `\|`) }
@@ -0,0 +1,51 @@
+package mustgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FactoryType is the type generated by the Factory option.
+const FactoryType = "MustFactory"
+
+// factoryFuncs returns the wrappers of plan turned into methods of FactoryType: the ones of the functions named
+// New..., except the methods and the generic functions, which can't be methods.
+func factoryFuncs(plan *Plan) []*FuncSpec {
+	var funcs []*FuncSpec
+	for _, w := range plan.Funcs {
+		if strings.HasPrefix(w.Name, "New") && w.Recv == nil && len(w.TypeParams) == 0 {
+			funcs = append(funcs, w)
+		}
+	}
+	return funcs
+}
+
+// generateFactory writes FactoryType, with a method calling the wrapper of each constructor of plan.
+func (g *Generator) generateFactory(plan *Plan) error {
+	funcs := factoryFuncs(plan)
+	if len(funcs) == 0 {
+		return nil
+	}
+	fmt.Fprintf(g, "// %s calls the constructors of the package, panicking on error.\ntype %s struct{}\n\n", FactoryType, FactoryType)
+	for _, w := range funcs {
+		v, err := newWrapperView(w)
+		if err != nil {
+			return err
+		}
+		_, paramsUse := joinFields(w.Params)
+		call := fmt.Sprintf("%s(%s)", w.NewName, paramsUse)
+		if len(v.ResultTypes) > 0 {
+			call = "return " + call
+		}
+		fmt.Fprintf(g, "// %s calls %s, it panics on error\nfunc (%s) %s(%s) (%s) {\n%s\n}\n\n",
+			w.Name,
+			w.NewName,
+			FactoryType,
+			w.Name,
+			v.ParamsDecl,
+			strings.Join(v.ResultTypes, ","),
+			call,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package mustgen
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files is the file system the library reads from and writes to. The zero value uses the OS file system.
+// Packages are still loaded by go/packages from the OS file system.
+type Files struct {
+	// FS is read instead of the OS file system when not nil. Absolute paths are looked up without the leading slash
+	FS fs.FS
+	// WriteFile replaces os.WriteFile when not nil
+	WriteFile func(name string, data []byte, perm fs.FileMode) error
+}
+
+func fsPath(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "/")
+}
+
+func (f Files) Open(name string) (fs.File, error) {
+	if f.FS == nil {
+		return os.Open(name)
+	}
+	return f.FS.Open(fsPath(name))
+}
+
+func (f Files) ReadFile(name string) ([]byte, error) {
+	if f.FS == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(f.FS, fsPath(name))
+}
+
+// Write writes data to the file name, creating its directory if needed when using the OS file system. A file of
+// the OS file system already holding data isn't written, its modification time is kept.
+func (f Files) Write(name string, data []byte, perm fs.FileMode) error {
+	if f.WriteFile != nil {
+		return f.WriteFile(name, data, perm)
+	}
+	if current, err := os.ReadFile(name); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, perm)
+}
+
+// Create returns a writer of the file name, creating its directory if needed when using the OS file system.
+// With a WriteFile func the content is written when the writer is closed. With the OS file system it's written to
+// a temporary file of the directory, renamed to name when the writer is closed: like Write, an unchanged file isn't
+// written, keeping its modification time. discardFile drops what was written instead, eg: when the generation
+// fails, leaving the file as it was.
+func (f Files) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if f.WriteFile != nil {
+		return &bufferedFile{name: name, perm: perm, writeFile: f.WriteFile}, nil
+	}
+	if current, err := os.Open(name); err == nil {
+		if info, err := current.Stat(); err == nil {
+			perm = info.Mode().Perm()
+		}
+		return &syncedFile{name: name, perm: perm, current: current, r: bufio.NewReader(current)}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	return &syncedFile{name: name, perm: perm}, nil
+}
+
+// writeWith writes the file name with write, through Create. The file is left as it was if write fails.
+func (f Files) writeWith(name string, write func(w io.Writer) error) error {
+	w, err := f.Create(name, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = write(w); err != nil {
+		discardFile(w)
+		return err
+	}
+	return w.Close()
+}
+
+// discardFile drops what was written to w, a writer of Files.Create, and closes it.
+func discardFile(w io.WriteCloser) {
+	if d, ok := w.(interface{ discard() }); ok {
+		d.discard()
+		return
+	}
+	w.Close()
+}
+
+type bufferedFile struct {
+	bytes.Buffer
+	name      string
+	perm      fs.FileMode
+	writeFile func(name string, data []byte, perm fs.FileMode) error
+}
+
+func (f *bufferedFile) Close() error { return f.writeFile(f.name, f.Bytes(), f.perm) }
+
+func (f *bufferedFile) discard() {}
+
+// syncedFile compares what is written with the content of the existing file, if any, and writes it to a temporary
+// file from the first difference, renamed to the file when closed: an unchanged file isn't written at all.
+type syncedFile struct {
+	name    string
+	perm    fs.FileMode
+	current *os.File
+	r       *bufio.Reader
+	// n is the length of the common prefix, tmp the temporary file once they differ
+	n   int64
+	tmp *os.File
+	buf []byte
+}
+
+func (f *syncedFile) Write(p []byte) (int, error) {
+	if f.tmp == nil && f.r != nil {
+		if cap(f.buf) < len(p) {
+			f.buf = make([]byte, len(p))
+		}
+		read, _ := io.ReadFull(f.r, f.buf[:len(p)])
+		if read == len(p) && bytes.Equal(f.buf[:read], p) {
+			f.n += int64(read)
+			return len(p), nil
+		}
+	}
+	if f.tmp == nil {
+		if err := f.createTemp(); err != nil {
+			return 0, err
+		}
+	}
+	return f.tmp.Write(p)
+}
+
+// createTemp creates the temporary file, holding the common prefix.
+func (f *syncedFile) createTemp() error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.name), "."+filepath.Base(f.name)+".*")
+	if err != nil {
+		return err
+	}
+	f.tmp = tmp
+	if f.n > 0 {
+		if _, err = io.Copy(tmp, io.NewSectionReader(f.current, 0, f.n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *syncedFile) Close() error {
+	if f.tmp == nil {
+		if f.current != nil {
+			// the content is the same, unless the file is longer
+			_, err := f.r.ReadByte()
+			switch {
+			case errors.Is(err, io.EOF):
+				return f.current.Close()
+			case err != nil:
+				f.current.Close()
+				return err
+			}
+		}
+		if err := f.createTemp(); err != nil {
+			f.discard()
+			return err
+		}
+	}
+	if f.current != nil {
+		f.current.Close()
+	}
+	err := f.tmp.Chmod(f.perm)
+	if closeErr := f.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.tmp.Name(), f.name)
+	}
+	if err != nil {
+		os.Remove(f.tmp.Name())
+	}
+	return err
+}
+
+func (f *syncedFile) discard() {
+	if f.current != nil {
+		f.current.Close()
+	}
+	if f.tmp != nil {
+		f.tmp.Close()
+		os.Remove(f.tmp.Name())
+	}
+}
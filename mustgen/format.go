@@ -0,0 +1,78 @@
+package mustgen
+
+import (
+	"errors"
+	"go/format"
+	"io"
+	"sort"
+
+	"golang.org/x/tools/imports"
+	gofumpt "mvdan.cc/gofumpt/format"
+)
+
+// Formatter formats src, the generated code to be written to filename.
+type Formatter func(filename string, src []byte) ([]byte, error)
+
+var ErrUnknownFormatter = errors.New("unknown formatter")
+
+var formatters = map[string]Formatter{
+	"gofmt":     goFmt,
+	"goimports": goImports,
+	"gofumpt":   goFumpt,
+}
+
+// RegisterFormatter makes a formatter available by name, replacing any formatter with the same name.
+func RegisterFormatter(name string, f Formatter) { formatters[name] = f }
+
+// Formatters returns the names of the available formatters.
+func Formatters() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Format formats src with the formatter called name and writes the result to dst.
+func Format(name string, filename string, src io.Reader, dst io.Writer) error {
+	f, ok := formatters[name]
+	if !ok {
+		return ErrUnknownFormatter
+	}
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	if b, err = f(filename, b); err != nil {
+		return err
+	}
+	_, err = dst.Write(b)
+	return err
+}
+
+func GoFmt(src io.Reader, dst io.Writer) error { return Format("gofmt", "", src, dst) }
+
+// GoImports formats src like GoFmt, also adding missing and removing unused imports, like goimports does.
+// filename is the path of the output file, imports are resolved from its directory.
+func GoImports(filename string, src io.Reader, dst io.Writer) error {
+	return Format("goimports", filename, src, dst)
+}
+
+func goFmt(_ string, src []byte) ([]byte, error) { return format.Source(src) }
+
+func goImports(filename string, src []byte) ([]byte, error) {
+	return imports.Process(filename, src, &imports.Options{
+		Comments:  true,
+		TabIndent: true,
+		TabWidth:  8,
+	})
+}
+
+func goFumpt(filename string, src []byte) ([]byte, error) {
+	b, err := goImports(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return gofumpt.Source(b, gofumpt.Options{})
+}
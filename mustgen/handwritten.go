@@ -0,0 +1,146 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var ErrWrapperMismatch = errors.New("hand-written wrapper doesn't match the wrapped function")
+
+// handWritten indexes the functions declared outside of the code generated by gen_must,
+// to find the wrappers already written by hand.
+type handWritten struct {
+	pkg   *packages.Package
+	decls map[string]*ast.FuncDecl
+}
+
+func newHandWritten(pkg *packages.Package) *handWritten {
+	h := &handWritten{pkg: pkg, decls: make(map[string]*ast.FuncDecl)}
+	for _, file := range pkg.Syntax {
+		if isGeneratedSyntax(file) {
+			continue
+		}
+		regions := generatedRegions(file)
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || inRegions(regions, fd.Pos()) {
+				continue
+			}
+			h.decls[funcKey(recvName(fd.Recv), fd.Name.Name)] = fd
+		}
+	}
+	return h
+}
+
+// isGeneratedSyntax is IsGenerated for a parsed file.
+func isGeneratedSyntax(file *ast.File) bool {
+	var header strings.Builder
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			header.WriteString(c.Text + "\n")
+		}
+	}
+	gen, _ := IsGenerated(strings.NewReader(header.String()))
+	return gen
+}
+
+// generatedRegions returns the bounds of the regions between RegionBegin and RegionEnd.
+func generatedRegions(file *ast.File) [][2]token.Pos {
+	var regions [][2]token.Pos
+	begin := token.NoPos
+	for _, cg := range file.Comments {
+		for _, c := range cg.List {
+			switch {
+			case c.Text == RegionBegin:
+				begin = c.Pos()
+			case c.Text == RegionEnd && begin.IsValid():
+				regions = append(regions, [2]token.Pos{begin, c.End()})
+				begin = token.NoPos
+			}
+		}
+	}
+	return regions
+}
+
+func inRegions(regions [][2]token.Pos, pos token.Pos) bool {
+	for _, r := range regions {
+		if pos >= r[0] && pos <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func funcKey(recv, name string) string { return recv + "." + name }
+
+// recvName returns the name of the receiver type, without pointer and type parameters.
+func recvName(recv *ast.FieldList) string {
+	if recv == nil || len(recv.List) == 0 {
+		return ""
+	}
+	typ := recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// check reports whether the wrapper w of fnDecl was written by hand. It returns an error if its signature isn't the
+// one gen_must would generate.
+func (h *handWritten) check(fnDecl *ast.FuncDecl, w *FuncSpec) (bool, error) {
+	var recv string
+	if w.isMethod() {
+		recv = w.recvTypeName()
+	}
+	newName := w.NewName
+	wrapper := h.decls[funcKey(recv, newName)]
+	if wrapper == nil {
+		return false, nil
+	}
+	if h.pkg.TypesInfo == nil {
+		return true, nil
+	}
+	orig, ok1 := h.pkg.TypesInfo.Defs[fnDecl.Name].(*types.Func)
+	hand, ok2 := h.pkg.TypesInfo.Defs[wrapper.Name].(*types.Func)
+	if !ok1 || !ok2 {
+		return true, nil
+	}
+	origSig, handSig := orig.Type().(*types.Signature), hand.Type().(*types.Signature)
+	params := origSig.Params()
+	if w.RecvParam && origSig.Recv() != nil {
+		vars := []*types.Var{origSig.Recv()}
+		for i := 0; i < params.Len(); i++ {
+			vars = append(vars, params.At(i))
+		}
+		params = types.NewTuple(vars...)
+	}
+	pkg := h.pkg.Types
+	want := TupleString(pkg, params, origSig.Variadic()) + " " + TupleString(pkg, WrapperResults(origSig), false)
+	got := TupleString(pkg, handSig.Params(), handSig.Variadic()) + " " + TupleString(pkg, handSig.Results(), false)
+	if want != got {
+		return true, &PosError{
+			Pos:  h.pkg.Fset.Position(wrapper.Pos()),
+			Func: fnDecl.Name.Name,
+			Err:  fmt.Errorf("%w: %s: want func%s, got func%s", ErrWrapperMismatch, newName, want, got),
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package mustgen
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const digestPrefix = "// gen_must:digest"
+
+// generateCommand is the command of the //go:generate directive written in the header.
+const generateCommand = "go run github.com/heliorosa/gen_must"
+
+// DefaultMarker is the template of the marker line(s) written before the package clause.
+const DefaultMarker = "// Code generated - DO NOT EDIT.\n" +
+	generatedBy + "{{with .Version}} {{.}}{{end}} and any manual changes will be lost."
+
+var (
+	ErrInvalidMarker = errors.New(`marker must contain a line matching "^// Code generated .* DO NOT EDIT\.$"`)
+
+	generatedMarkerRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+)
+
+type markerData struct {
+	Package string
+	Version string
+}
+
+func (g *Generator) marker(pkgName string) (string, error) {
+	text := g.Marker
+	if text == "" {
+		text = DefaultMarker
+	}
+	tmpl, err := template.New("marker").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, markerData{Package: pkgName, Version: g.Version}); err != nil {
+		return "", err
+	}
+	marker := strings.TrimSpace(buf.String())
+	for _, line := range strings.Split(marker, "\n") {
+		if generatedMarkerRe.MatchString(line) {
+			return marker, nil
+		}
+	}
+	return "", ErrInvalidMarker
+}
+
+// commentBlock turns text into a line comment block, unless it's already a comment.
+func commentBlock(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "//") || strings.HasPrefix(text, "/*") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace("// " + line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReadDigest returns the source digest stamped in the header of the go source read from r,
+// or "" if it doesn't have one.
+func ReadDigest(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if strings.HasPrefix(line, digestPrefix+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, digestPrefix)), nil
+		}
+	}
+	return "", scanner.Err()
+}
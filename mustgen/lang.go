@@ -0,0 +1,98 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	ErrInvalidLang     = errors.New("invalid go version, expected eg: go1.17")
+	ErrLanguageVersion = errors.New("not supported by the go version of the output")
+)
+
+// langMinor returns the minor version of lang, a go version like go1.17 or go1.17.3.
+func langMinor(lang string) (int, bool) {
+	rest, ok := strings.CutPrefix(lang, "go1.")
+	if !ok {
+		return 0, false
+	}
+	if i := strings.IndexByte(rest, '.'); i != -1 {
+		if _, err := strconv.Atoi(rest[i+1:]); err != nil {
+			return 0, false
+		}
+		rest = rest[:i]
+	}
+	minor, err := strconv.Atoi(rest)
+	return minor, err == nil && minor >= 0
+}
+
+// ValidLang reports whether lang is a go version accepted by Options.Lang, eg: go1.17.
+func ValidLang(lang string) bool {
+	_, ok := langMinor(lang)
+	return ok
+}
+
+// checkLang returns an error when the wrapper w needs a newer go version than lang: generics need go1.18, the once
+// variant sync.OnceValues (go1.21) and the iterators the iter package (go1.23). Any version is fine when lang is
+// empty.
+func (w *FuncSpec) checkLang(lang string) error {
+	if lang == "" {
+		return nil
+	}
+	minor, ok := langMinor(lang)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidLang, lang)
+	}
+	var feature string
+	var need int
+	switch {
+	case len(w.TypeParams) > 0 || w.Recv != nil && strings.Contains(w.Recv.Type, "["):
+		feature, need = "generic functions", 18
+	case w.Iter:
+		feature, need = "iterators", 23
+	case w.variant() == VariantOnce:
+		feature, need = "the once variant", 21
+	default:
+		return nil
+	}
+	if minor >= need {
+		return nil
+	}
+	return fmt.Errorf("%w: %s need go1.%d, the output targets %s", ErrLanguageVersion, feature, need, lang)
+}
+
+// lang returns the go version of the output for pkg: Lang, or the go version of the module of pkg, from the go
+// directive of its go.mod, auto is then true. It's empty when unknown.
+func (g *Gen) lang(pkg *packages.Package) (lang string, auto bool) {
+	if g.opts.Lang != "" {
+		return g.opts.Lang, false
+	}
+	if pkg.Module != nil && ValidLang("go"+pkg.Module.GoVersion) {
+		return "go" + pkg.Module.GoVersion, true
+	}
+	return "", false
+}
+
+// fitLang returns the error of checkLang for the wrapper w. When lang is the version of the module (auto) the
+// features it lacks are disabled with a warning instead: the once variant falls back to the default one, the
+// other wrappers are skipped.
+func (g *Gen) fitLang(w *FuncSpec, lang string, auto bool) (skip bool, err error) {
+	if err = w.checkLang(lang); err == nil || !auto {
+		return false, err
+	}
+	if w.variant() == VariantOnce {
+		w.Options = maps.Clone(w.Options)
+		delete(w.Options, "variant")
+		if w.checkLang(lang) == nil {
+			g.opts.Logger.Warn("variant not available at the go version of the module", "func", w.Name, "variant", VariantOnce, "go", lang, "pos", w.Pos.String())
+			return false, nil
+		}
+	}
+	g.opts.Logger.Warn("function not wrapped", "func", w.Name, "err", err, "pos", w.Pos.String())
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package mustgen
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// layouts of the generated code
+const (
+	// LayoutPackage writes the wrappers in the package of the wrapped functions
+	LayoutPackage = "package"
+	// LayoutInternal writes the wrappers in a package of their own, internal/must/<path> in the module of the
+	// wrapped functions, importing them: the wrappers can't be imported outside of the module
+	LayoutInternal = "internal"
+	// LayoutReceiver writes the wrappers in the package of the wrapped functions, the ones of the methods of each
+	// type, and its decorator, to a file of their own: the output file name prefixed by the type name in lower case,
+	// eg: client_must.go. The other wrappers stay in the output file
+	LayoutReceiver = "receiver"
+)
+
+var ErrNoModule = errors.New("the package doesn't belong to a module")
+
+// Layouts returns the names of the layouts.
+func Layouts() []string { return []string{LayoutPackage, LayoutInternal, LayoutReceiver} }
+
+// InternalDir returns the directory of the wrappers of the package matching patterns with LayoutInternal:
+// internal/must/<path> in its module, path being the import path of the package relative to the module, or its
+// name for the root package of the module.
+func InternalDir(ctx context.Context, patterns []string, buildFlags ...string) (string, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{Context: ctx, Mode: packages.NeedName | packages.NeedModule, BuildFlags: buildFlags},
+		patterns...,
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(pkgs) != 1 {
+		return "", ErrNoPackageFound
+	}
+	pkg := pkgs[0]
+	if pkg.Module == nil || pkg.Module.Dir == "" {
+		return "", ErrNoModule
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(pkg.PkgPath, pkg.Module.Path), "/")
+	if rel == "" {
+		rel = pkg.Name
+	}
+	return filepath.Join(pkg.Module.Dir, "internal", "must", filepath.FromSlash(rel)), nil
+}
+
+// PlanFile is the plan of an output file.
+type PlanFile struct {
+	Path string
+	Plan *Plan
+}
+
+// ReceiverFiles splits plan, written to outPath, in the files of LayoutReceiver: outPath first, then the files of
+// the receiver types sorted by path. Each plan only keeps the imports its wrappers use.
+func ReceiverFiles(plan *Plan, outPath string) []PlanFile {
+	main := &Plan{Package: plan.Package, Digest: plan.Digest, Funcs: []*FuncSpec{}, Reexports: plan.Reexports}
+	parts := make(map[string]*Plan)
+	part := func(recv string) *Plan {
+		name := filepath.Join(filepath.Dir(outPath), strings.ToLower(recv)+"_"+filepath.Base(outPath))
+		p, ok := parts[name]
+		if !ok {
+			p = &Plan{Package: plan.Package, Digest: plan.Digest, Funcs: []*FuncSpec{}, Part: recv}
+			parts[name] = p
+			main.Parts = append(main.Parts, recv)
+		}
+		return p
+	}
+	for _, w := range plan.Funcs {
+		if recv := w.recvTypeName(); recv != "" {
+			p := part(recv)
+			p.Funcs = append(p.Funcs, w)
+			continue
+		}
+		main.Funcs = append(main.Funcs, w)
+	}
+	for _, d := range plan.Decorators {
+		p := part(d.Type)
+		p.Decorators = append(p.Decorators, d)
+	}
+	sort.Strings(main.Parts)
+	files := []PlanFile{{Path: outPath, Plan: main}}
+	for name, p := range parts {
+		files = append(files, PlanFile{Path: name, Plan: p})
+	}
+	sort.Slice(files[1:], func(i, j int) bool { return files[i+1].Path < files[j+1].Path })
+	for _, f := range files {
+		f.Plan.Imports = usedImports(plan.Imports, f.Plan)
+	}
+	return files
+}
+
+// usedImports returns the imports of the list referred to by the types of the wrappers of plan, or by their
+// package qualifier.
+func usedImports(imports []Import, plan *Plan) []Import {
+	var names []string
+	add := func(w *FuncSpec) {
+		names = append(names, w.Pkg)
+		types := append(fieldTypes(w.TypeParams), fieldTypes(w.Params)...)
+		if w.Recv != nil {
+			types = append(types, w.Recv.Type)
+		}
+		for _, typ := range append(types, w.Results...) {
+			names = append(names, typeIdents(typ)...)
+		}
+	}
+	for _, w := range plan.Funcs {
+		add(w)
+	}
+	for _, d := range plan.Decorators {
+		for _, w := range d.Methods {
+			add(w)
+		}
+	}
+	for _, r := range plan.Reexports {
+		names = append(names, r.Pkg)
+	}
+	var used []Import
+	for _, imp := range imports {
+		if name := importName(imp); name == "_" || name == "." || slices.Contains(names, name) {
+			used = append(used, imp)
+		}
+	}
+	return used
+}
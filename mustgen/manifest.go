@@ -0,0 +1,174 @@
+package mustgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+)
+
+// Variants of the wrappers, set by the variant option of the directive: VariantMust panics on error, VariantOnce
+// also calls the wrapped function once, the first time it's called.
+const (
+	VariantMust = "must"
+	VariantOnce = "once"
+)
+
+// Kinds of the declarations of the output, see ManifestEntry.
+const (
+	// KindWrapper is a wrapper, a function or a method
+	KindWrapper = "wrapper"
+	// KindAll is the slice helper of a wrapper, added by the all option of the directive
+	KindAll = "all"
+	// KindDecorator is the decorator of a struct, its Must method returning it, and its methods
+	KindDecorator = "decorator"
+	// KindFactory is FactoryType and its methods
+	KindFactory = "factory"
+)
+
+// Manifest describes the generated wrappers, for tools consuming them (eg: doc generators, API diff tools).
+type Manifest struct {
+	Package  string          `json:"package"`
+	Wrappers []ManifestEntry `json:"wrappers"`
+}
+
+// ManifestEntry describes a declaration of the output.
+type ManifestEntry struct {
+	// Kind is the kind of the declaration, Func the wrapped function (eg: Client.Get for a method) or type
+	Kind string `json:"kind"`
+	Func string `json:"func,omitempty"`
+	// Wrapper is the declared name, Recv the type of its receiver when it's a method, Variant the kind of the
+	// wrapper
+	Wrapper string `json:"wrapper"`
+	Recv    string `json:"recv,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	// File is the file the declaration is written to, empty for stdout
+	File string `json:"file,omitempty"`
+	// Pos is the position of the wrapped function or type
+	Pos token.Position `json:"pos"`
+}
+
+// Manifest returns the manifest of the declarations written by g for plan to file.
+func (g *Generator) Manifest(plan *Plan, file string) (*Manifest, error) {
+	decls, err := g.emittedDecls(plan)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Package: plan.Package, Wrappers: make([]ManifestEntry, 0, len(decls))}
+	for _, d := range decls {
+		m.Wrappers = append(m.Wrappers, ManifestEntry{
+			Kind:    d.kind,
+			Func:    d.fn,
+			Wrapper: d.name,
+			Recv:    d.recv,
+			Variant: d.variant,
+			File:    file,
+			Pos:     d.pos,
+		})
+	}
+	return m, nil
+}
+
+func (m *Manifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
+
+// emittedDecl is a declaration of the output, see emittedDecls.
+type emittedDecl struct {
+	kind string
+	// name is the declared name, recv the type of its receiver when it's a method, group the type it belongs to
+	name, recv, group string
+	// sig is the declaration without body, eg: func (t *T) MustGet(id int) string
+	sig string
+	// fn is the wrapped function or type, pos its position
+	fn      string
+	pos     token.Position
+	variant string
+}
+
+// emittedDecls returns the declarations written by g for plan, in their order: the wrappers and their slice
+// helpers, the decorators and the factory.
+func (g *Generator) emittedDecls(plan *Plan) ([]emittedDecl, error) {
+	var decls []emittedDecl
+	for _, w := range plan.Funcs {
+		fn := w.Name
+		if w.recvOption() == "" && w.Recv != nil {
+			fn = w.recvTypeName() + "." + w.Name
+		}
+		wrapper, err := wrapperDecls(w, fn)
+		if err != nil {
+			return nil, err
+		}
+		decls = append(decls, wrapper...)
+	}
+	for _, d := range plan.Decorators {
+		decls = append(decls,
+			emittedDecl{kind: KindDecorator, name: d.NewName, group: d.NewName, sig: fmt.Sprintf("type %s struct{ *%s }", d.NewName, d.Type), fn: d.Type, pos: d.Pos},
+			emittedDecl{kind: KindDecorator, name: "Must", recv: "*" + d.Type, group: d.NewName, sig: fmt.Sprintf("func (v *%s) Must() %s", d.Type, d.NewName), fn: d.Type, pos: d.Pos},
+		)
+		for _, w := range d.Methods {
+			methods, err := wrapperDecls(w, w.Name)
+			if err != nil {
+				return nil, err
+			}
+			decls = append(decls, methods...)
+		}
+	}
+	if funcs := factoryFuncs(plan); g.Factory && len(funcs) > 0 {
+		decls = append(decls, emittedDecl{kind: KindFactory, name: FactoryType, group: FactoryType, sig: fmt.Sprintf("type %s struct{}", FactoryType)})
+		for _, w := range funcs {
+			v, err := newWrapperView(w)
+			if err != nil {
+				return nil, err
+			}
+			decl, err := funcDecl(w, w.Name, w.Params, v.ResultTypes)
+			if err != nil {
+				return nil, &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+			}
+			decl.Recv = &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent(FactoryType)}}}
+			decls = append(decls, emittedDecl{kind: KindFactory, name: w.Name, recv: FactoryType, group: FactoryType, sig: declSignature(decl), fn: w.Name, pos: w.Pos, variant: w.variant()})
+		}
+	}
+	return decls, nil
+}
+
+// wrapperDecls returns the declarations of the wrapper w of the function fn: the wrapper and its slice helper.
+func wrapperDecls(w *FuncSpec, fn string) ([]emittedDecl, error) {
+	w = renameReserved(w)
+	v, err := newWrapperView(w)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := emittedDecl{kind: KindWrapper, name: w.NewName, fn: fn, pos: w.Pos, variant: w.variant()}
+	if w.isMethod() {
+		wrapper.recv, wrapper.group = w.Recv.Type, w.recvTypeName()
+	}
+	decl, err := funcDecl(w, w.NewName, w.wrapperParams(), v.ResultTypes)
+	if err != nil {
+		return nil, &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
+	wrapper.sig = declSignature(decl)
+	decls := []emittedDecl{wrapper}
+	if name := w.allName(); name != "" {
+		params := w.wrapperParams()
+		param := params[len(params)-1]
+		decl, err = funcDecl(w, name, append(params[:len(params)-1:len(params)-1], Field{Name: param.Name, Type: "[]" + param.Type}),
+			[]string{"[]" + v.ResultTypes[0]})
+		if err != nil {
+			return nil, &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+		}
+		all := wrapper
+		all.kind, all.name, all.sig = KindAll, name, declSignature(decl)
+		decls = append(decls, all)
+	}
+	return decls, nil
+}
+
+// declSignature returns the signature of decl, without its body.
+func declSignature(decl *ast.FuncDecl) string {
+	decl.Body = nil
+	return nodeString(decl)
+}
@@ -0,0 +1,108 @@
+package mustgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Delimiters of the region managed by gen_must in a merged file.
+const (
+	RegionBegin = "// gen_must:begin - code up to gen_must:end is generated, DO NOT EDIT."
+	RegionEnd   = "// gen_must:end"
+)
+
+var ErrBadRegion = errors.New("gen_must:begin without a matching gen_must:end")
+
+// EmitWrappers writes the wrappers and the decorators of plan, and the factory when enabled, without header,
+// package clause or imports.
+func (g *Generator) EmitWrappers(plan *Plan) error {
+	g.planImports = plan.Imports
+	for _, w := range plan.Funcs {
+		if err := g.GenerateWrapper(w); err != nil {
+			return err
+		}
+	}
+	for _, d := range plan.Decorators {
+		if err := g.generateDecorator(d); err != nil {
+			return err
+		}
+	}
+	if g.Factory {
+		return g.generateFactory(plan)
+	}
+	return nil
+}
+
+// Merge writes src with the region between RegionBegin and RegionEnd replaced by the wrappers of plan,
+// leaving the rest of the file untouched. The region is appended if src doesn't have one,
+// and the imports required by plan are added.
+func (g *Generator) Merge(src []byte, plan *Plan) error {
+	if err := g.runPlugins(plan); err != nil {
+		return err
+	}
+	region := &bytes.Buffer{}
+	fmt.Fprintf(region, "%s\n\n", RegionBegin)
+	rg := *g
+	rg.Writer = region
+	// the directives would shift the lines of the code following the region
+	rg.LineDirectives = false
+	rg.generateSupport(plan)
+	if err := rg.generateReexports(plan); err != nil {
+		return err
+	}
+	if err := rg.EmitWrappers(plan); err != nil {
+		return err
+	}
+	fmt.Fprintf(region, "%s\n", RegionEnd)
+
+	lines := strings.SplitAfter(string(src), "\n")
+	begin, end := -1, -1
+	for i, line := range lines {
+		switch l := strings.TrimSpace(line); {
+		case begin == -1 && strings.HasPrefix(l, "// gen_must:begin"):
+			begin = i
+		case begin != -1 && l == RegionEnd:
+			end = i
+		}
+		if end != -1 {
+			break
+		}
+	}
+	merged := &bytes.Buffer{}
+	switch {
+	case begin == -1:
+		merged.Write(src)
+		if len(src) > 0 && !bytes.HasSuffix(src, []byte("\n")) {
+			merged.WriteString("\n")
+		}
+		merged.WriteString("\n")
+		merged.Write(region.Bytes())
+	case end == -1:
+		return ErrBadRegion
+	default:
+		merged.WriteString(strings.Join(lines[:begin], ""))
+		merged.Write(region.Bytes())
+		merged.WriteString(strings.Join(lines[end+1:], ""))
+	}
+	imports := g.imports(plan)
+	if len(imports) == 0 {
+		_, err := g.Write(merged.Bytes())
+		return err
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", merged.Bytes(), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	for _, imp := range imports {
+		astutil.AddNamedImport(fset, file, imp.Name, imp.Path)
+	}
+	return format.Node(g, fset, file)
+}
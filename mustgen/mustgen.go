@@ -1,13 +1,19 @@
 package mustgen
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
+	"go/parser"
+	"go/token"
 	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"text/template"
 
 	"golang.org/x/tools/go/packages"
 )
@@ -17,18 +23,88 @@ var (
 	ErrUnknownFieldType = errors.New("unknown field type")
 	ErrNoReturnValues   = errors.New("no return values")
 	ErrNoErrorReturn    = errors.New("no error returned")
+	ErrNotExported      = errors.New("not exported, can't be used outside of its package")
+	ErrForeignReceiver  = errors.New("methods of generic types and decorators can't be generated outside of their package")
+	ErrUnknownParam     = errors.New("unknown parameter")
+	ErrUnknownVariant   = errors.New("unknown variant")
+	ErrInvalidName      = errors.New("invalid wrapper name")
+	ErrOnceVariant      = errors.New("only functions without parameters, returning a value and an error or an error, can be called once")
+	ErrAllOption        = errors.New("all= needs a function with a single parameter, returning a value and an error")
+	ErrCgoType          = errors.New("cgo types can't be used outside of the files importing C")
+	ErrRecvOption       = errors.New("recv= needs a function without type parameters, and a type of the output package like T or *T")
+	ErrImportConflict   = errors.New("an import name refers to different packages in the files of the wrapped functions")
+	ErrDotImport        = errors.New("identifiers of dot-imports can't be resolved without the type information of the package")
 )
 
-func ParsePackage(patterns []string) (*packages.Package, error) {
+// isUnsupported tells whether err is about a signature gen_must can't wrap, rather than a mistake in a directive.
+func isUnsupported(err error) bool {
+	return errors.Is(err, ErrUnknownFieldType) || errors.Is(err, ErrNoReturnValues) || errors.Is(err, ErrNoErrorReturn) ||
+		errors.Is(err, ErrDotImport) || errors.Is(err, ErrLanguageVersion)
+}
+
+// PosError is an error found at Pos, while processing the function Func.
+type PosError struct {
+	Pos  token.Position
+	Func string
+	Err  error
+}
+
+func (e *PosError) Error() string {
+	if !e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Func, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Pos, e.Func, e.Err)
+}
+
+func (e *PosError) Unwrap() error { return e.Err }
+
+// ErrorList is the errors of the functions that can't be wrapped, returned by Plan with the KeepGoing option along
+// with the plan of the other ones. errors.Is and errors.As look into each of them.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, 0, len(l))
+	for _, err := range l {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (l ErrorList) Unwrap() []error { return l }
+
+// UnsupportedTypeError is returned, wrapped in a PosError, for a type expression gen_must can't render.
+// It matches ErrUnknownFieldType with errors.Is.
+type UnsupportedTypeError struct {
+	// Construct is the source of the type expression, eg: map[string]int
+	Construct string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnknownFieldType, e.Construct)
+}
+
+func (e *UnsupportedTypeError) Is(target error) bool { return target == ErrUnknownFieldType }
+
+// syntaxMode loads what is needed to plan the wrappers from the syntax alone, typesMode adds the type
+// information used by the type-aware checks.
+const (
+	syntaxMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedSyntax |
+		packages.NeedModule
+	typesMode = syntaxMode | packages.NeedTypes | packages.NeedTypesInfo
+)
+
+// ParsePackage loads the package matching patterns. buildFlags are passed to the build tool (eg: -tags=integration).
+func ParsePackage(ctx context.Context, patterns []string, buildFlags ...string) (*packages.Package, error) {
+	return loadPackage(ctx, typesMode, patterns, buildFlags)
+}
+
+func loadPackage(ctx context.Context, mode packages.LoadMode, patterns []string, buildFlags []string) (*packages.Package, error) {
 	pkgs, err := packages.Load(
 		&packages.Config{
-			Mode: packages.NeedName |
-				packages.NeedFiles |
-				packages.NeedCompiledGoFiles |
-				packages.NeedTypes |
-				packages.NeedSyntax |
-				packages.NeedTypesInfo,
-			Tests: false,
+			Context:    ctx,
+			Mode:       mode,
+			BuildFlags: buildFlags,
+			Tests:      false,
 		},
 		patterns...,
 	)
@@ -38,79 +114,173 @@ func ParsePackage(patterns []string) (*packages.Package, error) {
 	if len(pkgs) != 1 {
 		return nil, ErrNoPackageFound
 	}
+	if err = originalSyntax(pkgs[0]); err != nil {
+		return nil, err
+	}
 	return pkgs[0], nil
 }
 
-func GoFmt(src io.Reader, dst io.Writer) error {
-	b, err := io.ReadAll(src)
-	if err != nil {
-		return err
+// originalSyntax replaces the syntax of a package using cgo, parsed from the files rewritten by cgo, with the
+// syntax of its source files, so the directives, the positions and the types are the ones written by the user.
+// The type information of the rewritten files doesn't match the new syntax and is dropped.
+func originalSyntax(pkg *packages.Package) error {
+	cgo := slices.ContainsFunc(pkg.CompiledGoFiles, func(name string) bool { return !slices.Contains(pkg.GoFiles, name) })
+	if !cgo {
+		return nil
 	}
-	b, err = format.Source(b)
-	if err != nil {
-		return err
+	syntax := make([]*ast.File, 0, len(pkg.GoFiles))
+	for _, name := range pkg.GoFiles {
+		file, err := parser.ParseFile(pkg.Fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		syntax = append(syntax, file)
 	}
-	_, err = io.Copy(dst, bytes.NewReader(b))
-	return err
+	pkg.Syntax = syntax
+	pkg.TypesInfo = nil
+	return nil
 }
 
 func WalkPackage(pkg *packages.Package, tagComment string, genFn func(newName string, fnDecl *ast.FuncDecl) error) error {
-	for _, file := range pkg.Syntax {
-		var err error
-		ast.Inspect(file, func(n ast.Node) bool {
-			fn, ok := n.(*ast.FuncDecl)
-			if !ok {
-				return true
-			}
-			var firstComment *ast.Comment
-		Outer:
-			for _, i := range file.Comments {
-				for _, j := range i.List {
-					if j.Pos() >= fn.Body.Lbrace && j.Pos() <= fn.Body.Rbrace {
-						firstComment = j
-						break Outer
-					}
-				}
-			}
-			if firstComment == nil {
-				return true
+	return walkPackage(context.Background(), pkg, directiveTag{tag: tagComment}, mustName, nil, func(d *directive, fnDecl *ast.FuncDecl) error {
+		return genFn(d.name, fnDecl)
+	})
+}
+
+// found is a tagged function found by scanFile.
+type found struct {
+	d  directive
+	fn *ast.FuncDecl
+}
+
+// walkPackage calls genFn with each function of pkg tagged by a directive, or matching one of targets.
+func walkPackage(ctx context.Context, pkg *packages.Package, tag directiveTag, naming func(string) string, targets []Target, genFn func(d *directive, fnDecl *ast.FuncDecl) error) error {
+	// the files are scanned concurrently, genFn is then called in the order of the files
+	results := make([][]found, len(pkg.Syntax))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, file := range pkg.Syntax {
+		// the output of a previous run isn't scanned, its wrappers would be wrapped again
+		if isGeneratedSyntax(file) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, file *ast.File) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if ctx.Err() == nil {
+				results[i] = scanFile(file, tag, naming, targets)
 			}
-			var firstNode ast.Node
-			ast.Inspect(fn.Body, func(n ast.Node) bool {
-				if firstNode != nil {
-					return false
-				}
-				if n == nil || n == fn.Body {
-					return true
-				}
-				firstNode = n
-				return false
-			})
-			if firstNode.Pos() < firstComment.Pos() {
-				return true
+		}(i, file)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, res := range results {
+		for i := range res {
+			if err := genFn(&res[i].d, res[i].fn); err != nil {
+				return err
 			}
-			pref := "//" + tagComment
-			if !strings.HasPrefix(firstComment.Text, pref) {
-				return true
+		}
+	}
+	return nil
+}
+
+// scanFile returns the tagged functions of file, and the ones matching targets, in source order.
+func scanFile(file *ast.File, tag directiveTag, naming func(string) string, targets []Target) []found {
+	var res []found
+	regions := generatedRegions(file)
+	ast.Inspect(file, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if !ok {
+			return true
+		}
+		if inRegions(regions, fn.Pos()) {
+			return false
+		}
+		ds, _ := funcDirectives(file, fn, tag)
+		if len(ds) == 0 {
+			if d, ok := targetDirective(targets, fn); ok {
+				ds = append(ds, d)
 			}
-			newName := strings.TrimPrefix(firstComment.Text, pref)
-			if strings.HasPrefix(newName, ":") {
-				newName = strings.TrimSpace(newName[1:])
-			} else if newName == "" {
-				newName = mustName(fn.Name.Name)
+		}
+		// each of the stacked directives generates a wrapper
+		for _, d := range ds {
+			if d.name == "" {
+				d.name = naming(fn.Name.Name)
 			}
-			if err = genFn(newName, fn); err != nil {
-				return false
+			res = append(res, found{d: d, fn: fn})
+		}
+		return true
+	})
+	return res
+}
+
+// bodyComments returns the comments of file starting the body of fn, before its first statement.
+func bodyComments(file *ast.File, fn *ast.FuncDecl) []*ast.Comment {
+	if fn.Body == nil {
+		return nil
+	}
+	end := fn.Body.Rbrace
+	if len(fn.Body.List) > 0 {
+		end = fn.Body.List[0].Pos()
+	}
+	var comments []*ast.Comment
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			if c.Pos() > fn.Body.Lbrace && c.Pos() < end {
+				comments = append(comments, c)
 			}
-			return true
-		})
-		if err != nil {
-			return err
 		}
 	}
+	return comments
+}
+
+// bodyComment returns the first comment of the body of fn, where its directive is, nil if there is none.
+func bodyComment(file *ast.File, fn *ast.FuncDecl) *ast.Comment {
+	if comments := bodyComments(file, fn); len(comments) > 0 {
+		return comments[0]
+	}
 	return nil
 }
 
+// funcDirectives returns the directives of fn, with their comments: the comments starting its body, as long as
+// they are directives. They are stacked to generate several wrappers of fn, eg: one panicking and one called once.
+// A function without body (implemented in assembly or by //go:linkname) has them in its doc comment instead.
+func funcDirectives(file *ast.File, fn *ast.FuncDecl, tag directiveTag) ([]directive, []*ast.Comment) {
+	var (
+		ds       []directive
+		comments []*ast.Comment
+	)
+	if fn.Body == nil {
+		if fn.Doc == nil {
+			return nil, nil
+		}
+		for _, c := range fn.Doc.List {
+			if d, ok := tag.parse(c.Text); ok {
+				d.comment = c
+				ds = append(ds, d)
+				comments = append(comments, c)
+			}
+		}
+		return ds, comments
+	}
+	for _, c := range bodyComments(file, fn) {
+		d, ok := tag.parse(c.Text)
+		if !ok {
+			break
+		}
+		d.comment = c
+		ds = append(ds, d)
+		comments = append(comments, c)
+	}
+	return ds, comments
+}
+
 func mustName(name string) string {
 	f := name[:1]
 	if strings.ToUpper(f) == f {
@@ -119,191 +289,674 @@ func mustName(name string) string {
 	return "must" + strings.ToUpper(f) + name[1:]
 }
 
-type Generator struct{ io.Writer }
+type Generator struct {
+	io.Writer
+	// Version of gen_must stamped in the header, omitted when empty
+	Version string
+	// Header is written before the marker, eg: a license. It's turned into a comment if it isn't one
+	Header string
+	// Marker is the text/template of the "Code generated" marker, DefaultMarker when empty
+	Marker string
+	// GoGenerate are the arguments of gen_must written in a //go:generate directive after the marker, so the
+	// file can be generated again with go generate. It's omitted when empty
+	GoGenerate string
+	// Template is the text/template of each wrapper, executed with a WrapperView, when not empty
+	Template string
+	// LineDirectives writes a //line directive before each wrapper, pointing to the wrapped function,
+	// so that panics and debuggers refer to the original code
+	LineDirectives bool
+	// PanicArgs panics with an error describing the call, the name of the wrapper and its arguments,
+	// instead of the bare error
+	PanicArgs bool
+	// RedactTypes are the types of the parameters written as *** in the panic, like the parameters named by
+	// the redact=name,... option of the directive
+	RedactTypes []string
+	// Stack panics with an error carrying the stack of the failed call, exposed by its StackTrace() []uintptr
+	// method. The error type is declared in the output, only one output file of a package can use it
+	Stack bool
+	// MustError panics with a *MustError, holding the name of the wrapper and the error. Like with Stack the type
+	// is declared in the output
+	MustError bool
+	// Metrics calls the OnMustFailure hook, declared in the output, with the name of the wrapper and the error
+	// before panicking
+	Metrics bool
+	// Tracing calls the RecordMustError hook, declared in the output, with the context.Context parameter of the
+	// wrapper and the error before panicking, eg: to record the error on the span of the context
+	Tracing bool
+	// Factory writes a MustFactory type, with a method calling the wrapper of each constructor (New...)
+	Factory bool
+	// Lang is the go version of the output, eg: go1.17. The wrappers needing a newer version (generic functions,
+	// iterators, the once variant) are errors. Any version when empty
+	Lang string
+	// Plugins are the commands generating the wrappers of other variants than VariantMust and VariantOnce, by
+	// variant. A plugin is run for each wrapper of its variant: it reads a PluginRequest as JSON from its standard
+	// input and writes a PluginResponse to its standard output
+	Plugins map[string]string
 
-func NewGenerator(w io.Writer) *Generator { return &Generator{w} }
+	tmpl *template.Template
+	// plugged are the responses of the plugins, by wrapper
+	plugged map[*FuncSpec]*PluginResponse
+	// planImports are the imports of the plan being generated, they tell the packages of the types of the wrappers
+	planImports []Import
+}
+
+func NewGenerator(w io.Writer) *Generator { return &Generator{Writer: w} }
+
+// GenerateHead writes the header, the marker, the digest of the source (when not empty) and the package clause.
+func (g *Generator) GenerateHead(pkgName string, digest string) error {
+	return g.generateHead(pkgName, digest, "")
+}
 
-func (g *Generator) GenerateHead(pkgName string) {
-	fmt.Fprintf(g, "// Code generated - DO NOT EDIT.\n// This file is auto generated by gen_must and any manual changes will be lost.\n\n")
+func (g *Generator) generateHead(pkgName string, digest string, buildConstraint string) error {
+	marker, err := g.marker(pkgName)
+	if err != nil {
+		return err
+	}
+	if header := commentBlock(g.Header); header != "" {
+		fmt.Fprintf(g, "%s\n\n", header)
+	}
+	fmt.Fprintf(g, "%s\n", marker)
+	if digest != "" {
+		fmt.Fprintf(g, "%s %s\n", digestPrefix, digest)
+	}
+	if g.GoGenerate != "" {
+		fmt.Fprintf(g, "//go:generate %s %s\n", generateCommand, g.GoGenerate)
+	}
+	fmt.Fprintf(g, "\n")
+	if buildConstraint != "" {
+		fmt.Fprintf(g, "//go:build %s\n\n", buildConstraint)
+	}
 	fmt.Fprintf(g, "package %s\n\n", pkgName)
+	return nil
 }
 
-func (g *Generator) GenerateMust(newName string, fnDecl *ast.FuncDecl) error {
-	typeParamsDecl, typeParamsUse, err := generateTypeParams(fnDecl.Type.TypeParams)
+// imports returns the imports of plan and the ones needed by the code of g.
+func (g *Generator) imports(plan *Plan) []Import {
+	imports := plan.Imports
+	add := func(path string) {
+		if !slices.Contains(imports, Import{Path: path}) {
+			imports = append([]Import{{Path: path}}, imports...)
+		}
+	}
+	if g.PanicArgs && !plan.empty() {
+		add("fmt")
+	}
+	if g.Stack && plan.declaresSupport() {
+		add("runtime")
+	}
+	if g.Tracing && plan.declaresSupport() {
+		add("context")
+	}
+	if slices.ContainsFunc(plan.Funcs, func(w *FuncSpec) bool { return w.variant() == VariantOnce }) {
+		add("sync")
+	}
+	if slices.ContainsFunc(plan.Funcs, func(w *FuncSpec) bool { return w.Iter }) {
+		add("iter")
+	}
+	for _, w := range plan.Funcs {
+		if resp := g.plugged[w]; resp != nil {
+			for _, imp := range resp.Imports {
+				if !slices.Contains(imports, imp) {
+					imports = append(slices.Clip(imports), imp)
+				}
+			}
+		}
+	}
+	return imports
+}
+
+func (g *Generator) GenerateImports(imports []Import) {
+	if len(imports) == 0 {
+		return
+	}
+	fmt.Fprintf(g, "import (\n")
+	for _, imp := range imports {
+		fmt.Fprintf(g, "%s %q\n", imp.Name, imp.Path)
+	}
+	fmt.Fprintf(g, ")\n\n")
+}
+
+func (g *Generator) Emit(plan *Plan) error {
+	buildConstraint, err := planConstraint(plan)
 	if err != nil {
 		return err
 	}
-	recvDecl, recvUse, err := generateReceiver(fnDecl.Recv)
+	if err := g.runPlugins(plan); err != nil {
+		return err
+	}
+	if err := g.generateHead(plan.Package, plan.Digest, buildConstraint); err != nil {
+		return err
+	}
+	g.GenerateImports(g.imports(plan))
+	g.generateSupport(plan)
+	if err := g.generateReexports(plan); err != nil {
+		return err
+	}
+	return g.EmitWrappers(plan)
+}
+
+func (g *Generator) GenerateMust(newName string, fnDecl *ast.FuncDecl) error {
+	w, err := (&planner{fn: fnDecl}).planWrapper(&directive{name: newName})
 	if err != nil {
 		return err
 	}
-	paramsDecl, paramsUse, err := generateParams(fnDecl.Type.Params)
+	return g.GenerateWrapper(w)
+}
+
+func (g *Generator) GenerateWrapper(w *FuncSpec) error {
+	if err := w.check(pluginVariants(g.Plugins)...); err != nil {
+		return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
+	if err := w.checkLang(g.Lang); err != nil {
+		return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
+	if command, ok := g.Plugins[w.variant()]; ok {
+		return g.generatePlugin(w, command)
+	}
+	w = renameReserved(w)
+	v, err := newWrapperView(w)
 	if err != nil {
 		return err
 	}
-	retsDecl, retsVars, err := generateReturns(fnDecl.Type.Results)
+	call := wrappedCall(w)
+	if w.variant() == VariantOnce {
+		if err = printNode(g, onceDecl(w, v)); err != nil {
+			return err
+		}
+		fmt.Fprintf(g, "\n\n")
+		call = callExpr(ast.NewIdent(v.OnceVar))
+		v.Call = nodeString(call)
+	}
+	panicExpr := g.panicExpr(w, v)
+	v.Panic = nodeString(panicExpr)
+	onError := g.onError(w, v, panicExpr)
+	v.OnError = stmtsString(onError)
+	if g.LineDirectives && w.Pos.IsValid() {
+		v.LineDirective = fmt.Sprintf("//line %s:%d", filepath.Base(w.Pos.Filename), w.Pos.Line)
+	}
+	if g.Template != "" {
+		err = g.executeTemplate(v)
+	} else {
+		err = g.generateWrapper(w, v, call, onError)
+	}
 	if err != nil {
 		return err
 	}
+	return g.generateAll(w, v)
+}
+
+// generateWrapper writes the default code of the wrapper w, calling the wrapped function with call.
+func (g *Generator) generateWrapper(w *FuncSpec, v *WrapperView, call ast.Expr, onError []ast.Stmt) error {
+	decl, err := wrapperDecl(w, v, call, onError)
+	if err != nil {
+		return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+	}
 	fmt.Fprintf(g, "// %s has the behavior of %s, except it panics on error\n",
-		newName,
-		fnDecl.Name,
-	)
-	fmt.Fprintf(g, "func %s %s%s(%s) (%s) {\n",
-		recvDecl,
-		newName,
-		typeParamsDecl,
-		paramsDecl,
-		strings.Join(retsDecl[:len(retsDecl)-1], ","),
+		w.NewName,
+		w.Name,
 	)
-	fmt.Fprintf(g, "%s := %s%s%s(%s)\nif err!=nil{panic(err)}\n",
-		strings.Join(retsVars, ","),
-		recvUse,
-		fnDecl.Name,
-		typeParamsUse,
-		paramsUse,
-	)
-	rv := retsVars[:len(retsVars)-1]
-	if len(rv) > 0 {
-		fmt.Fprintf(g, "return %s", strings.Join(rv, ","))
+	if v.OnceVar != "" {
+		fmt.Fprintf(g, "// %s is called once, by the first call, its results are returned by the following ones\n", w.Name)
+	}
+	if v.LineDirective != "" {
+		fmt.Fprintf(g, "%s\n", v.LineDirective)
+	}
+	if err = printNode(g, decl); err != nil {
+		return err
 	}
-	fmt.Fprintf(g, "}\n\n")
+	fmt.Fprintf(g, "\n\n")
 	return nil
 }
 
-func generateType(typ ast.Expr) (string, error) {
+// panicExpr returns the value the wrapper w panics with: the error, described by the call with PanicArgs, in a
+// *MustError with MustError, carrying the stack with Stack.
+func (g *Generator) panicExpr(w *FuncSpec, v *WrapperView) ast.Expr {
+	var expr ast.Expr = ast.NewIdent(v.ErrVar)
+	if g.PanicArgs {
+		expr = panicArgs(w, v, g.RedactTypes)
+	}
+	if g.MustError {
+		expr = &ast.UnaryExpr{Op: token.AND, X: &ast.CompositeLit{
+			Type: ast.NewIdent("MustError"),
+			Elts: []ast.Expr{
+				&ast.KeyValueExpr{Key: ast.NewIdent("Func"), Value: stringLit(wrapperName(w))},
+				&ast.KeyValueExpr{Key: ast.NewIdent("Err"), Value: expr},
+			},
+		}}
+	}
+	if g.Stack {
+		expr = callExpr(ast.NewIdent("mustStack"), expr)
+	}
+	return expr
+}
+
+// onError returns the statements run by the wrapper w when the wrapped function fails: the hooks and the panic.
+func (g *Generator) onError(w *FuncSpec, v *WrapperView, panicExpr ast.Expr) []ast.Stmt {
+	var stmts []ast.Stmt
+	if g.Metrics {
+		stmts = append(stmts, ifNotNil("OnMustFailure", &ast.ExprStmt{
+			X: callExpr(ast.NewIdent("OnMustFailure"), stringLit(wrapperName(w)), ast.NewIdent(v.ErrVar)),
+		}))
+	}
+	if ctx := contextParam(w, g.planImports); g.Tracing && ctx != "" {
+		stmts = append(stmts, ifNotNil("RecordMustError", &ast.ExprStmt{
+			X: callExpr(ast.NewIdent("RecordMustError"), idents(ctx, v.ErrVar)...),
+		}))
+	}
+	return append(stmts, &ast.ExprStmt{X: callExpr(ast.NewIdent("panic"), panicExpr)})
+}
+
+// contextParam returns the name of the first context.Context parameter of w, empty if there is none. The package
+// of a type is the one imports import by its qualifier, eg: stdctx.Context with import stdctx "context", or the one
+// of the qualifier itself when none does.
+func contextParam(w *FuncSpec, imports []Import) string {
+	for _, f := range w.Params {
+		qual, name, ok := strings.Cut(f.Type, ".")
+		if !ok || name != "Context" {
+			continue
+		}
+		importPath := qual
+		if i := slices.IndexFunc(imports, func(imp Import) bool { return importName(imp) == qual }); i != -1 {
+			importPath = imports[i].Path
+		}
+		if importPath == "context" {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// wrapperName returns the name of the wrapper w in the panics, prefixed by its receiver type.
+func wrapperName(w *FuncSpec) string {
+	if w.isMethod() {
+		return w.recvTypeName() + "." + w.NewName
+	}
+	return w.NewName
+}
+
+// panicArgs returns an error describing the call of the wrapper, eg: fmt.Errorf("MustFoo(%v, %q): %w", a, b, err).
+// The redacted parameters and the ones of redactTypes are written as ***.
+func panicArgs(w *FuncSpec, v *WrapperView, redactTypes []string) ast.Expr {
+	name := wrapperName(w)
+	redacted := w.redacted()
+	verbs := make([]string, 0, len(w.Params))
+	args := make([]ast.Expr, 0, len(w.Params)+2)
+	for _, f := range w.Params {
+		typ := strings.TrimPrefix(f.Type, "...")
+		switch {
+		case slices.Contains(redacted, f.Name) || slices.Contains(redactTypes, typ):
+			verbs = append(verbs, "***")
+			continue
+		case typ == "string":
+			verbs = append(verbs, "%q")
+		default:
+			verbs = append(verbs, "%v")
+		}
+		args = append(args, ast.NewIdent(f.Name))
+	}
+	args = append([]ast.Expr{stringLit(name + "(" + strings.Join(verbs, ", ") + "): %w")}, args...)
+	return callExpr(selector("fmt", "Errorf"), append(args, ast.NewIdent(v.ErrVar))...)
+}
+
+func joinFields(fields []Field) (decl string, use string) {
+	decls := make([]string, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		decls = append(decls, fmt.Sprintf("%s %s", f.Name, f.Type))
+		if strings.HasPrefix(f.Type, "...") {
+			names = append(names, f.Name+"...")
+			continue
+		}
+		names = append(names, f.Name)
+	}
+	return strings.Join(decls, ","), strings.Join(names, ",")
+}
+
+// generateType renders typ as seen from the output package: from the type information when the package was
+// type-checked, from the syntax otherwise.
+func (p *planner) generateType(typ ast.Expr) (string, error) {
+	if p.info != nil {
+		if s, ok, err := p.typeString(typ); ok || err != nil {
+			return s, err
+		}
+	}
 	switch t := typ.(type) {
 	case *ast.StarExpr:
-		tx, err := generateType(t.X)
+		tx, err := p.generateType(t.X)
 		if err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("*%s", tx), nil
 	case *ast.Ident:
-		return t.Name, nil
+		return p.qualify(t)
 	case *ast.Ellipsis:
-		return fmt.Sprintf("...%s", t.Elt), nil
+		elt, err := p.generateType(t.Elt)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("...%s", elt), nil
 	case *ast.BinaryExpr:
 		if !t.Op.IsOperator() {
-			return "", ErrUnknownFieldType
+			return "", p.errAt(t, unsupportedType(t))
 		}
-		tx, err := generateType(t.X)
+		tx, err := p.generateType(t.X)
 		if err != nil {
 			return "", err
 		}
-		ty, err := generateType(t.Y)
+		ty, err := p.generateType(t.Y)
 		if err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("%s %s %s", tx, t.Op.String(), ty), nil
 	case *ast.UnaryExpr:
-		return fmt.Sprintf("%s%s", t.Op.String(), t.X), nil
+		tx, err := p.generateType(t.X)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s%s", t.Op.String(), tx), nil
 	case *ast.IndexExpr:
-		ident, err := generateType(t.X)
+		ident, err := p.generateType(t.X)
 		if err != nil {
 			return "", err
 		}
-		expr, err := generateType(t.Index)
+		expr, err := p.generateType(t.Index)
 		if err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("%s[%s]", ident, expr), nil
+	case *ast.SelectorExpr:
+		x, ok := t.X.(*ast.Ident)
+		if ok && x.Name == "C" {
+			return "", p.errAt(t, ErrCgoType)
+		}
+		// the type is written like in the file of the function, importing the package with the same name
+		imp, found := p.lookupImport(x)
+		if !ok || !found {
+			return "", p.errAt(typ, unsupportedType(typ))
+		}
+		if !slices.Contains(p.imports, imp) {
+			p.imports = append(p.imports, imp)
+		}
+		return x.Name + "." + t.Sel.Name, nil
 	case *ast.IndexListExpr:
-		ident, err := generateType(t.X)
+		ident, err := p.generateType(t.X)
 		if err != nil {
 			return "", err
 		}
 		exprs := make([]string, 0, len(t.Indices))
 		for _, i := range t.Indices {
-			e, err := generateType(i)
+			e, err := p.generateType(i)
 			if err != nil {
 				return "", err
 			}
 			exprs = append(exprs, e)
 		}
 		return fmt.Sprintf("%s[%s]", ident, strings.Join(exprs, ",")), nil
+	case *ast.ArrayType:
+		elt, err := p.generateType(t.Elt)
+		if err != nil {
+			return "", err
+		}
+		if t.Len == nil {
+			return "[]" + elt, nil
+		}
+		n, err := p.generateLen(t.Len)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[%s]%s", n, elt), nil
+	case *ast.ParenExpr:
+		x, err := p.generateType(t.X)
+		if err != nil {
+			return "", err
+		}
+		return "(" + x + ")", nil
+	case *ast.MapType:
+		key, err := p.generateType(t.Key)
+		if err != nil {
+			return "", err
+		}
+		value, err := p.generateType(t.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map[%s]%s", key, value), nil
+	case *ast.ChanType:
+		value, err := p.generateType(t.Value)
+		if err != nil {
+			return "", err
+		}
+		switch t.Dir {
+		case ast.SEND:
+			return "chan<- " + value, nil
+		case ast.RECV:
+			return "<-chan " + value, nil
+		}
+		if strings.HasPrefix(value, "<-chan") {
+			// chan <-chan T would read as chan<- chan T
+			value = "(" + value + ")"
+		}
+		return "chan " + value, nil
+	case *ast.FuncType:
+		params, err := p.generateSignatureFields(t.Params)
+		if err != nil {
+			return "", err
+		}
+		results, err := p.generateSignatureFields(t.Results)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case len(results) == 0:
+			return fmt.Sprintf("func(%s)", strings.Join(params, ", ")), nil
+		case len(results) == 1 && len(t.Results.List[0].Names) == 0:
+			return fmt.Sprintf("func(%s) %s", strings.Join(params, ", "), results[0]), nil
+		}
+		return fmt.Sprintf("func(%s) (%s)", strings.Join(params, ", "), strings.Join(results, ", ")), nil
 	default:
-		return "", ErrUnknownFieldType
+		return "", p.errAt(typ, unsupportedType(typ))
+	}
+}
+
+// generateLen renders the length of an array type: a literal, or a constant expression.
+func (p *planner) generateLen(n ast.Expr) (string, error) {
+	switch n := n.(type) {
+	case *ast.BasicLit:
+		return n.Value, nil
+	case *ast.ParenExpr:
+		x, err := p.generateLen(n.X)
+		if err != nil {
+			return "", err
+		}
+		return "(" + x + ")", nil
+	case *ast.BinaryExpr:
+		x, err := p.generateLen(n.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := p.generateLen(n.Y)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", x, n.Op, y), nil
+	}
+	return p.generateType(n)
+}
+
+// generateSignatureFields renders the parameters or the results of a function type, with their names if any.
+func (p *planner) generateSignatureFields(fields *ast.FieldList) ([]string, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	var list []string
+	for _, f := range fields.List {
+		typ, err := p.generateType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.Names) == 0 {
+			list = append(list, typ)
+			continue
+		}
+		for _, name := range f.Names {
+			list = append(list, name.Name+" "+typ)
+		}
 	}
+	return list, nil
 }
 
-func generateReceiver(recv *ast.FieldList) (name string, decl string, err error) {
+func (p *planner) generateReceiver(recv *ast.FieldList) (*Field, error) {
 	if recv == nil {
-		return "", "", err
+		return nil, nil
 	}
-	name = recv.List[0].Names[0].Name
-	decl, err = generateType(recv.List[0].Type)
+	typ, err := p.generateType(recv.List[0].Type)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	if names := recv.List[0].Names; len(names) > 0 && names[0].Name != "_" {
+		return &Field{Name: names[0].Name, Type: typ}, nil
+	}
+	return &Field{Name: p.placeholderRecv(), Type: typ}, nil
+}
+
+// placeholderRecv returns the name of a blank or unnamed receiver, it doesn't collide with the names used by the
+// signature.
+func (p *planner) placeholderRecv() string {
+	nodes := []ast.Node{p.fn.Type}
+	if p.fn.Recv != nil {
+		nodes = append(nodes, p.fn.Recv)
+	}
+	used := make(map[string]bool)
+	for _, node := range nodes {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+			return true
+		})
+	}
+	base := p.recvName
+	if base == "" {
+		base = DefaultRecvName
+	}
+	name := base
+	for i := 1; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	return name
+}
+
+// isRecvType reports whether typ can be the receiver of a method: T or *T.
+func isRecvType(typ string) bool {
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return false
 	}
-	if name == "_" {
-		name = "t"
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
 	}
-	return fmt.Sprintf("(%s %s)", name, decl), name + ".", nil
+	_, ok := expr.(*ast.Ident)
+	return ok
 }
 
-func generateParams(params *ast.FieldList) (decl string, use string, err error) {
+func (p *planner) generateParams(params *ast.FieldList) ([]Field, error) {
 	if params == nil || len(params.List) == 0 {
-		return "", "", nil
+		return nil, nil
 	}
-	names := make([]string, 0, len(params.List))
-	types := make([]string, 0, len(params.List))
+	fields := make([]Field, 0, len(params.List))
 	for _, i := range params.List {
-		names = append(names, i.Names[0].Name)
-		t, err := generateType(i.Type)
+		t, err := p.generateType(i.Type)
 		if err != nil {
-			return "", "", err
+			return nil, err
+		}
+		// the blank and unnamed parameters are named after their index, the wrapper passes them on
+		if len(i.Names) == 0 {
+			fields = append(fields, Field{Name: fmt.Sprintf("p%d", len(fields)), Type: t})
+			continue
+		}
+		for _, name := range i.Names {
+			if name.Name == "_" {
+				fields = append(fields, Field{Name: fmt.Sprintf("p%d", len(fields)), Type: t})
+				continue
+			}
+			fields = append(fields, Field{Name: name.Name, Type: t})
 		}
-		types = append(types, fmt.Sprintf("%s %s", i.Names[0].Name, t))
 	}
-	return strings.Join(types, ","), strings.Join(names, ","), nil
+	return fields, nil
 }
 
-func generateReturns(rets *ast.FieldList) (decl []string, use []string, err error) {
+// generateReturns returns the types of the results, and their names when they are named.
+func (p *planner) generateReturns(rets *ast.FieldList) (results, names []string, iter bool, err error) {
 	if rets == nil || len(rets.List) == 0 {
-		return nil, nil, ErrNoReturnValues
+		return nil, nil, false, p.errAt(p.fn.Type, ErrNoReturnValues)
+	}
+	if elem := iterSeq2Elem(rets); elem != nil {
+		t, err := p.generateType(elem)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return []string{t, "error"}, nil, true, nil
 	}
-	names := make([]string, 0, len(rets.List))
-	types := make([]string, 0, len(rets.List))
-	for i, ret := range rets.List {
-		names = append(names, fmt.Sprintf("var%d", i))
-		t, err := generateType(ret.Type)
+	results = make([]string, 0, len(rets.List))
+	for _, ret := range rets.List {
+		t, err := p.generateType(ret.Type)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, false, err
+		}
+		if len(ret.Names) == 0 {
+			results = append(results, t)
+			continue
+		}
+		for _, name := range ret.Names {
+			results = append(results, t)
+			names = append(names, name.Name)
 		}
-		types = append(types, t)
 	}
-	if types[len(types)-1] != "error" {
-		return nil, nil, ErrNoErrorReturn
+	last := rets.List[len(rets.List)-1].Type
+	if results[len(results)-1] != "error" && (p.info == nil || !isErrorType(p.info.TypeOf(last))) {
+		return nil, nil, false, p.errAt(last, ErrNoErrorReturn)
 	}
-	names[len(names)-1] = "err"
-	return types, names, nil
+	return results, names, false, nil
 }
 
-func generateTypeParams(typeParams *ast.FieldList) (decl string, use string, err error) {
+// iterSeq2Elem returns T if rets is a single iter.Seq2[T, error], nil otherwise.
+func iterSeq2Elem(rets *ast.FieldList) ast.Expr {
+	if len(rets.List) != 1 || len(rets.List[0].Names) > 1 {
+		return nil
+	}
+	t, ok := rets.List[0].Type.(*ast.IndexListExpr)
+	if !ok || len(t.Indices) != 2 {
+		return nil
+	}
+	sel, ok := t.X.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Seq2" {
+		return nil
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "iter" {
+		return nil
+	}
+	if errType, ok := t.Indices[1].(*ast.Ident); !ok || errType.Name != "error" {
+		return nil
+	}
+	return t.Indices[0]
+}
+
+func (p *planner) generateTypeParams(typeParams *ast.FieldList) ([]Field, error) {
 	if typeParams == nil || len(typeParams.List) == 0 {
-		return "", "", nil
+		return nil, nil
 	}
-	names := make([]string, 0, len(typeParams.List))
-	types := make([]string, 0, len(typeParams.List))
-	_ = types
+	fields := make([]Field, 0, len(typeParams.List))
 	for _, i := range typeParams.List {
-		names = append(names, i.Names[0].Name)
-		t, err := generateType(i.Type)
+		t, err := p.generateType(i.Type)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		types = append(types, fmt.Sprintf("%s %s", i.Names[0].Name, t))
-	}
-	if len(names) > 0 {
-		use = fmt.Sprintf("[%s]", strings.Join(names, ","))
-		decl = fmt.Sprintf("[%s]", strings.Join(types, ","))
+		fields = append(fields, Field{Name: i.Names[0].Name, Type: t})
 	}
-	return decl, use, nil
+	return fields, nil
 }
 
+// Generate writes the unformatted wrappers of pkg to w, with the default options.
 func Generate(w io.Writer, pkg *packages.Package) error {
-	gen := NewGenerator(w)
-	gen.GenerateHead(pkg.Name)
-	return WalkPackage(pkg, "@gen_must", gen.GenerateMust)
+	return New(WithFormatter("")).Generate(context.Background(), w, pkg)
 }
@@ -2,12 +2,28 @@ package mustgen
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/go/packages"
 )
 
 func filePath(name string) string { return filepath.Join("testdata", "testpkg", name) }
@@ -16,22 +32,2133 @@ func goFilePath(idx int) string { return filePath(fmt.Sprintf("testpkg_%d.go", i
 
 func expectedFilePath(idx int) string { return goFilePath(idx) + ".expected" }
 
+const testCount = 19
+
+var ctx = context.Background()
+
 func TestMustGen(t *testing.T) {
-	const testCount = 9
+	// the files are loaded with the type information, and without it like by the command line
+	loaders := map[string]func(ctx context.Context, patterns []string) (*packages.Package, error){
+		"types": func(ctx context.Context, patterns []string) (*packages.Package, error) {
+			return ParsePackage(ctx, patterns)
+		},
+		"syntax": func(ctx context.Context, patterns []string) (*packages.Package, error) {
+			return New().Load(ctx, patterns)
+		},
+	}
+	for mode, load := range loaders {
+		for i := 0; i < testCount; i++ {
+			if i == 10 && mode == "syntax" {
+				// the concrete error types need the type information
+				continue
+			}
+			goFile := goFilePath(i)
+			t.Run(fmt.Sprintf("File: %s, %s", goFile, mode), func(t *testing.T) {
+				pkg, err := load(ctx, []string{goFile})
+				require.NoError(t, err)
+				buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+				err = Generate(buffer, pkg)
+				require.NoError(t, err)
+				fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+				err = GoFmt(buffer, fmtCode)
+				require.NoError(t, err)
+				exp, err := os.ReadFile(expectedFilePath(i))
+				require.NoError(t, err)
+				require.Equal(t, string(exp), fmtCode.String())
+			})
+		}
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
 	for i := 0; i < testCount; i++ {
 		goFile := goFilePath(i)
 		t.Run(fmt.Sprintf("File: %s", goFile), func(t *testing.T) {
-			pkg, err := ParsePackage([]string{goFile})
+			pkg, err := ParsePackage(ctx, []string{goFile})
 			require.NoError(t, err)
-			buffer := bytes.NewBuffer(make([]byte, 0, 1024))
-			err = Generate(buffer, pkg)
+			plan, err := BuildPlan(pkg, "")
 			require.NoError(t, err)
-			fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
-			err = GoFmt(buffer, fmtCode)
+			planJSON := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, plan.Write(planJSON))
+			plan, err = ReadPlan(planJSON)
 			require.NoError(t, err)
+			buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, NewGenerator(buffer).Emit(plan))
+			fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, GoFmt(buffer, fmtCode))
 			exp, err := os.ReadFile(expectedFilePath(i))
 			require.NoError(t, err)
 			require.Equal(t, exp, fmtCode.Bytes())
 		})
 	}
 }
+
+func TestPositionedErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		err  error
+		msg  string
+	}{
+		{"errpkg_0.go", ErrNoErrorReturn, "errpkg_0.go:3:16: noError: no error returned"},
+		{"errpkg_2.go", ErrNoReturnValues, "errpkg_2.go:3:1: noResults: no return values"},
+		{"errpkg_4.go", ErrNoErrorReturn, "errpkg_4.go:7:23: valueErr: no error returned"},
+		{"errpkg_5.go", ErrUnknownParam, "errpkg_5.go:3:1: login: redact=pasword: unknown parameter"},
+		{"errpkg_10.go", ErrUnknownOption, "errpkg_10.go:3:1: signIn: unknown directive option: redcat"},
+		{"errpkg_6.go", ErrOnceVariant, "errpkg_6.go:3:1: open: " + ErrOnceVariant.Error()},
+		{"errpkg_7.go", ErrAllOption, "errpkg_7.go:3:1: join: " + ErrAllOption.Error()},
+		{"errpkg_9.go", ErrRecvOption, "errpkg_9.go:5:1: stop: recv=*service: " + ErrRecvOption.Error()},
+		{"errpkg_3.go", ErrWrapperMismatch, "errpkg_3.go:8:1: drifted: hand-written wrapper doesn't match the wrapped function: mustDrifted: want func(string, int) (int), got func(string) (int)"},
+	}
+	for _, tt := range tests {
+		goFile := filepath.Join("testdata", "errpkg", tt.file)
+		t.Run(fmt.Sprintf("File: %s", goFile), func(t *testing.T) {
+			pkg, err := ParsePackage(ctx, []string{goFile})
+			require.NoError(t, err)
+			err = Generate(io.Discard, pkg)
+			require.ErrorIs(t, err, tt.err)
+			var posErr *PosError
+			require.ErrorAs(t, err, &posErr)
+			require.Equal(t, tt.file, filepath.Base(posErr.Pos.Filename))
+			require.True(t, strings.HasSuffix(err.Error(), tt.msg), err.Error())
+		})
+	}
+	// without the type information, the types are rendered from the syntax
+	errFile := filepath.Join("testdata", "errpkg", "errpkg_1.go")
+	pkg, err := New().Load(ctx, []string{errFile})
+	require.NoError(t, err)
+	err = Generate(io.Discard, pkg)
+	require.ErrorIs(t, err, ErrUnknownFieldType)
+	require.True(t, strings.HasSuffix(err.Error(), "errpkg_1.go:3:20: structParam: unknown field type: struct{n int}"), err.Error())
+	var typeErr *UnsupportedTypeError
+	require.ErrorAs(t, err, &typeErr)
+	require.Equal(t, "struct{n int}", typeErr.Construct)
+	pkg, err = ParsePackage(ctx, []string{errFile})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, Generate(buffer, pkg))
+	require.Contains(t, buffer.String(), "(s struct{ n int })")
+}
+
+func TestSyntaxTypes(t *testing.T) {
+	// without -typecheck the composite types are rendered from the syntax too
+	sources := map[string]string{
+		"io.go": "package io\n\nimport \"time\"\n\nconst size = 4\n\ntype Buf struct{}\n\n" +
+			"func Read(b []byte, m map[string]int) (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n\n" +
+			"func Watch(f func(string, ...int) (bool, error), in <-chan time.Time, out chan<- [size * 2]*Buf, c chan (<-chan int)) (func() error, error) {\n\t//@gen_must\n\treturn nil, nil\n}\n",
+	}
+	out, err := New(WithFormatter("gofmt")).GenerateSources(ctx, "example.com/io", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustRead(b []byte, m map[string]int) int {")
+	require.Contains(t, string(out), "func MustWatch(f func(string, ...int) (bool, error), in <-chan time.Time, out chan<- [size * 2]*Buf, c chan (<-chan int)) func() error {")
+	require.Contains(t, string(out), "\t\"time\"\n")
+
+	// outside of the package, the names of the package are qualified
+	out, err = New(WithFormatter("gofmt"), WithPackage("must")).GenerateSources(ctx, "example.com/io", map[string]string{
+		"io.go": "package io\n\nconst Size = 4\n\ntype Buf struct{}\n\nfunc Fill(b [Size]Buf, m map[Buf]func(Buf) Buf) (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n",
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustFill(b [io.Size]io.Buf, m map[io.Buf]func(io.Buf) io.Buf) int {")
+}
+
+func TestHandWritten(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "handpkg")})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Len(t, plan.Funcs, 1)
+	require.Equal(t, "MustOpen", plan.Funcs[0].NewName)
+}
+
+func TestSkipGenerated(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "genpkg")})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Len(t, plan.Funcs, 1)
+	require.Equal(t, "MustOpen", plan.Funcs[0].NewName)
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	files, err := GeneratedFiles(ctx, []string{"./" + filepath.Join("testdata", "cleanpkg")})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "musts.gen.go", filepath.Base(files[0]))
+}
+
+func TestHeaderVersion(t *testing.T) {
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	gen := NewGenerator(buffer)
+	gen.Version = "v1.2.3"
+	require.NoError(t, gen.Emit(&Plan{Package: "testpkg"}))
+	require.Contains(t, buffer.String(), "// This file is auto generated by gen_must v1.2.3 and any manual changes will be lost.\n")
+	isGen, err := IsGenerated(buffer)
+	require.NoError(t, err)
+	require.True(t, isGen)
+}
+
+func TestBuildTags(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "tagpkg")}, "-tags=integration")
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, Generate(buffer, pkg))
+	fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoFmt(buffer, fmtCode))
+	require.Contains(t, fmtCode.String(), "func MustDoThing() int {")
+	require.Contains(t, fmtCode.String(), "\n\n//go:build integration\n\npackage tagpkg\n")
+}
+
+func TestBuildConstraints(t *testing.T) {
+	tests := []struct {
+		src      string
+		filename string
+		expr     string
+	}{
+		{"package p\n", "p.go", ""},
+		{"package p\n", "p_linux.go", "linux"},
+		{"package p\n", "p_linux_arm64_test.go", "linux && arm64"},
+		{"package p\n", "linux.go", ""},
+		{"//go:build !windows || cgo\n\npackage p\n", "p_amd64.go", "(!windows || cgo) && amd64"},
+		// the tags of the name required by the //go:build line aren't repeated
+		{"//go:build linux\n\npackage p\n", "p_linux.go", "linux"},
+		{"//go:build linux && cgo\n\npackage p\n", "p_linux_amd64.go", "linux && cgo && amd64"},
+		{"//go:build android\n\npackage p\n", "p_linux.go", "android"},
+	}
+	for _, tt := range tests {
+		file, err := parser.ParseFile(token.NewFileSet(), tt.filename, tt.src, parser.ParseComments)
+		require.NoError(t, err)
+		require.Equal(t, tt.expr, fileConstraint(file, tt.filename), tt.filename)
+	}
+	plan := &Plan{Package: "p", Funcs: []*FuncSpec{
+		{Name: "a", NewName: "mustA", Results: []string{"error"}, Constraint: "linux"},
+		{Name: "b", NewName: "mustB", Results: []string{"error"}},
+	}}
+	require.ErrorIs(t, NewGenerator(io.Discard).Emit(plan), ErrMixedConstraints)
+	files := ConstraintFiles(plan, "must.go")
+	require.Len(t, files, 2)
+	require.Equal(t, []string{"mustB"}, []string{files[0].Plan.Funcs[0].NewName})
+	require.Equal(t, []string{"linux"}, files[0].Plan.Parts)
+	require.Equal(t, "must_linux.go", files[1].Path)
+
+	// the constraints reading differently but the same are written to the same file
+	plan.Funcs[1].Constraint = "amd64 && linux"
+	plan.Funcs = append(plan.Funcs, &FuncSpec{Name: "c", NewName: "mustC", Results: []string{"error"}, Constraint: "linux && amd64 && linux"})
+	require.NoError(t, NewGenerator(io.Discard).Emit(&Plan{Package: "p", Funcs: plan.Funcs[1:]}))
+	files = ConstraintFiles(plan, "must.go")
+	require.Len(t, files, 3)
+	require.Equal(t, "must_amd64_linux.go", files[1].Path)
+	require.Len(t, files[1].Plan.Funcs, 2)
+	require.Equal(t, "amd64 && linux", constraintKey("linux && (amd64) && linux"))
+	require.Equal(t, "(cgo || darwin) && linux", constraintKey("linux && (darwin || cgo || darwin)"))
+
+	names := map[string]string{
+		"linux":             "must_linux.go",
+		"linux && amd64":    "must_linux_amd64.go",
+		"integration":       "must_integration.go",
+		"!windows":          "must_not_windows_build.go",
+		"linux || darwin":   "must_linux_or_darwin_build.go",
+		"go1.21 && !purego": "must_go121_not_purego.go",
+	}
+	for c, name := range names {
+		require.Equal(t, name, constraintFile("must.go", c), c)
+	}
+}
+
+func TestConstraintFiles(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-tags", "foo,bar", "-must-error", "-format", "gofmt", "-outdir", dir, "-out", "must.go", "./testdata/constraintpkg"}
+	require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+	for _, name := range []string{"must.go", "must_foo.go", "must_bar_not_baz.go"} {
+		exp, err := os.ReadFile(filepath.Join("testdata", "constraintpkg", name+".expected"))
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, string(exp), strings.Replace(string(got), "gen_must "+toolVersion()+" and", "gen_must and", 1), name)
+	}
+	require.Equal(t, ExitOK, Run(ctx, append([]string{"-check"}, args...), io.Discard, io.Discard))
+	// a single file can't carry different constraints
+	stderr := bytes.NewBuffer(nil)
+	require.NotEqual(t, ExitOK, Run(ctx, []string{"-tags", "foo,bar", "./testdata/constraintpkg"}, io.Discard, stderr))
+	require.Contains(t, stderr.String(), ErrMixedConstraints.Error())
+}
+
+func TestHeader(t *testing.T) {
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	gen := NewGenerator(buffer)
+	gen.Version = "v1.2.3"
+	gen.Header = "Copyright ACME\nAll rights reserved.\n"
+	gen.Marker = "// Code generated by gen_must {{.Version}} for {{.Package}}. DO NOT EDIT."
+	require.NoError(t, gen.Emit(&Plan{Package: "testpkg"}))
+	require.Equal(t,
+		"// Copyright ACME\n// All rights reserved.\n\n// Code generated by gen_must v1.2.3 for testpkg. DO NOT EDIT.\n\npackage testpkg\n\n",
+		buffer.String(),
+	)
+	isGen, err := IsGenerated(buffer)
+	require.NoError(t, err)
+	require.True(t, isGen)
+
+	gen.Marker = "// generated by gen_must"
+	require.ErrorIs(t, gen.Emit(&Plan{Package: "testpkg"}), ErrInvalidMarker)
+}
+
+func TestExternalPackage(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "extpkg")})
+	require.NoError(t, err)
+	plan, err := BuildPlan(pkg, "extpkg_test")
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, NewGenerator(buffer).Emit(plan))
+	fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoFmt(buffer, fmtCode))
+	exp, err := os.ReadFile(filepath.Join("testdata", "extpkg", "extpkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), fmtCode.String())
+}
+
+func TestLoadMode(t *testing.T) {
+	require.Zero(t, New().LoadMode()&packages.NeedTypes)
+	require.NotZero(t, New(WithTypeCheck(true)).LoadMode()&packages.NeedTypesInfo)
+	// the scope of the wrapped package is rebuilt from its syntax
+	g := New(WithPackage("extpkg_test"), WithFormatter("gofmt"))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "extpkg")})
+	require.NoError(t, err)
+	require.Nil(t, pkg.Types)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filepath.Join("testdata", "extpkg", "extpkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+	// concrete error types need the type information
+	patterns := []string{filepath.Join("testdata", "testpkg", "testpkg_10.go")}
+	pkg, err = New().Load(ctx, patterns)
+	require.NoError(t, err)
+	_, err = New().Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrNoErrorReturn)
+	g = New(WithTypeCheck(true))
+	pkg, err = g.Load(ctx, patterns)
+	require.NoError(t, err)
+	_, err = g.Plan(ctx, pkg)
+	require.NoError(t, err)
+}
+
+func TestPlanTypes(t *testing.T) {
+	// the syntax isn't used, the types could come from LoadTypes as well
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "typespkg")})
+	require.NoError(t, err)
+	plan, err := New(WithPackage("typespkg_test")).PlanTypes(pkg)
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, NewGenerator(buffer).Emit(plan))
+	fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoFmt(buffer, fmtCode))
+	exp, err := os.ReadFile(filepath.Join("testdata", "typespkg", "typespkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), fmtCode.String())
+
+	plan, err = New().PlanTypes(pkg, "Close")
+	require.NoError(t, err)
+	require.Empty(t, plan.Imports)
+	require.Len(t, plan.Funcs, 1)
+	require.Equal(t, "", plan.Funcs[0].Pkg)
+	require.Equal(t, []Field{{Name: "p0", Type: "*Config"}}, plan.Funcs[0].Params)
+}
+
+func TestGoImports(t *testing.T) {
+	src := "package testpkg\n\nimport \"os\"\n\nfunc f() (*bytes.Buffer, error) { return nil, nil }\n"
+	out := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoImports(filePath("musts.gen.go"), strings.NewReader(src), out))
+	require.Equal(t, "package testpkg\n\nimport \"bytes\"\n\nfunc f() (*bytes.Buffer, error) { return nil, nil }\n", out.String())
+}
+
+func TestFormat(t *testing.T) {
+	src := "package testpkg\n\nfunc f() {\n\n\treturn\n}\n"
+	out := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, Format("gofumpt", "", strings.NewReader(src), out))
+	require.Equal(t, "package testpkg\n\nfunc f() {\n\treturn\n}\n", out.String())
+	require.ErrorIs(t, Format("nofmt", "", strings.NewReader(src), out), ErrUnknownFormatter)
+}
+
+func TestMerge(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	plan, err := BuildPlan(pkg, "")
+	require.NoError(t, err)
+	const (
+		handWritten = "package testpkg\n\n// helper is hand written\nfunc helper() {}\n"
+		region      = RegionBegin + "\n\n" +
+			"// mustDoThing has the behavior of doThing, except it panics on error\n" +
+			"func mustDoThing() int {\n" +
+			"\tn, err := doThing()\n" +
+			"\tif err != nil {\n" +
+			"\t\tpanic(err)\n" +
+			"\t}\n" +
+			"\treturn n\n" +
+			"}\n\n" +
+			RegionEnd + "\n"
+		trailer = "\n// other is hand written\nfunc other() {}\n"
+	)
+	tests := []struct {
+		name string
+		src  string
+		exp  string
+	}{
+		{"append", handWritten, handWritten + "\n" + region},
+		{"replace", handWritten + "\n" + RegionBegin + "\n\nfunc mustOld() {}\n\n" + RegionEnd + "\n" + trailer, handWritten + "\n" + region + trailer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, NewGenerator(buffer).Merge([]byte(tt.src), plan))
+			fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, GoFmt(buffer, fmtCode))
+			require.Equal(t, tt.exp, fmtCode.String())
+		})
+	}
+	err = NewGenerator(io.Discard).Merge([]byte(handWritten+RegionBegin+"\n"), plan)
+	require.ErrorIs(t, err, ErrBadRegion)
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.go")
+	out := filepath.Join(dir, "out.go")
+	require.NoError(t, os.WriteFile(src, []byte("package src\n"), 0o644))
+	require.NoError(t, os.WriteFile(out, []byte("package src\n"), 0o644))
+	cache := &Cache{Dir: filepath.Join(dir, "cache")}
+	key, err := HashInputs([]string{src}, "v1")
+	require.NoError(t, err)
+	fresh, err := cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.False(t, fresh)
+	require.NoError(t, cache.Store(out, key))
+	fresh, err = cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	otherKey, err := HashInputs([]string{src}, "v2")
+	require.NoError(t, err)
+	fresh, err = cache.Fresh(out, otherKey)
+	require.NoError(t, err)
+	require.False(t, fresh)
+	require.NoError(t, os.WriteFile(out, []byte("package src\n\n// edited\n"), 0o644))
+	fresh, err = cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.False(t, fresh)
+	// the other files written with out are checked too
+	part := filepath.Join(dir, "part.go")
+	require.NoError(t, os.WriteFile(part, []byte("package src\n"), 0o644))
+	require.NoError(t, cache.Store(out, key, part))
+	fresh, err = cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	require.NoError(t, os.WriteFile(part, []byte("package src\n\n// edited\n"), 0o644))
+	fresh, err = cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.False(t, fresh)
+	require.NoError(t, os.Remove(part))
+	fresh, err = cache.Fresh(out, key)
+	require.NoError(t, err)
+	require.False(t, fresh)
+	plan, err := cache.Plan(key)
+	require.NoError(t, err)
+	require.Nil(t, plan)
+	stored := &Plan{Package: "src", Funcs: []*FuncSpec{{Name: "Open", NewName: "MustOpen"}}}
+	require.NoError(t, cache.StorePlan(key, stored))
+	plan, err = cache.Plan(key)
+	require.NoError(t, err)
+	require.Equal(t, stored, plan)
+}
+
+func TestKeyInputs(t *testing.T) {
+	keys := func(args ...string) ([]string, []string) {
+		c, code := parseCommand(append(args, goFilePath(0)), io.Discard, io.Discard)
+		require.NotNil(t, c, code)
+		return c.keyInputs(nil), c.keyInputs(outputFlags)
+	}
+	runKey, planKey := keys("-out", "a.go", "-wrap", "Get*", "-wrap", "Put*")
+	require.Equal(t, []string{toolVersion(), "-out=a.go", "-wrap=Get*", "-wrap=Put*"}, runKey)
+	require.Equal(t, []string{toolVersion(), "-wrap=Get*", "-wrap=Put*"}, planKey)
+	otherRun, otherPlan := keys("-out", "b.go", "-wrap", "Get*", "-wrap", "Put*")
+	require.NotEqual(t, runKey, otherRun)
+	require.Equal(t, planKey, otherPlan)
+	_, otherPlan = keys("-out", "a.go", "-wrap", "Get*", "-wrap", "Put*", "-recv-name", "r")
+	require.NotEqual(t, planKey, otherPlan)
+}
+
+func TestDigests(t *testing.T) {
+	stamped, current, err := New().Digests(ctx, expectedFilePath(0), []string{goFilePath(0)})
+	require.NoError(t, err)
+	require.NotEmpty(t, stamped)
+	require.Equal(t, stamped, current)
+	stamped, current, err = New().Digests(ctx, expectedFilePath(0), []string{goFilePath(1)})
+	require.NoError(t, err)
+	require.NotEqual(t, stamped, current)
+}
+
+func TestRun(t *testing.T) {
+	stdout := bytes.NewBuffer(make([]byte, 0, 1024))
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.Equal(t, ExitUsage, Run(ctx, nil, stdout, stderr))
+	require.Contains(t, stderr.String(), "usage: gen_must")
+	// the synopsis of the README is the one of the usage
+	synopsis, _, _ := strings.Cut(strings.TrimPrefix(stderr.String(), "usage: "), "\n")
+	readme, err := os.ReadFile("../README.md")
+	require.NoError(t, err)
+	require.Contains(t, string(readme), "`"+synopsis+"`")
+
+	stderr.Reset()
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-check", goFilePath(0)}, stdout, stderr))
+	require.Equal(t, "-check requires -out\n", stderr.String())
+
+	// the source digest is the same, but the expected file is generated without the version of gen_must
+	require.Equal(t, ExitCheck, Run(ctx, []string{"-out", filepath.Base(expectedFilePath(0)), "-check", goFilePath(0)}, stdout, stderr))
+	require.Equal(t, ExitCheck, Run(ctx, []string{"-out", filepath.Base(expectedFilePath(1)), "-check", goFilePath(0)}, stdout, stderr))
+
+	sarif := filepath.Join(t.TempDir(), "check.sarif")
+	require.Equal(t, ExitCheck, Run(ctx, []string{"-out", filepath.Base(expectedFilePath(1)), "-check", "-sarif", sarif, goFilePath(0)}, stdout, stderr))
+	b, err := os.ReadFile(sarif)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"ruleId": "stale-wrapper"`)
+	require.Contains(t, string(b), `"uri": "testdata/testpkg/testpkg_0.go"`)
+
+	// -diff prints the changes instead of writing them
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-diff", goFilePath(0)}, stdout, stderr))
+	stdout.Reset()
+	require.Equal(t, ExitCheck, Run(ctx, []string{"-out", filepath.Base(expectedFilePath(1)), "-diff", goFilePath(0)}, stdout, stderr))
+	require.Contains(t, stdout.String(), "--- "+expectedFilePath(1)+"\n+++ "+expectedFilePath(1)+" (generated)\n")
+	require.Contains(t, stdout.String(), "\n-func MustDoThing() int {\n")
+	require.Contains(t, stdout.String(), "\n+func mustDoThing() int {\n")
+
+	outDir := t.TempDir()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-outdir", outDir, "-out", "must.go", "./testdata/testpkg/testpkg_0.go"}, stdout, stderr))
+	require.FileExists(t, filepath.Join(outDir, "must.go"))
+	require.Equal(t, ExitOK, Run(ctx, []string{"-outdir", outDir, "-out", "must.go", "-check", goFilePath(0)}, stdout, stderr))
+	// the digest of the source is the same, not the output
+	require.Equal(t, ExitCheck, Run(ctx, []string{"-outdir", outDir, "-out", "must.go", "-check", "-panic-args", goFilePath(0)}, stdout, stderr))
+	generated, err := os.ReadFile(filepath.Join(outDir, "must.go"))
+	require.NoError(t, err)
+	require.Contains(t, string(generated), "\t\tpanic(err)\n")
+	for _, edited := range []string{
+		strings.Replace(string(generated), "\t\tpanic(err)\n", "", 1),
+		string(generated[:len(generated)/2]),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(outDir, "must.go"), []byte(edited), 0o644))
+		require.Equal(t, ExitCheck, Run(ctx, []string{"-outdir", outDir, "-out", "must.go", "-check", goFilePath(0)}, stdout, stderr))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(outDir, "must.go"), generated, 0o644))
+	outPath := filepath.Join(outDir, "literal", "must.go")
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", outPath, goFilePath(0)}, stdout, stderr))
+	require.FileExists(t, outPath)
+
+	// the paths of the directive are relative to the output directory, where go generate runs it
+	stdout.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-go-generate", "-panic-args", "-header-file", "../LICENSE", goFilePath(0)}, stdout, stderr))
+	require.Contains(t, stdout.String(), "\n//go:generate go run github.com/heliorosa/gen_must -go-generate -header-file=../../../LICENSE -panic-args ./testpkg_0.go\n")
+
+	stdout.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-plan-out", "-", goFilePath(0)}, stdout, stderr))
+	plan, err := ReadPlan(stdout)
+	require.NoError(t, err)
+	require.Equal(t, "testpkg", plan.Package)
+
+	// the module flags are passed to the build tool
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-mod", "bogus", goFilePath(0)}, stdout, stderr))
+	require.Equal(t, ExitLoad, Run(ctx, []string{"-modfile", filepath.Join(t.TempDir(), "go.mod"), goFilePath(0)}, stdout, stderr))
+
+	stderr.Reset()
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"-strict", filepath.Join("testdata", "errpkg", "errpkg_0.go")}, stdout, stderr))
+	require.Contains(t, stderr.String(), "noError: no error returned")
+
+	// the placeholder of the blank receivers
+	stdout.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-recv-name", "self", "-out", "-", goFilePath(15)}, stdout, stderr))
+	require.Contains(t, stdout.String(), "func (self *TypeA) mustApply(t int) int {")
+	require.Contains(t, stdout.String(), "func (self *TypeA) mustReset() bool {")
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-recv-name", "_", goFilePath(15)}, stdout, stderr))
+
+	// without -strict the function is skipped with a warning
+	stderr.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "-", filepath.Join("testdata", "errpkg", "errpkg_1.go")}, io.Discard, stderr))
+	require.Contains(t, stderr.String(), "function not wrapped")
+	require.Contains(t, stderr.String(), "structParam: unknown field type: struct{n int}")
+	require.NotContains(t, stderr.String(), "no tagged functions found")
+	// a directive on a function without error result is reported where it is
+	stderr.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "-", filepath.Join("testdata", "errpkg", "errpkg_0.go")}, io.Discard, stderr))
+	require.Contains(t, stderr.String(), `msg="directive on function without error result" func=noError pos=`)
+	require.Contains(t, stderr.String(), "errpkg_0.go:3:16\n")
+}
+
+func TestLayout(t *testing.T) {
+	patterns := []string{"./" + filepath.Join("testdata", "extpkg")}
+	dir, err := InternalDir(ctx, patterns)
+	require.NoError(t, err)
+	root, err := filepath.Abs("..")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "internal", "must", "mustgen", "testdata", "extpkg"), dir)
+	// the wrappers import the package, whose name they keep
+	g := New(WithLayout(LayoutInternal))
+	pkg, err := g.Load(ctx, patterns)
+	require.NoError(t, err)
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Equal(t, "extpkg", plan.Package)
+	require.Equal(t, []Import{{Path: "github.com/heliorosa/gen_must/mustgen/testdata/extpkg"}}, plan.Imports)
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-layout", "flat", goFilePath(0)}, io.Discard, io.Discard))
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-layout", "internal", "-outdir", t.TempDir(), goFilePath(0)}, io.Discard, io.Discard))
+}
+
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.work":          "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod":         "module example.com/a\n\ngo 1.21\n",
+		"a/store/store.go": "package store\n\nfunc Open(dsn string) (string, error) {\n\t//@gen_must\n\treturn dsn, nil\n}\n",
+		"b/go.mod":         "module example.com/b\n\ngo 1.21\n",
+		"b/api/api.go":     "package api\n\nfunc Get(url string) (string, error) {\n\t//@gen_must\n\treturn url, nil\n}\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	t.Cleanup(func() { os.Chdir(wd) })
+	// the workspace mode only accepts -mod=readonly or vendor
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "")
+
+	patterns, err := WorkspacePatterns(ctx, []string{"./...", "./a/...", "example.com/b/api"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"./a/...", "./b/...", "./a/...", "example.com/b/api"}, patterns)
+	stderr := &bytes.Buffer{}
+	require.Equal(t, ExitUsage, Run(ctx, []string{"./..."}, io.Discard, stderr))
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "must.go", "./..."}, io.Discard, stderr), stderr.String())
+	require.FileExists(t, filepath.Join("a", "store", "must.go"))
+	require.FileExists(t, filepath.Join("b", "api", "must.go"))
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "must.go", "-check", "./..."}, io.Discard, stderr), stderr.String())
+}
+
+func TestScanFiles(t *testing.T) {
+	// the package of the files is loaded, only the files are scanned
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-plan-out", "-", goFilePath(0), goFilePath(1)}, stdout, io.Discard))
+	plan, err := ReadPlan(stdout)
+	require.NoError(t, err)
+	require.NotEmpty(t, plan.Funcs)
+	for _, w := range plan.Funcs {
+		require.Contains(t, []string{"testpkg_0.go", "testpkg_1.go"}, filepath.Base(w.Pos.Filename))
+	}
+	pattern, isFiles, err := FilesPackage([]string{goFilePath(0), filepath.Join("testdata", "errpkg", "errpkg_0.go")})
+	require.True(t, isFiles)
+	require.ErrorIs(t, err, ErrFilesSpanPackages)
+	pattern, isFiles, err = FilesPackage([]string{goFilePath(0), goFilePath(1)})
+	require.NoError(t, err)
+	require.True(t, isFiles)
+	require.Equal(t, "testpkg", filepath.Base(pattern))
+	_, isFiles, err = FilesPackage([]string{"./testdata/testpkg"})
+	require.NoError(t, err)
+	require.False(t, isFiles)
+
+	g := New(WithScanFiles(filepath.Join("testdata", "errpkg", "errpkg_0.go")))
+	pkg, err := g.Load(ctx, []string{pattern})
+	require.NoError(t, err)
+	_, err = g.Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrFileNotInPackage)
+	// the files excluded by the build constraints are loaded by their name
+	stdout.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{filepath.Join("testdata", "tagpkg", "tagpkg.go")}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "func MustDoThing() int {")
+
+	// the files guarded by //go:build ignore are skipped, even named
+	files := []string{filepath.Join("testdata", "ignorepkg", "ignorepkg.go"), filepath.Join("testdata", "ignorepkg", "gen.go")}
+	stdout.Reset()
+	stderr := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, files, stdout, stderr))
+	require.Contains(t, stdout.String(), "func MustOpen(name string) int {")
+	require.NotContains(t, stdout.String(), "MustGenerate")
+	require.Contains(t, stderr.String(), "file guarded by //go:build ignore skipped")
+	stdout.Reset()
+	require.Equal(t, ExitOK, Run(ctx, []string{"-scan-ignored", files[1]}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "//go:build ignore\n")
+	require.Contains(t, stdout.String(), "func MustGenerate(name string) int {")
+}
+
+func TestOptions(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(1)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	g := New(
+		WithVersion("v1.2.3"),
+		WithNaming(func(name string) string { return name + "OrPanic" }),
+		WithFormatter("gofmt"),
+	)
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "gen_must v1.2.3")
+	require.Contains(t, buffer.String(), "\nfunc DoThingOrPanic() int {\n")
+
+	pkg, err = ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	plan, err := New(WithTag("@other")).Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Empty(t, plan.Funcs)
+}
+
+func TestTemplate(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(5)})
+	require.NoError(t, err)
+	const tmpl = `
+func {{.RecvDecl}} {{.NewName}}{{.TypeParamsDecl}}({{.ParamsDecl}}) ({{join .ResultTypes ","}}) {
+	{{join .ResultVars ","}}, {{.ErrVar}} := {{.Call}}
+	if {{.ErrVar}} != nil {
+		panic("{{.Name}}: " + {{.ErrVar}}.Error())
+	}
+	return {{join .ResultVars ","}}
+}
+`
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithTemplate(tmpl), WithFormatter("gofmt")).Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), `
+func (t2 *TypeB[T]) mustMethod() int {
+	n, err := t2.method()
+	if err != nil {
+		panic("method: " + err.Error())
+	}
+	return n
+}
+`)
+}
+
+func TestStaleWrappers(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	g := New()
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	current, err := os.ReadFile(expectedFilePath(9))
+	require.NoError(t, err)
+	stale, err := g.StaleWrappers(plan, current)
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	current = bytes.Replace(current, []byte("n, err := zed()"), []byte("n, err := alpha()"), 1)
+	stale, err = g.StaleWrappers(plan, current)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	require.Equal(t, "mustZed", stale[0].NewName)
+}
+
+func TestEmitTestsAndBenchmarks(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	emitters := map[string]func(*Generator, *Plan) error{
+		"testpkg_9_test.go.expected":       (*Generator).EmitTests,
+		"testpkg_9_bench_test.go.expected": (*Generator).EmitBenchmarks,
+	}
+	for expected, emit := range emitters {
+		buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+		require.NoError(t, emit(NewGenerator(buffer), plan))
+		fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+		require.NoError(t, GoFmt(buffer, fmtCode))
+		exp, err := os.ReadFile(filepath.Join("testdata", "testpkg", expected))
+		require.NoError(t, err)
+		require.Equal(t, string(exp), fmtCode.String(), expected)
+	}
+	// - writes the skeletons to stdout, like -manifest
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-format", "gofmt", "-tests", "-", "-bench", "-", goFilePath(9)}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "func TestMustAlpha(t *testing.T) {")
+	require.Contains(t, stdout.String(), "func BenchmarkMustAlpha(")
+	require.NoFileExists(t, filepath.Join("testdata", "testpkg", "-"))
+}
+
+func TestReceiverFiles(t *testing.T) {
+	dir := filepath.Join("testdata", "recvpkg")
+	pkg, err := ParsePackage(ctx, []string{"./" + dir})
+	require.NoError(t, err)
+	g := New(WithMustError(true), WithFormatter("gofmt"))
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	files := ReceiverFiles(plan, filepath.Join(dir, "must.go"))
+	require.Len(t, files, 3)
+	require.Equal(t, []string{"Client", "Server"}, files[0].Plan.Parts)
+	for _, f := range files {
+		buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+		require.NoError(t, g.Generator(buffer).Emit(f.Plan))
+		fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+		require.NoError(t, g.Format(f.Path, buffer, fmtCode))
+		exp, err := os.ReadFile(f.Path + ".expected")
+		require.NoError(t, err)
+		require.Equal(t, string(exp), fmtCode.String(), f.Path)
+	}
+
+	// -check covers the files of the receiver types
+	outDir := t.TempDir()
+	args := []string{"-layout", "receiver", "-outdir", outDir, "-out", "must.go", "./" + dir}
+	require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+	require.FileExists(t, filepath.Join(outDir, "client_must.go"))
+	require.Equal(t, ExitOK, Run(ctx, append([]string{"-check"}, args...), io.Discard, io.Discard))
+	require.NoError(t, os.Remove(filepath.Join(outDir, "server_must.go")))
+	stderr := bytes.NewBuffer(nil)
+	require.Equal(t, ExitCheck, Run(ctx, append([]string{"-check"}, args...), io.Discard, stderr))
+	require.Contains(t, stderr.String(), "server_must.go is out of date")
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-layout", "receiver", "./" + dir}, io.Discard, io.Discard))
+}
+
+func TestVerifyFiles(t *testing.T) {
+	dir := filepath.Join("testdata", "recvpkg")
+	files := make(map[string][]byte)
+	for _, name := range []string{"must.go", "client_must.go", "server_must.go"} {
+		src, err := os.ReadFile(filepath.Join(dir, name+".expected"))
+		require.NoError(t, err)
+		files[filepath.Join(dir, name)] = src
+	}
+	require.NoError(t, VerifyFiles(ctx, files))
+
+	// the wrappers of the client use the error type declared in must.go
+	delete(files, filepath.Join(dir, "must.go"))
+	err := VerifyFiles(ctx, files)
+	require.ErrorIs(t, err, ErrInvalidOutput)
+	var posErr *PosError
+	require.ErrorAs(t, err, &posErr)
+	require.Contains(t, []string{"MustClose", "MustGet", "MustPort", "Listen", "Port"}, posErr.Func)
+	require.Contains(t, posErr.Error(), "undefined: MustError")
+
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-verify", "./" + dir}, io.Discard, io.Discard))
+}
+
+func TestEmitInvalidPlan(t *testing.T) {
+	// plans read from JSON aren't trusted, a spec Plan would reject is an error, not a panic
+	plans := map[error]*FuncSpec{
+		ErrAllOption:      {Name: "f", NewName: "mustF", Results: []string{"int", "error"}, Options: map[string]string{"all": "mustFAll"}},
+		ErrNoReturnValues: {Name: "g", NewName: "mustG"},
+		ErrUnknownParam:   {Name: "h", NewName: "mustH", Results: []string{"error"}, Options: map[string]string{"redact": "key"}},
+		ErrInvalidType:    {Name: "i", NewName: "mustI", Params: []Field{{Name: "m", Type: "map[string"}}, Results: []string{"error"}},
+	}
+	for want, w := range plans {
+		plan := &Plan{Package: "p", Funcs: []*FuncSpec{w}}
+		err := NewGenerator(io.Discard).Emit(plan)
+		require.ErrorIs(t, err, want)
+		var posErr *PosError
+		require.ErrorAs(t, err, &posErr)
+		require.Equal(t, w.Name, posErr.Func)
+	}
+}
+
+func TestMethodFuncs(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(18)})
+	require.NoError(t, err)
+	g := New(WithMethodFuncs(true), WithFormatter("gofmt"))
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filePath("testpkg_18_funcs.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+
+	// the skeletons pass the receiver to the wrapper
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	tests := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, NewGenerator(tests).EmitTests(plan))
+	require.Contains(t, tests.String(), "func TestMustGetByID(t *testing.T) {")
+	require.Contains(t, tests.String(), "mustGetByID(tt.recv, tt.id)")
+	bench := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, NewGenerator(bench).EmitBenchmarks(plan))
+	require.Contains(t, bench.String(), "mustGetByID(recv, id)")
+
+	// the wrappers attached by recv= call the function
+	pkg, err = ParsePackage(ctx, []string{goFilePath(17)})
+	require.NoError(t, err)
+	plan, err = g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	tests.Reset()
+	require.NoError(t, NewGenerator(tests).EmitTests(plan))
+	require.NotContains(t, tests.String(), "tt.recv.fetch(")
+	require.Contains(t, tests.String(), "tt.recv.mustFetch(tt.t)")
+}
+
+func TestRecvOption(t *testing.T) {
+	sources := map[string]string{
+		"svc.go": "package svc\n\ntype Service struct{}\n\nfunc Fetch(id int) (string, error) {\n\t//@gen_must recv=*Service\n\treturn \"\", nil\n}\n",
+	}
+	out, err := New().GenerateSources(ctx, "example.com/svc", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func (t *Service) MustFetch(id int) string {")
+	// the methods can't be declared on the types of the package of another output
+	for _, g := range []*Gen{New(WithPackage("must")), New(WithLayout(LayoutInternal))} {
+		_, err = g.GenerateSources(ctx, "example.com/svc", sources)
+		require.ErrorIs(t, err, ErrRecvOption)
+		require.ErrorContains(t, err, "svc.go:5:1: Fetch: recv=*Service")
+	}
+}
+
+func TestStream(t *testing.T) {
+	for patterns, outPkg := range map[string]string{goFilePath(9): "", goFilePath(11): "", "./testdata/extpkg": "extpkg_test"} {
+		pkg, err := ParsePackage(ctx, []string{patterns})
+		require.NoError(t, err)
+		g := New(WithPackage(outPkg), WithFormatter("gofmt"), WithLineDirectives(true))
+		require.True(t, g.CanStream())
+		want := bytes.NewBuffer(make([]byte, 0, 1024))
+		require.NoError(t, g.Generate(ctx, want, pkg))
+		plan, err := g.Plan(ctx, pkg)
+		require.NoError(t, err)
+		for _, window := range []int{0, 2, 100} {
+			got := bytes.NewBuffer(make([]byte, 0, 1024))
+			require.NoError(t, New(WithPackage(outPkg), WithFormatter("gofmt"), WithLineDirectives(true), WithWindow(window)).Stream(got, plan))
+			require.Equal(t, want.String(), got.String())
+		}
+	}
+	require.False(t, New(WithFormatter("gofumpt")).CanStream())
+}
+
+func TestStreamImports(t *testing.T) {
+	chdirModule(t, map[string]string{
+		"go.mod":     "module example.com/m\n\ngo 1.21\n",
+		"sub/sub.go": "package sub\n\ntype Conn struct{}\n",
+		"m.go": "package m\n\nimport (\n\t\"context\"\n\n\t\"example.com/m/sub\"\n)\n\n" +
+			"func Dial(ctx context.Context) (*sub.Conn, error) {\n\t//@gen_must\n\treturn nil, ctx.Err()\n}\n",
+	})
+
+	// the imports of the streamed output are grouped like goimports does
+	stderr := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "must.go", "."}, io.Discard, stderr), stderr.String())
+	require.Equal(t, ExitOK, Run(ctx, []string{"-out", "must.go", "-check", "."}, io.Discard, stderr), stderr.String())
+}
+
+// chdirModule writes files to a temporary directory, their names relative to it, and makes it the working directory
+// of the test.
+func chdirModule(t *testing.T, files map[string]string) {
+	root := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("GOFLAGS", "")
+}
+
+func TestCommandFiles(t *testing.T) {
+	src := "package m\n\nfunc Open(name string) (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n"
+	chdirModule(t, map[string]string{"go.mod": "module example.com/m\n\ngo 1.21\n", "m.go": src})
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	// the header is only in the files of the command, the outputs are only written to them
+	fsys := fstest.MapFS{
+		fsPath(filepath.Join(wd, "m.go")): {Data: []byte(src)},
+		"header.txt":                      {Data: []byte("// Header of the files.\n")},
+	}
+	written := make(map[string][]byte)
+	files := Files{FS: fsys, WriteFile: func(name string, data []byte, perm fs.FileMode) error {
+		written[name] = data
+		return nil
+	}}
+	for _, args := range [][]string{
+		{"-header-file", "header.txt", "-format", "gofmt", "-out", "must.go", "-manifest", "manifest.json", "-doc", "API.md", "."},
+		{"module", "-header-file", "header.txt", "-format", "gofmt", "-out", "must.go", "."},
+	} {
+		clear(written)
+		c, code := parseCommand(args, io.Discard, io.Discard)
+		require.Equal(t, ExitOK, code)
+		c.files = files
+		require.Equal(t, ExitOK, c.run(ctx, io.Discard, io.Discard))
+		require.Contains(t, string(written["must.go"]), "// Header of the files.\n")
+		if args[0] != "module" {
+			require.Contains(t, string(written["manifest.json"]), `"wrapper": "MustOpen"`)
+			require.Contains(t, string(written["API.md"]), "| MustOpen | `func MustOpen(name string) int` |")
+		}
+		for _, name := range []string{"must.go", "manifest.json", "API.md"} {
+			require.NoFileExists(t, name)
+		}
+	}
+	// the packages with directives are found in the files
+	_, err = New(WithFS(fstest.MapFS{})).DirectivePackages(ctx, []string{"."})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestCacheParts(t *testing.T) {
+	chdirModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.21\n",
+		"m.go": "package m\n\ntype T struct{}\n\nfunc (t *T) Get() (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n\n" +
+			"func Open(name string) (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n",
+	})
+	args := []string{"-cache", "cache", "-format", "gofmt", "-layout", "receiver", "-out", "must.go", "-doc", "API.md", "."}
+	// the files written are files of the package: the second run is the first one with the same key
+	for i := 0; i < 2; i++ {
+		require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+	}
+	// the run isn't skipped when the doc or the file of a receiver was edited since
+	for _, name := range []string{"API.md", "t_must.go"} {
+		exp, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(name, []byte("// edited\n"), 0o644))
+		require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+		got, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, string(exp), string(got))
+	}
+}
+
+func TestCacheGoVersion(t *testing.T) {
+	chdirModule(t, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.17\n",
+		"m.go": "package m\n\nfunc Open(name string) (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n\n" +
+			"func Get[T any](v T) (T, error) {\n\t//@gen_must\n\treturn v, nil\n}\n",
+	})
+	args := []string{"-cache", "cache", "-format", "gofmt", "-out", "must.go", "."}
+	require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+	out, err := os.ReadFile("must.go")
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "func MustGet")
+
+	// the generic functions are wrapped once the module moves to go1.18, the cache doesn't keep the output
+	require.NoError(t, os.WriteFile("go.mod", []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.Equal(t, ExitOK, Run(ctx, args, io.Discard, io.Discard))
+	out, err = os.ReadFile("must.go")
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustGet")
+}
+
+func TestStreamFailure(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	g := New(WithFormatter("gofmt"))
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	outPath := filepath.Join(t.TempDir(), "out.go")
+	require.NoError(t, streamOutput(g, io.Discard, outPath, plan))
+	want, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+
+	// the head and the first wrappers are written before the last one fails
+	invalid := *plan.Funcs[len(plan.Funcs)-1]
+	invalid.NewName = "not a name"
+	plan.Funcs = append(plan.Funcs, &invalid)
+	require.ErrorIs(t, streamOutput(g, io.Discard, outPath, plan), ErrInvalidName)
+	got, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(got))
+	entries, err := os.ReadDir(filepath.Dir(outPath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// a new file isn't created
+	newPath := filepath.Join(filepath.Dir(outPath), "new.go")
+	require.Error(t, streamOutput(g, io.Discard, newPath, plan))
+	require.NoFileExists(t, newPath)
+}
+
+func TestManifest(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	m, err := New().Generator(io.Discard).Manifest(plan, "musts.gen.go")
+	require.NoError(t, err)
+	require.NoError(t, m.Write(buffer))
+	m = &Manifest{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), m))
+	require.Equal(t, "testpkg", m.Package)
+	require.Len(t, m.Wrappers, 4)
+	require.Equal(t, ManifestEntry{
+		Kind:    KindWrapper,
+		Func:    "TypeA.first",
+		Wrapper: "mustFirst",
+		Recv:    "*TypeA",
+		Variant: VariantMust,
+		File:    "musts.gen.go",
+		Pos:     plan.Funcs[2].Pos,
+	}, m.Wrappers[2])
+}
+
+func TestManifestEmitted(t *testing.T) {
+	sources := map[string]string{
+		"svc.go": `package svc
+
+type Client struct {
+	//@gen_must
+	addr string
+}
+
+func (c *Client) Get(path string) (string, error) {
+	return "", nil
+}
+
+func NewClient(addr string) (*Client, error) {
+	//@gen_must
+	return &Client{addr: addr}, nil
+}
+
+func Parse(s string) (int, error) {
+	//@gen_must all=true
+	return 0, nil
+}
+
+func Fetch(id int) (string, error) {
+	//@gen_must recv=*Client
+	return "", nil
+}
+`,
+	}
+	pkg, err := ParseSources("example.com/svc", sources)
+	require.NoError(t, err)
+	g := New(WithFS(fstest.MapFS{"svc.go": {Data: []byte(sources["svc.go"])}}), WithFactory(true))
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	m, err := g.Generator(io.Discard).Manifest(plan, "")
+	require.NoError(t, err)
+	var got []string
+	for _, e := range m.Wrappers {
+		got = append(got, strings.Join([]string{e.Kind, e.Func, e.Recv, e.Wrapper}, " "))
+	}
+	// every declaration of the output, with the receiver it's declared on
+	require.Equal(t, []string{
+		"wrapper NewClient  MustNewClient",
+		"wrapper Parse  MustParse",
+		"all Parse  MustParseAll",
+		"wrapper Fetch *Client MustFetch",
+		"decorator Client  ClientMust",
+		"decorator Client *Client Must",
+		"wrapper Client.Get ClientMust Get",
+		"factory   MustFactory",
+		"factory NewClient MustFactory NewClient",
+	}, got)
+	// the doc lists the same declarations
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generator(buffer).EmitDoc("API.md", []PlanFile{{Plan: plan}}))
+	for _, row := range []string{
+		"| MustParseAll | `func MustParseAll(s []string) []int` |",
+		"## Client\n\n| Declaration | Signature | Wraps |\n|---|---|---|\n| MustFetch | `func (t *Client) MustFetch(id int) string` |",
+		"| ClientMust | `type ClientMust struct{ *Client }` | [Client](svc.go#L3) |",
+		"| Must | `func (v *Client) Must() ClientMust` |",
+		"| Get | `func (m ClientMust) Get(path string) string` | [Client.Get](svc.go#L8) |",
+		"| MustFactory | `type MustFactory struct{}` |  |",
+		"| NewClient | `func (MustFactory) NewClient(addr string) *Client` |",
+	} {
+		require.Contains(t, buffer.String(), row)
+	}
+}
+
+func TestEmitDoc(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	docPath, err := filepath.Abs(filepath.Join("testdata", "API.md"))
+	require.NoError(t, err)
+	require.NoError(t, New().Generator(buffer).EmitDoc(docPath, []PlanFile{{Plan: plan}}))
+	exp, err := os.ReadFile(filepath.Join("testdata", "testpkg", "testpkg_9.md.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+}
+
+func TestLineDirectives(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithLineDirectives(true), WithFormatter("gofmt")).Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "except it panics on error\n//\n//line testpkg_0.go:3\nfunc mustDoThing() int {\n")
+}
+
+func TestPanicArgs(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(12)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithPanicArgs(true), WithRedactTypes("Token"), WithFormatter("gofmt")).Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filePath("testpkg_12_panic.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+}
+
+func TestStack(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	g := New(WithStack(true), WithFormatter("gofmt"))
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "import (\n\t\"runtime\"\n)\n\n// mustStackError is")
+	require.Contains(t, buffer.String(), "\t\tpanic(mustStack(err))\n")
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	streamed := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Stream(streamed, plan))
+	require.Equal(t, buffer.String(), streamed.String())
+}
+
+func TestMustError(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithMustError(true), WithFormatter("gofmt")).Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "package testpkg\n\n// MustError is the error the wrappers panic with.\ntype MustError struct {\n")
+	require.Contains(t, buffer.String(), "\t\tpanic(&MustError{Func: \"mustAlpha\", Err: err})\n")
+	require.Contains(t, buffer.String(), "\t\tpanic(&MustError{Func: \"TypeA.mustFirst\", Err: err})\n")
+}
+
+func TestMetrics(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithMetrics(true), WithFormatter("gofmt")).Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "\nvar OnMustFailure func(wrapper string, err error)\n")
+	require.Contains(t, buffer.String(), "\t\tif OnMustFailure != nil {\n\t\t\tOnMustFailure(\"mustDoThing\", err)\n\t\t}\n\t\tpanic(err)\n")
+}
+
+func TestTracing(t *testing.T) {
+	plan := &Plan{Package: "p", Imports: []Import{{Path: "context"}}, Funcs: []*FuncSpec{
+		{Name: "fetch", NewName: "mustFetch", Params: []Field{{Name: "ctx", Type: "context.Context"}}, Results: []string{"error"}},
+		{Name: "close", NewName: "mustClose", Results: []string{"error"}},
+	}}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, New(WithTracing(true), WithFormatter("gofmt")).Generator(buffer).Emit(plan))
+	fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoFmt(buffer, fmtCode))
+	require.Contains(t, fmtCode.String(), "\nvar RecordMustError func(ctx context.Context, err error)\n")
+	require.Contains(t, fmtCode.String(), "\t\tif RecordMustError != nil {\n\t\t\tRecordMustError(ctx, err)\n\t\t}\n\t\tpanic(err)\n")
+	require.Equal(t, 1, strings.Count(fmtCode.String(), "RecordMustError(ctx, err)"))
+
+	// the package of the type is told by the imports, not by its qualifier
+	sources := map[string]string{"a.go": "package a\n\nimport stdctx \"context\"\n\n" +
+		"func Fetch(ctx stdctx.Context) error {\n\t//@gen_must\n\treturn nil\n}\n"}
+	out, err := New(WithTracing(true)).GenerateSources(ctx, "example.com/a", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "RecordMustError(ctx, err)")
+	plan.Imports = []Import{{Name: "context", Path: "example.com/context"}}
+	plan.Funcs = plan.Funcs[:1]
+	buffer.Reset()
+	require.NoError(t, New(WithTracing(true)).Generator(buffer).EmitWrappers(plan))
+	require.NotContains(t, buffer.String(), "RecordMustError")
+}
+
+func TestFactory(t *testing.T) {
+	plan := &Plan{Package: "p", Funcs: []*FuncSpec{
+		{Name: "NewConfig", NewName: "MustNewConfig", Params: []Field{{Name: "opts", Type: "...int"}}, Results: []string{"*Config", "error"}},
+		{Name: "NewList", NewName: "MustNewList", TypeParams: []Field{{Name: "T", Type: "any"}}, Results: []string{"error"}},
+		{Name: "open", NewName: "mustOpen", Results: []string{"error"}},
+	}}
+	g := New(WithFactory(true), WithFormatter("gofmt"))
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generator(buffer).Emit(plan))
+	fmtCode := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, GoFmt(buffer, fmtCode))
+	require.Contains(t, fmtCode.String(), "type MustFactory struct{}\n\n"+
+		"// NewConfig calls MustNewConfig, it panics on error\n"+
+		"func (MustFactory) NewConfig(opts ...int) *Config {\n\treturn MustNewConfig(opts...)\n}\n")
+	require.NotContains(t, fmtCode.String(), ") NewList(")
+	require.NotContains(t, fmtCode.String(), ") open(")
+	streamed := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Stream(streamed, plan))
+	require.Equal(t, fmtCode.String(), streamed.String())
+}
+
+func TestDecorator(t *testing.T) {
+	g := New(WithFormatter("gofmt"))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "decopkg")})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filepath.Join("testdata", "decopkg", "decopkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+	plan, err := g.Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Len(t, plan.Decorators, 2)
+	streamed := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Stream(streamed, plan))
+	require.Equal(t, string(exp), streamed.String())
+	_, err = New(WithPackage("decopkg_test")).Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrForeignReceiver)
+}
+
+func TestCgo(t *testing.T) {
+	g := New(WithFormatter("gofmt"))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "cgopkg")})
+	require.NoError(t, err)
+	if len(pkg.GoFiles) == 1 {
+		t.Skip("cgo isn't enabled")
+	}
+	// the directives are read from the source files, not from the ones rewritten by cgo
+	for _, file := range pkg.Syntax {
+		require.Contains(t, pkg.GoFiles, pkg.Fset.File(file.Pos()).Name())
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filepath.Join("testdata", "cgopkg", "cgopkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+}
+
+func TestIter(t *testing.T) {
+	// the go version of the module, read from its go.mod, is older than go1.23, without the iter package
+	logs := &bytes.Buffer{}
+	g := New(WithFormatter("gofmt"), WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "iterpkg")})
+	require.NoError(t, err)
+	require.NotNil(t, pkg.Module)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	require.NotContains(t, buffer.String(), "func mustRecords")
+	require.Contains(t, logs.String(), "iterators need go1.23, the output targets go"+pkg.Module.GoVersion+"\"")
+
+	g = New(WithFormatter("gofmt"), WithLang("go1.23"))
+	buffer.Reset()
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filepath.Join("testdata", "iterpkg", "iterpkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+}
+
+func TestImportAliases(t *testing.T) {
+	// the qualified types are written like in the file of the function, with the same import names
+	g := New(WithFormatter("gofmt"))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "aliaspkg")})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	exp, err := os.ReadFile(filepath.Join("testdata", "aliaspkg", "aliaspkg.go.expected"))
+	require.NoError(t, err)
+	require.Equal(t, string(exp), buffer.String())
+
+	pkg, err = g.Load(ctx, []string{"./" + filepath.Join("testdata", "aliaspkg", "conflict")})
+	require.NoError(t, err)
+	_, err = g.Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrImportConflict)
+
+	for importPath, name := range map[string]string{
+		"net/url":                     "url",
+		"gopkg.in/yaml.v3":            "yaml",
+		"github.com/jackc/pgx/v5":     "pgx",
+		"github.com/mattn/go-sqlite3": "sqlite3",
+	} {
+		require.Equal(t, name, guessPackageName(importPath), importPath)
+	}
+}
+
+func TestDotImports(t *testing.T) {
+	name := filepath.Join("testdata", "dotpkg", "dotpkg.go")
+	pkg, err := New().Load(ctx, []string{"./" + name})
+	require.NoError(t, err)
+	_, err = New().Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrDotImport)
+
+	// the type information tells the identifiers of the dot-imports from the ones of the package
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+	require.NoError(t, err)
+	info := &types.Info{Uses: map[*ast.Ident]types.Object{}, Defs: map[*ast.Ident]types.Object{}}
+	tpkg, err := (&types.Config{Importer: importer.ForCompiler(fset, "source", nil)}).Check("dotpkg", fset, []*ast.File{file}, info)
+	require.NoError(t, err)
+	exp := map[string]struct {
+		params, results []string
+	}{
+		"Wait":  {[]string{"time.Duration"}, []string{"time.Time", "error"}},
+		"Start": {[]string{"time.Time"}, []string{"*Clock", "error"}},
+	}
+	for _, decl := range file.Decls {
+		fnDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		p := &planner{fset: fset, fn: fnDecl, info: info, file: file, scope: tpkg.Scope()}
+		w, err := p.planWrapper(&directive{name: "Must" + fnDecl.Name.Name})
+		require.NoError(t, err)
+		require.Equal(t, exp[w.Name].params, fieldTypes(w.Params), w.Name)
+		require.Equal(t, exp[w.Name].results, w.Results, w.Name)
+		require.Equal(t, []Import{{Path: "time"}}, p.imports, w.Name)
+	}
+}
+
+func TestTypeInfoRendering(t *testing.T) {
+	// the syntax alone only renders a subset of the types
+	name := filepath.Join("testdata", "renderpkg", "renderpkg.go")
+	pkg, err := New().Load(ctx, []string{"./" + name})
+	require.NoError(t, err)
+	_, err = New().Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrUnknownFieldType)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+	require.NoError(t, err)
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}, Uses: map[*ast.Ident]types.Object{}, Defs: map[*ast.Ident]types.Object{}}
+	tpkg, err := (&types.Config{Importer: importer.ForCompiler(fset, "source", nil)}).Check("renderpkg", fset, []*ast.File{file}, info)
+	require.NoError(t, err)
+	fnDecl := file.Decls[1].(*ast.FuncDecl)
+	p := &planner{fset: fset, fn: fnDecl, info: info, file: file, scope: tpkg.Scope()}
+	w, err := p.planWrapper(&directive{name: "MustCopy"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"map[string][]int", "func(io.Reader) (int, error)", "<-chan struct{}", "*str.Builder", "...func()"}, fieldTypes(w.Params))
+	require.Equal(t, []string{"[]io.Writer", "error"}, w.Results)
+	require.Equal(t, []Import{{Path: "io"}, {Name: "str", Path: "strings"}}, p.imports)
+}
+
+func TestHooks(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	g := New(
+		WithOnFunction(func(fnDecl *ast.FuncDecl, w *FuncSpec) (bool, error) {
+			if w.Recv != nil {
+				return true, nil
+			}
+			w.NewName = strings.Replace(w.NewName, "must", "panicking", 1)
+			return false, nil
+		}),
+		WithAfterFile(func(path string, src []byte) ([]byte, error) {
+			return append(src, "// post-processed\n"...), nil
+		}),
+	)
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "\nfunc panickingAlpha() int {\n")
+	require.Contains(t, buffer.String(), "\nfunc panickingZed() int {\n")
+	require.NotContains(t, buffer.String(), "mustFirst")
+	require.True(t, strings.HasSuffix(buffer.String(), "}\n// post-processed\n"))
+
+	hookErr := errors.New("vetoed")
+	g = New(WithOnFunction(func(*ast.FuncDecl, *FuncSpec) (bool, error) { return false, hookErr }))
+	require.ErrorIs(t, g.Generate(ctx, io.Discard, pkg), hookErr)
+}
+
+func TestContext(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(0)})
+	require.NoError(t, err)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = New().Plan(canceled, pkg)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = ParsePackage(canceled, []string{goFilePath(0)})
+	require.Error(t, err)
+}
+
+func TestWalkOrder(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg := &packages.Package{Name: "many", Fset: fset}
+	var want []string
+	for i := 0; i < 64; i++ {
+		src := fmt.Sprintf("package many\n\nfunc F%d() error {\n\t//@gen_must\n\treturn nil\n}\n", i)
+		file, err := parser.ParseFile(fset, fmt.Sprintf("f%d.go", i), src, parser.ParseComments)
+		require.NoError(t, err)
+		pkg.Syntax = append(pkg.Syntax, file)
+		want = append(want, fmt.Sprintf("MustF%d", i))
+	}
+	var got []string
+	require.NoError(t, WalkPackage(pkg, DefaultTag, func(newName string, _ *ast.FuncDecl) error {
+		got = append(got, newName)
+		return nil
+	}))
+	require.Equal(t, want, got)
+}
+
+func TestKeepGoing(t *testing.T) {
+	g := New(WithKeepGoing(true))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "errpkg")})
+	require.NoError(t, err)
+	plan, err := g.Plan(ctx, pkg)
+	var list ErrorList
+	require.ErrorAs(t, err, &list)
+	require.Len(t, list, 9)
+	require.ErrorIs(t, err, ErrUnknownFieldType)
+	require.ErrorIs(t, err, ErrAllOption)
+	require.Len(t, plan.Funcs, 1)
+	require.Equal(t, "parse", plan.Funcs[0].Name)
+	_, err = New().Plan(ctx, pkg)
+	require.False(t, errors.As(err, &list))
+
+	// the wrappers are generated, the errors reported and the run fails
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"-strict", "-keep-going", "./" + filepath.Join("testdata", "errpkg")}, stdout, stderr))
+	require.Contains(t, stdout.String(), "func mustParse(s string) int {")
+	require.Contains(t, stderr.String(), "errpkg_0.go:3:16: noError: no error returned\n")
+	require.Contains(t, stderr.String(), "errpkg_7.go:3:1: join: ")
+}
+
+func TestVetDirectives(t *testing.T) {
+	dir := "./" + filepath.Join("testdata", "vetpkg")
+	g := New()
+	pkgs, err := g.LoadPackages(ctx, []string{dir})
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	err = g.Vet(ctx, pkgs[0])
+	var list ErrorList
+	require.ErrorAs(t, err, &list)
+	exp := []struct {
+		line int
+		fn   string
+		err  error
+	}{
+		{3, "vetpkg.go", ErrStrayDirective},
+		{5, "Client", ErrInvalidName},
+		{9, "open", ErrUnknownOption},
+		{14, "close", ErrInvalidName},
+		{19, "size", ErrNoErrorReturn},
+		{26, "parse", ErrStrayDirective},
+	}
+	require.Len(t, list, len(exp))
+	for i, e := range exp {
+		var posErr *PosError
+		require.ErrorAs(t, list[i], &posErr)
+		require.Equal(t, e.line, posErr.Pos.Line, posErr.Error())
+		require.Equal(t, e.fn, posErr.Func)
+		require.ErrorIs(t, posErr, e.err)
+	}
+
+	// nothing is generated, the findings are reported with their position
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"vet-directives", dir}, stdout, stderr))
+	require.Empty(t, stdout.String())
+	require.Contains(t, stderr.String(), "vetpkg.go:9:1: open: unknown directive option: varaint\n")
+	stderr.Reset()
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"vet-directives", "-json", dir}, stdout, stderr))
+	require.Contains(t, stderr.String(), `"code":"error","message":"unknown directive option: varaint"`)
+	require.Contains(t, stderr.String(), `"code":"unsupported","message":"no error returned"`)
+	require.Equal(t, ExitOK, Run(ctx, []string{"vet-directives", "./" + filepath.Join("testdata", "handpkg")}, stdout, stderr))
+	require.Equal(t, ExitUsage, Run(ctx, []string{"vet-directives"}, stdout, io.Discard))
+}
+
+func TestSuggest(t *testing.T) {
+	g := New()
+	pkgs, err := g.LoadPackages(ctx, []string{"./" + filepath.Join("testdata", "suggestpkg") + "/..."})
+	require.NoError(t, err)
+	var got []string
+	for _, s := range g.Suggest(pkgs, 1) {
+		got = append(got, fmt.Sprintf("%s.%s %d", path.Base(s.PkgPath), s.Func, len(s.Calls)))
+	}
+	// the tagged, the unexported functions and the calls handling their error aren't suggested
+	require.Equal(t, []string{"conf.Parse 2", "conf.Check 1", "conf.Load 1"}, got)
+	require.Len(t, g.Suggest(pkgs, 2), 1)
+
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"suggest", "-min", "2", "-v", "./" + filepath.Join("testdata", "suggestpkg") + "/..."}, stdout, io.Discard))
+	require.Regexp(t, `^\S+conf.go:5:1: \S+/suggestpkg/conf.Parse: 2 calls panic on its error\n\t\S+main.go:18:12\n\t\S+main.go:24:15\n$`, stdout.String())
+}
+
+func TestCoverage(t *testing.T) {
+	dir := "./" + filepath.Join("testdata", "coveragepkg")
+	g := New()
+	pkgs, err := g.LoadPackages(ctx, []string{dir})
+	require.NoError(t, err)
+	cov, err := g.Coverage(ctx, pkgs[0])
+	require.NoError(t, err)
+	// Open is tagged, Dial wrapped by hand and Close by the generated file, the methods of conn and the functions
+	// without error don't count
+	require.Equal(t, 4, cov.Funcs)
+	require.Equal(t, 3, cov.Covered)
+	require.Equal(t, []string{"Client.Get"}, cov.Missing)
+	require.Equal(t, 75.0, cov.Percent())
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"coverage", "-v", "-min-coverage", "75", dir}, stdout, stderr))
+	require.Equal(t, "github.com/heliorosa/gen_must/mustgen/testdata/coveragepkg: 3/4 functions with a Must counterpart (75.0%)\n\tClient.Get\n", stdout.String())
+	require.Equal(t, ExitCoverage, Run(ctx, []string{"coverage", "-min-coverage", "80", dir}, io.Discard, stderr))
+	require.Contains(t, stderr.String(), "coverage below 80%: github.com/heliorosa/gen_must/mustgen/testdata/coveragepkg")
+	require.Equal(t, ExitUsage, Run(ctx, []string{"coverage", "-min-coverage", "101", dir}, io.Discard, io.Discard))
+}
+
+// TestPluginProcess is the plugin run by TestPlugin, it's skipped unless run by gen_must.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("GEN_MUST_TEST_PLUGIN") == "" {
+		t.Skip("run as a plugin by TestPlugin")
+	}
+	req := &PluginRequest{}
+	if err := json.NewDecoder(os.Stdin).Decode(req); err != nil {
+		os.Exit(2)
+	}
+	resp := &PluginResponse{Imports: []Import{{Path: "log"}}}
+	if req.Func.Name == "fails" {
+		resp.Error = "no policy for fails"
+	} else {
+		resp.Code = fmt.Sprintf("func %s() { log.Println(%q, %d) }", req.Func.NewName, req.Package, req.Version)
+	}
+	json.NewEncoder(os.Stdout).Encode(resp)
+	os.Exit(0)
+}
+
+func TestPlugin(t *testing.T) {
+	t.Setenv("GEN_MUST_TEST_PLUGIN", "1")
+	command := os.Args[0] + " -test.run=^TestPluginProcess$"
+	plan := &Plan{Package: "audited", Funcs: []*FuncSpec{
+		{Name: "load", NewName: "mustLoad", Results: []string{"error"}, Options: map[string]string{"variant": "audit"}},
+		{Name: "Open", NewName: "MustOpen", Results: []string{"error"}},
+	}}
+	buffer := &bytes.Buffer{}
+	gen := NewGenerator(buffer)
+	require.ErrorIs(t, gen.Emit(plan), ErrUnknownVariant)
+
+	buffer.Reset()
+	gen.Plugins = map[string]string{"audit": command}
+	require.NoError(t, gen.Emit(plan))
+	src := buffer.String()
+	require.Contains(t, src, "import (\n \"log\"\n)")
+	require.Contains(t, src, "func mustLoad() { log.Println(\"audited\", 1) }\n")
+	require.Contains(t, src, "func MustOpen() {")
+
+	plan.Funcs[0].Name = "fails"
+	require.ErrorContains(t, gen.Emit(plan), "fails: plugin failed: "+os.Args[0]+": no policy for fails")
+	gen.Plugins["audit"] = filepath.Join(t.TempDir(), "missing")
+	require.ErrorIs(t, gen.Emit(plan), ErrPlugin)
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-plugin", "once=cmd", goFilePath(1)}, io.Discard, io.Discard))
+}
+
+func TestGenerateSources(t *testing.T) {
+	sources := map[string]string{
+		"conf.go":      "package conf\n\nfunc Load(path string) (*Config, error) {\n\t//@gen_must\n\treturn nil, nil\n}\n",
+		"types.go":     "package conf\n\ntype Config struct{}\n",
+		"conf_test.go": "package conf_test\n",
+	}
+	out, err := New(WithLang("go1.21")).GenerateSources(ctx, "example.com/conf", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "package conf\n")
+	require.Contains(t, string(out), "func MustLoad(path string) *Config {\n")
+
+	sources["other.go"] = "package other\n"
+	_, err = ParseSources("example.com/conf", sources)
+	require.ErrorIs(t, err, ErrMixedPackages)
+	_, err = ParseSources("", map[string]string{"README.md": ""})
+	require.ErrorIs(t, err, ErrNoPackageFound)
+}
+
+func TestReexport(t *testing.T) {
+	sources := map[string]string{
+		"store.go": "package store\n\nimport \"errors\"\n\nconst DefaultName = \"store\"\n\nvar ErrClosed = errors.New(\"closed\")\n\n" +
+			"type Store struct{}\n\ntype Cache[T any] struct{}\n\ntype MustOpen struct{}\n\nfunc Open(name string) (*Store, error) {\n\t//@gen_must\n\treturn nil, nil\n}\n",
+	}
+	out, err := New(WithPackage("muststore"), WithReexport(true), WithLang("go1.21")).GenerateSources(ctx, "example.com/store", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "type Store = store.Store\n")
+	require.Contains(t, string(out), "const DefaultName = store.DefaultName\n")
+	require.Contains(t, string(out), "var ErrClosed = store.ErrClosed\n")
+	require.Contains(t, string(out), "func MustOpen(name string) *store.Store {\n")
+	// the generic types and the names of the wrappers aren't re-exported
+	require.NotContains(t, string(out), "Cache")
+	require.NotContains(t, string(out), "type MustOpen")
+	// nothing to re-export in the package itself
+	out, err = New(WithReexport(true), WithLang("go1.21")).GenerateSources(ctx, "example.com/store", sources)
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "store.Store")
+
+	pkg, err := ParsePackage(ctx, []string{"./" + filepath.Join("testdata", "typespkg")})
+	require.NoError(t, err)
+	plan, err := New(WithPackage("typespkg_test"), WithReexport(true)).PlanTypes(pkg, "Close")
+	require.NoError(t, err)
+	require.Equal(t, []Reexport{{Kind: ReexportType, Name: "Config", Pkg: "typespkg"}}, plan.Reexports)
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-reexport", "./" + filepath.Join("testdata", "typespkg")}, io.Discard, io.Discard))
+}
+
+func TestForeignReceiver(t *testing.T) {
+	g := New(WithPackage("must"), WithFuncs("Queries.GetUser"), WithFormatter("gofmt"), WithLang("go1.21"))
+	pkg, err := g.Load(ctx, []string{"./" + filepath.Join("testdata", "targetpkg")})
+	require.NoError(t, err)
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	require.NoError(t, g.Generate(ctx, buffer, pkg))
+	require.Contains(t, buffer.String(), "func MustQueriesGetUser(q *targetpkg.Queries, ctx context.Context, id int64) targetpkg.User {\n")
+	require.Contains(t, buffer.String(), "q.GetUser(ctx, id)")
+
+	sources := map[string]string{
+		"cache.go": "package cache\n\ntype Cache[T any] struct{}\n\nfunc (c *Cache[T]) Get() (T, error) {\n\t//@gen_must\n\tvar v T\n\treturn v, nil\n}\n",
+	}
+	_, err = New(WithPackage("must")).GenerateSources(ctx, "example.com/cache", sources)
+	require.ErrorIs(t, err, ErrForeignReceiver)
+}
+
+func TestModule(t *testing.T) {
+	dir := "./" + filepath.Join("testdata", "modulepkg")
+	paths, err := New().DirectivePackages(ctx, []string{dir + "/..."})
+	require.NoError(t, err)
+	require.Equal(t, []string{"github.com/heliorosa/gen_must/mustgen/testdata/modulepkg/store"}, paths)
+
+	// -diff prints the outputs without writing them, the summary goes to stderr
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitCheck, Run(ctx, []string{"module", "-diff", "-out", "must.go", dir + "/..."}, stdout, stderr))
+	require.Contains(t, stdout.String(), "+func MustOpen(name string) *Store {")
+	require.NotContains(t, stdout.String(), "packages with directives")
+	require.Contains(t, stderr.String(), "github.com/heliorosa/gen_must/mustgen/testdata/modulepkg/store: out of date\n")
+	require.Contains(t, stderr.String(), "packages with directives: 1, failed: 1\n")
+	// with -json, the summary is made of diagnostics
+	stderr.Reset()
+	require.Equal(t, ExitCheck, Run(ctx, []string{"module", "-json", "-diff", "-out", "must.go", dir + "/..."}, io.Discard, stderr))
+	var summary []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		var d Diagnostic
+		require.NoError(t, json.Unmarshal([]byte(line), &d))
+		if d.Code == "summary" {
+			summary = append(summary, d.Message)
+		}
+	}
+	require.Equal(t, []string{
+		"github.com/heliorosa/gen_must/mustgen/testdata/modulepkg/store: out of date",
+		"packages with directives: 1, failed: 1",
+	}, summary)
+	require.NoFileExists(t, filepath.Join(dir, "store", "must.go"))
+	require.NoFileExists(t, filepath.Join(dir, "util", "must.go"))
+	require.Equal(t, ExitUsage, Run(ctx, []string{"module", dir + "/..."}, io.Discard, io.Discard))
+}
+
+func TestDuplicateNames(t *testing.T) {
+	sources := map[string]string{
+		"a.go": "package a\n\nfunc Open() (int, error) {\n\t//@gen_must MustLoad\n\treturn 0, nil\n}\n",
+		"b.go": "package a\n\nfunc Load() (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n",
+	}
+	_, err := New().GenerateSources(ctx, "example.com/a", sources)
+	require.ErrorIs(t, err, ErrDuplicateName)
+	require.EqualError(t, err, "b.go:4:2: Load: duplicate wrapper name MustLoad, also requested by Open at a.go:4:2")
+	pkg, err := ParseSources("example.com/a", sources)
+	require.NoError(t, err)
+	fsys := fstest.MapFS{"a.go": {Data: []byte(sources["a.go"])}, "b.go": {Data: []byte(sources["b.go"])}}
+	plan, err := New(WithKeepGoing(true), WithFS(fsys)).Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrDuplicateName)
+	require.Len(t, plan.Funcs, 1)
+
+	// the stacked directives are told apart by their position
+	sources = map[string]string{"a.go": "package a\n\nfunc Open() (int, error) {\n\t//@gen_must\n\t//@gen_must\n\treturn 0, nil\n}\n"}
+	_, err = New().GenerateSources(ctx, "example.com/a", sources)
+	require.EqualError(t, err, "a.go:5:2: Open: duplicate wrapper name MustOpen, also requested by Open at a.go:4:2")
+
+	// the wrappers of build constraints that can't both be satisfied don't collide
+	for _, tc := range []struct {
+		a, b    string
+		collide bool
+	}{
+		{"a_linux.go", "a_windows.go", false},
+		{"a_linux.go", "a_amd64.go", true},
+		{"a_linux.go", "a_android.go", true},
+		{"a_linux_amd64.go", "a_linux_arm64.go", false},
+		{"//go:build debug\n\npackage a", "//go:build !debug\n\npackage a", false},
+		{"//go:build debug\n\npackage a", "//go:build linux\n\npackage a", true},
+		{"//go:build debug && linux\n\npackage a", "//go:build !debug || windows\n\npackage a", false},
+		{"//go:build (debug || race) && !linux\n\npackage a", "//go:build race && !windows\n\npackage a", true},
+	} {
+		sources = make(map[string]string, 2)
+		fsys = make(fstest.MapFS, 2)
+		for i, src := range []string{tc.a, tc.b} {
+			name := src
+			if strings.HasPrefix(src, "//go:build") {
+				name = fmt.Sprintf("file%d.go", i)
+			} else {
+				src = "package a"
+			}
+			sources[name] = src + "\n\nfunc Open() (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n"
+			fsys[name] = &fstest.MapFile{Data: []byte(sources[name])}
+		}
+		pkg, err = ParseSources("example.com/a", sources)
+		require.NoError(t, err)
+		plan, err = New(WithKeepGoing(true), WithFS(fsys)).Plan(ctx, pkg)
+		if tc.collide {
+			require.ErrorIs(t, err, ErrDuplicateName, "%s and %s", tc.a, tc.b)
+			require.Len(t, plan.Funcs, 1)
+		} else {
+			require.NoError(t, err, "%s and %s", tc.a, tc.b)
+			require.Len(t, plan.Funcs, 2)
+		}
+	}
+}
+
+func TestLooseDirectives(t *testing.T) {
+	sources := map[string]string{
+		"a.go": "package a\n\nfunc Open() (int, error) {\n\t// @gen_must\n\treturn 0, nil\n}\n\n" +
+			"func Load() (int, error) {\n\t/*@gen_must MustLoadIt*/\n\treturn 0, nil\n}\n\n" +
+			"func Close() error {\n\t//@GEN_MUST\n\treturn nil\n}\n",
+	}
+	out, err := New(WithLooseDirectives(true)).GenerateSources(ctx, "example.com/a", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustOpen() int {\n")
+	require.Contains(t, string(out), "func MustLoadIt() int {\n")
+	require.Contains(t, string(out), "func MustClose() {\n")
+
+	// without the option they are only reported
+	sources["b.go"] = "package a\n\n// @gen_must\nfunc Sum(a, b int) (int, error)\n"
+	logs := bytes.NewBuffer(make([]byte, 0, 1024))
+	out, err = New(WithLogger(slog.New(slog.NewTextHandler(logs, nil)))).GenerateSources(ctx, "example.com/a", sources)
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "func Must")
+	require.Equal(t, 4, strings.Count(logs.String(), `msg="not a directive, only read with loose directives"`), logs.String())
+	for _, pos := range []string{"a.go:4:2", "a.go:9:2", "a.go:14:2", "b.go:3:1"} {
+		require.Contains(t, logs.String(), pos+"\n")
+	}
+	delete(sources, "b.go")
+	pkg, err := ParseSources("example.com/a", sources)
+	require.NoError(t, err)
+	err = New().Vet(ctx, pkg)
+	var list ErrorList
+	require.ErrorAs(t, err, &list)
+	require.Len(t, list, 3)
+	require.ErrorIs(t, list[0], ErrNearMissDirective)
+	require.NoError(t, New(WithLooseDirectives(true)).Vet(ctx, pkg))
+}
+
+func TestStackedDirectives(t *testing.T) {
+	sources := map[string]string{
+		"conf.go": "package conf\n\ntype Config struct{}\n\nfunc Load(path string) (*Config, error) {\n\t//@gen_must\n" +
+			"\t//@gen_must LoadConfig redact=path\n\t// the following one isn't read\n\t//@gen_must Ignored\n\treturn nil, nil\n}\n",
+	}
+	out, err := New(WithLang("go1.21")).GenerateSources(ctx, "example.com/conf", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustLoad(path string) *Config {\n")
+	require.Contains(t, string(out), "func LoadConfig(path string) *Config {\n")
+	require.NotContains(t, string(out), "Ignored")
+	pkg, err := ParseSources("example.com/conf", sources)
+	require.NoError(t, err)
+	err = New().Vet(ctx, pkg)
+	require.ErrorIs(t, err, ErrStrayDirective)
+	require.ErrorContains(t, err, "conf.go:9:2: Load:")
+
+	// the stacked directives need names of their own
+	sources["conf.go"] = "package conf\n\nfunc Open() (int, error) {\n\t//@gen_must\n\t//@gen_must variant=once\n\treturn 0, nil\n}\n"
+	_, err = New(WithLang("go1.21")).GenerateSources(ctx, "example.com/conf", sources)
+	require.ErrorIs(t, err, ErrDuplicateName)
+}
+
+func TestBodilessFuncs(t *testing.T) {
+	sources := map[string]string{
+		"sqrt.go": "package fastmath\n\n// Sqrt is implemented in assembly.\n//\n//@gen_must\n//@gen_must: SqrtOrPanic\n//go:noescape\n" +
+			"func Sqrt(x float64) (float64, error)\n\n//@gen_must\nfunc Abs(x float64) (float64, error) {\n\treturn x, nil\n}\n",
+	}
+	out, err := New(WithLang("go1.21")).GenerateSources(ctx, "example.com/fastmath", sources)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "func MustSqrt(x float64) float64 {\n")
+	require.Contains(t, string(out), "func SqrtOrPanic(x float64) float64 {\n")
+	// the doc comment of a function with a body isn't read
+	require.NotContains(t, string(out), "MustAbs")
+	pkg, err := ParseSources("example.com/fastmath", sources)
+	require.NoError(t, err)
+	err = New().Vet(ctx, pkg)
+	var list ErrorList
+	require.ErrorAs(t, err, &list)
+	require.Len(t, list, 1)
+	require.ErrorIs(t, list[0], ErrStrayDirective)
+	require.ErrorContains(t, list[0], "sqrt.go:10:1:")
+}
+
+func TestTargets(t *testing.T) {
+	dir := filepath.Join("testdata", "targetpkg")
+	f, err := os.Open(filepath.Join(dir, "targets.txt"))
+	require.NoError(t, err)
+	defer f.Close()
+	targets, err := ReadTargets(f)
+	require.NoError(t, err)
+	target, err := ParseTarget("Queries.Delete* redact=id")
+	require.NoError(t, err)
+	require.Equal(t, "Queries.Delete* redact=id", target.String())
+	targets = append(targets, target)
+
+	pkg, err := New().Load(ctx, []string{"./" + dir})
+	require.NoError(t, err)
+	plan, err := New(WithTargets(targets...)).Plan(ctx, pkg)
+	require.NoError(t, err)
+	var names []string
+	for _, w := range plan.Funcs {
+		names = append(names, w.NewName)
+	}
+	// New and Close don't return an error
+	require.Equal(t, []string{"MustDeleteUser", "MustFindUser", "MustGetUser"}, names)
+	require.Equal(t, map[string]string{"redact": "id"}, plan.Funcs[0].Options)
+
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-wrap-file", filepath.Join(dir, "targets.txt"), "-wrap", "Queries.Delete*", "./" + dir}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "func (q *Queries) MustDeleteUser(ctx context.Context, id int64) {")
+	require.Contains(t, stdout.String(), "func (q *Queries) MustGetUser(ctx context.Context, id int64) User {")
+
+	for _, s := range []string{"", "variant=once", "Get[", "Get* MustGet"} {
+		_, err = ParseTarget(s)
+		require.ErrorIs(t, err, ErrInvalidTarget, s)
+	}
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-wrap", "Get[", "./" + dir}, io.Discard, io.Discard))
+}
+
+func TestFuncs(t *testing.T) {
+	dir := "./" + filepath.Join("testdata", "targetpkg")
+	pkg, err := New().Load(ctx, []string{dir})
+	require.NoError(t, err)
+	// the targets are ignored, only the named functions are wrapped
+	plan, err := New(WithFuncs("Queries.GetUser"), WithTargets(Target{Pattern: "Queries.*"})).Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Len(t, plan.Funcs, 1)
+	require.Equal(t, "MustGetUser", plan.Funcs[0].NewName)
+	_, err = New(WithFuncs("Queries.GetUser", "Queries.Missing", "Missing")).Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrFuncNotFound)
+	require.ErrorContains(t, err, "function not found: Missing, Queries.Missing")
+
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-func", "Queries.DeleteUser", "-func", "Queries.FindUser", dir}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "MustDeleteUser(")
+	require.Contains(t, stdout.String(), "MustFindUser(")
+	require.NotContains(t, stdout.String(), "MustGetUser(")
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-func", "Queries.Get*", dir}, io.Discard, io.Discard))
+	require.Equal(t, ExitError, Run(ctx, []string{"-func", "Queries.Missing", dir}, io.Discard, io.Discard))
+}
+
+func TestConfig(t *testing.T) {
+	name := filepath.Join("testdata", "config", "gen_must.yaml")
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	c, err := ReadConfig(f)
+	require.NoError(t, err)
+	args, err := c.Args(0, filepath.Dir(name))
+	require.NoError(t, err)
+	require.Equal(t, []string{"-format", "gofmt", "-out", "must.go", "-wrap", "Queries.GetUser MustUser", "-wrap", "Queries.Find* redact=name", "./" + filepath.Join("testdata", "targetpkg")}, args)
+
+	// -diff prints the outputs without writing them
+	stdout := &bytes.Buffer{}
+	require.Equal(t, ExitCheck, Run(ctx, []string{"config", "-diff", name}, stdout, io.Discard))
+	require.Contains(t, stdout.String(), "+func (q *Queries) MustUser(ctx context.Context, id int64) User {")
+	require.Contains(t, stdout.String(), "+func (q *Queries) MustFindUser(ctx context.Context, name string) *User {")
+	require.NoFileExists(t, filepath.Join("testdata", "targetpkg", "must.go"))
+
+	c, err = ReadConfig(strings.NewReader(`{"packages": [{"path": "./store", "out": "must.go", "funcs": [{"select": "Open", "variant": "once"}]}]}`))
+	require.NoError(t, err)
+	require.Equal(t, "once", c.Packages[0].Funcs[0].Variant)
+	for _, src := range []string{
+		`{"packages": [{"path": "./store"}]}`,
+		`{"packages": [{"path": "./store", "out": "must.go", "output": "x.go"}]}`,
+		`{"packages": [{"path": "./store", "out": "must.go", "funcs": [{"select": "Get*", "name": "MustGet"}]}]}`,
+	} {
+		_, err = ReadConfig(strings.NewReader(src))
+		require.ErrorIs(t, err, ErrInvalidConfig, src)
+	}
+}
+
+func TestLang(t *testing.T) {
+	// the generic functions are errors, even when the unsupported signatures are skipped
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"-lang", "go1.17", goFilePath(2)}, stdout, stderr))
+	require.Contains(t, stderr.String(), "testpkg_2.go:3:1: DoStuff: not supported by the go version of the output: generic functions need go1.18, the output targets go1.17")
+	require.Equal(t, ExitOK, Run(ctx, []string{"-lang", "go1.18", goFilePath(2)}, io.Discard, io.Discard))
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-lang", "1.17", goFilePath(2)}, io.Discard, io.Discard))
+
+	pkg, err := New().Load(ctx, []string{goFilePath(13)})
+	require.NoError(t, err)
+	_, err = New(WithLang("go1.20")).Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrLanguageVersion)
+	plan, err := New(WithLang("go1.21.0")).Plan(ctx, pkg)
+	require.NoError(t, err)
+	// the plans read from JSON are checked too
+	gen := NewGenerator(io.Discard)
+	gen.Lang = "go1.20"
+	require.ErrorIs(t, gen.Emit(plan), ErrLanguageVersion)
+
+	// at the go version of the module, the once variant falls back to the default one
+	g := New()
+	w := &FuncSpec{Name: "setup", NewName: "mustSetup", Results: []string{"error"}, Options: map[string]string{"variant": VariantOnce}}
+	skip, err := g.fitLang(w, "go1.20", true)
+	require.NoError(t, err)
+	require.False(t, skip)
+	require.Equal(t, VariantMust, w.variant())
+	w.TypeParams = []Field{{Name: "T", Type: "any"}}
+	skip, err = g.fitLang(w, "go1.17", true)
+	require.NoError(t, err)
+	require.True(t, skip)
+}
+
+func TestLogger(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{goFilePath(9)})
+	require.NoError(t, err)
+	logs := bytes.NewBuffer(make([]byte, 0, 1024))
+	logger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	g := New(
+		WithLogger(logger),
+		WithOnFunction(func(fnDecl *ast.FuncDecl, w *FuncSpec) (bool, error) { return w.Recv != nil, nil }),
+	)
+	require.NoError(t, g.Generate(ctx, io.Discard, pkg))
+	require.Contains(t, logs.String(), "msg=\"function skipped\" func=first")
+	require.Contains(t, logs.String(), "msg=\"function wrapped\" func=alpha wrapper=mustAlpha")
+	require.Contains(t, logs.String(), "msg=\"output formatted\"")
+
+	logs.Reset()
+	g = New(WithLogger(logger), WithOnFunction(func(*ast.FuncDecl, *FuncSpec) (bool, error) { return true, nil }))
+	require.NoError(t, g.Generate(ctx, io.Discard, pkg))
+	require.Contains(t, logs.String(), "level=WARN msg=\"no tagged functions found\"")
+}
+
+func TestIfEmpty(t *testing.T) {
+	pattern := "./" + filepath.Join("testdata", "emptypkg")
+	pkg, err := ParsePackage(ctx, []string{pattern})
+	require.NoError(t, err)
+	_, err = New(WithIfEmpty(IfEmptyFail)).Plan(ctx, pkg)
+	require.ErrorIs(t, err, ErrNoDirectives)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "must.go")
+	stderr := &bytes.Buffer{}
+	require.Equal(t, ExitOK, Run(ctx, []string{"-if-empty", "skip", "-outdir", dir, "-out", "must.go", pattern}, io.Discard, stderr))
+	require.NoFileExists(t, out)
+	require.Contains(t, stderr.String(), "no tagged functions found")
+	require.Equal(t, ExitError, Run(ctx, []string{"-if-empty", "fail", "-outdir", dir, "-out", "must.go", pattern}, io.Discard, stderr))
+	require.NoFileExists(t, out)
+	require.Equal(t, ExitOK, Run(ctx, []string{"-outdir", dir, "-out", "must.go", pattern}, io.Discard, stderr))
+	require.FileExists(t, out)
+	require.Equal(t, ExitUsage, Run(ctx, []string{"-if-empty", "bogus", pattern}, io.Discard, stderr))
+}
+
+func TestDiagnostics(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	errFile := filepath.Join("testdata", "errpkg", "errpkg_1.go")
+	require.Equal(t, ExitUnsupported, Run(ctx, []string{"-json", "-strict", errFile}, io.Discard, stderr))
+	var d Diagnostic
+	require.NoError(t, json.Unmarshal(stderr.Bytes(), &d))
+	require.Equal(t, "error", d.Level)
+	require.Equal(t, "unsupported", d.Code)
+	require.Equal(t, "unknown field type: struct{n int}", d.Message)
+	require.Equal(t, "errpkg_1.go", filepath.Base(d.File))
+	require.Equal(t, 3, d.Line)
+	require.Equal(t, 20, d.Column)
+	require.Equal(t, "structParam", d.Function)
+
+	logs := &bytes.Buffer{}
+	logger := slog.New(NewDiagnosticHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger.With("func", "first").Info("function skipped", "pos", "a/b.go:4:2")
+	logger.Warn("no tagged functions found", "package", "a/b")
+	dec := json.NewDecoder(logs)
+	require.NoError(t, dec.Decode(&d))
+	require.Equal(t, Diagnostic{Level: "info", Code: "function-skipped", Message: "function skipped", File: "a/b.go", Line: 4, Column: 2, Function: "first"}, d)
+	d = Diagnostic{}
+	require.NoError(t, dec.Decode(&d))
+	require.Equal(t, Diagnostic{Level: "warn", Code: "no-tagged-functions-found", Message: "no tagged functions found package=a/b"}, d)
+}
+
+func TestFiles(t *testing.T) {
+	src := []byte("package src\n\nfunc f() (int, error) {\n\t//@gen_must\n\treturn 0, nil\n}\n")
+	written := map[string][]byte{}
+	files := Files{
+		FS: fstest.MapFS{
+			"src/src.go": &fstest.MapFile{Data: src},
+			"src/out.go": &fstest.MapFile{Data: []byte("package src\n")},
+		},
+		WriteFile: func(name string, data []byte, perm fs.FileMode) error {
+			written[name] = data
+			return nil
+		},
+	}
+	dir := t.TempDir()
+	diskSrc := filepath.Join(dir, "src.go")
+	require.NoError(t, os.WriteFile(diskSrc, src, 0o644))
+	exp, err := SourceDigest([]string{diskSrc}, DefaultTag)
+	require.NoError(t, err)
+	digest, err := files.SourceDigest([]string{"/src/src.go"}, DefaultTag)
+	require.NoError(t, err)
+	require.Equal(t, exp, digest)
+
+	cache := &Cache{Dir: "/cache", Files: files}
+	require.NoError(t, cache.Store("/src/out.go", digest))
+	require.Len(t, written, 1)
+	for name, data := range written {
+		require.True(t, strings.HasPrefix(name, "/cache/"))
+		files.FS.(fstest.MapFS)[fsPath(name)] = &fstest.MapFile{Data: data}
+	}
+	fresh, err := cache.Fresh("/src/out.go", digest)
+	require.NoError(t, err)
+	require.True(t, fresh)
+}
+
+func TestFilesUnchanged(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.go")
+	data := []byte("package out\n\nvar x = 1\n")
+	var files Files
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	modTime := func() time.Time {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		return info.ModTime()
+	}
+	stream := func(chunks ...string) {
+		f, err := files.Create(name, 0o644)
+		require.NoError(t, err)
+		for _, chunk := range chunks {
+			_, err = io.WriteString(f, chunk)
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Close())
+	}
+
+	require.NoError(t, files.Write(name, data, 0o644))
+	require.NoError(t, os.Chtimes(name, past, past))
+	require.NoError(t, files.Write(name, data, 0o644))
+	require.Equal(t, past, modTime())
+	stream("package out\n", "\nvar x = 1\n")
+	require.Equal(t, past, modTime())
+
+	for _, chunks := range [][]string{
+		{"package out\n", "\nvar x = 2\n"},
+		{"package out\n"},
+		{"package out\n", "\nvar x = 1\n", "var y = 2\n"},
+		{"package other\n"},
+	} {
+		require.NoError(t, os.Chtimes(name, past, past))
+		stream(chunks...)
+		require.NotEqual(t, past, modTime())
+		current, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, strings.Join(chunks, ""), string(current))
+	}
+}
+
+func TestParseDirective(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+		exp  directive
+	}{
+		{"//@gen_must", true, directive{}},
+		{"//@gen_must: newName", true, directive{name: "newName"}},
+		{"//@gen_must newName a=1 b=", true, directive{name: "newName", options: map[string]string{"a": "1", "b": ""}}},
+		{"//@gen_must: a=1", true, directive{options: map[string]string{"a": "1"}}},
+		{"//@gen_mustard", false, directive{}},
+		{"// @gen_must", false, directive{}},
+	}
+	for _, tt := range tests {
+		d, ok := parseDirective(tt.text, DefaultTag)
+		require.Equal(t, tt.ok, ok, tt.text)
+		require.Equal(t, tt.exp, d, tt.text)
+	}
+}
+
+func TestResultName(t *testing.T) {
+	tests := []struct {
+		typ string
+		exp string
+	}{
+		{"int64", "n"},
+		{"*pkg.Config", "config"},
+		{"HTTPClient", "httpClient"},
+		{"URL", "url"},
+		{"TypeB[T]", "typeB"},
+		{"[]byte", "buf"},
+		{"[]*Item", "items"},
+		{"[]Address", "addresses"},
+		{"[]string", "values"},
+		{"map[string]int", "m"},
+		{"chan int", "ch"},
+		{"func() error", "fn"},
+		{"struct{}", "v"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.exp, resultName(tt.typ), tt.typ)
+	}
+}
+
+func TestFuncSpecJSON(t *testing.T) {
+	pkg, err := ParsePackage(ctx, []string{filepath.Join("testdata", "specpkg", "specpkg.go")})
+	require.NoError(t, err)
+	plan, err := New().Plan(ctx, pkg)
+	require.NoError(t, err)
+	require.Len(t, plan.Funcs, 1)
+	spec := plan.Funcs[0]
+	require.Equal(t, "specpkg.go", filepath.Base(spec.Pos.Filename))
+	require.Equal(t, 3, spec.Pos.Line)
+	spec.Pos.Filename = "specpkg.go"
+	b, err := json.Marshal(spec)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"name": "Parse",
+		"newName": "MustParseInt",
+		"params": [{"name": "s", "type": "string"}],
+		"results": ["int", "error"],
+		"options": {"redact": "s"},
+		"pos": {"Filename": "specpkg.go", "Offset": 17, "Line": 3, "Column": 1}
+	}`, string(b))
+}
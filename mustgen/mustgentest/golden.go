@@ -0,0 +1,77 @@
+// Package mustgentest checks the output of gen_must against expected outputs, in the tests of the repositories
+// embedding the generator. It's kept out of package mustgen, which doesn't link the testing package.
+package mustgentest
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/heliorosa/gen_must/mustgen"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Suffix is the suffix of the expected output of a source file, see Golden.
+const Suffix = ".expected"
+
+// Golden generates, with opts, the wrappers of each source file of dir having an expected output: the file
+// name suffixed by Suffix, eg: client.go.expected for client.go. Each file is a subtest, failing with a diff
+// if the output differs. When the test binary declares an update flag set to true (go test -update), the expected
+// outputs are rewritten instead:
+//
+//	var _ = flag.Bool("update", false, "update the expected outputs")
+func Golden(t *testing.T, dir string, opts ...mustgen.Option) {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := false
+	if f := flag.Lookup("update"); f != nil {
+		update = f.Value.String() == "true"
+	}
+	g := mustgen.New(opts...)
+	for _, name := range names {
+		expected := name + Suffix
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if _, err := os.Stat(expected); err != nil {
+			continue
+		}
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			pkg, err := g.Load(context.Background(), []string{name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+			if err = g.Generate(context.Background(), buffer, pkg); err != nil {
+				t.Fatal(err)
+			}
+			if update {
+				if err = os.WriteFile(expected, buffer.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			exp, err := os.ReadFile(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(exp, buffer.Bytes()) {
+				diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(string(exp)),
+					B:        difflib.SplitLines(buffer.String()),
+					FromFile: expected,
+					ToFile:   expected + " (generated)",
+					Context:  3,
+				})
+				t.Errorf("the output differs from %s, run go test -update to update it:\n%s", expected, diff)
+			}
+		})
+	}
+}
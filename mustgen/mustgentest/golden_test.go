@@ -0,0 +1,16 @@
+package mustgentest
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/heliorosa/gen_must/mustgen"
+)
+
+// update rewrites the expected outputs of Golden
+var _ = flag.Bool("update", false, "update the expected outputs")
+
+func TestGolden(t *testing.T) {
+	Golden(t, filepath.Join("..", "testdata", "testpkg"), mustgen.WithFormatter("gofmt"), mustgen.WithTypeCheck(true))
+}
@@ -0,0 +1,83 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+)
+
+var ErrDuplicateName = errors.New("duplicate wrapper name")
+
+// declaration is what declares a name of the output: the directive of a function or of a struct.
+type declaration struct {
+	pos        token.Position
+	fn         string
+	constraint string
+}
+
+// declarationPos returns the position of the directive, pos when it isn't known.
+func declarationPos(directive, pos token.Position) token.Position {
+	if directive.IsValid() {
+		return directive
+	}
+	return pos
+}
+
+// checkNames returns the errors of the wrappers and the decorators of plan declaring a name already declared by
+// another one, eg: two directives naming their wrappers alike, and removes them from plan. The names declared
+// under build constraints that can't both be satisfied don't collide, see exclusiveConstraints.
+func checkNames(plan *Plan) ErrorList {
+	names := make(map[string][]declaration)
+	var errs ErrorList
+	// declare records the names, it returns the error of the first one already declared
+	declare := func(d declaration, keys ...string) error {
+		for _, key := range keys {
+			for _, prev := range names[key] {
+				if !exclusiveConstraints(prev.constraint, d.constraint) {
+					return fmt.Errorf("%w %s, also requested by %s at %s", ErrDuplicateName, key, prev.fn, prev.pos)
+				}
+			}
+		}
+		for _, key := range keys {
+			names[key] = append(names[key], d)
+		}
+		return nil
+	}
+	funcs := plan.Funcs[:0]
+	for _, w := range plan.Funcs {
+		var recv string
+		if w.isMethod() {
+			recv = w.recvTypeName()
+		}
+		keys := []string{wrapperKey(recv, w.NewName)}
+		if name := w.allName(); name != "" {
+			keys = append(keys, wrapperKey(recv, name))
+		}
+		pos := declarationPos(w.directivePos, w.Pos)
+		if err := declare(declaration{pos: pos, fn: w.Name, constraint: w.Constraint}, keys...); err != nil {
+			errs = append(errs, &PosError{Pos: pos, Func: w.Name, Err: err})
+			continue
+		}
+		funcs = append(funcs, w)
+	}
+	plan.Funcs = funcs
+	decorators := plan.Decorators[:0]
+	for _, d := range plan.Decorators {
+		pos := declarationPos(d.directivePos, d.Pos)
+		if err := declare(declaration{pos: pos, fn: d.Type, constraint: d.Constraint}, d.NewName); err != nil {
+			errs = append(errs, &PosError{Pos: pos, Func: d.Type, Err: err})
+			continue
+		}
+		decorators = append(decorators, d)
+	}
+	plan.Decorators = decorators
+	return errs
+}
+
+// wrapperKey returns the name of a wrapper as written in the errors: Recv.Name for a method.
+func wrapperKey(recv, name string) string {
+	if recv == "" {
+		return name
+	}
+	return recv + "." + name
+}
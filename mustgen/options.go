@@ -0,0 +1,533 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DefaultTag is the directive marking the functions to be wrapped.
+const DefaultTag = "@gen_must"
+
+// The behaviors when no tagged function is found in a package, see Options.IfEmpty.
+const (
+	IfEmptyWarn = "warn"
+	IfEmptySkip = "skip"
+	IfEmptyFail = "fail"
+)
+
+var ErrNoDirectives = errors.New("no tagged functions found")
+
+// IfEmptyModes returns the behaviors when no tagged function is found.
+func IfEmptyModes() []string { return []string{IfEmptyWarn, IfEmptySkip, IfEmptyFail} }
+
+// DefaultRecvName is the name given to the blank or unnamed receivers of the wrapped methods.
+const DefaultRecvName = "t"
+
+type Options struct {
+	// Tag is the directive marking the functions to be wrapped
+	Tag string
+	// Naming returns the name of the wrapper of a function, when the directive doesn't set one
+	Naming func(name string) string
+	// Package is the package clause of the output, the name of the loaded package when empty
+	Package string
+	// Formatter is the name of the formatter of the output, the output isn't formatted when empty
+	Formatter string
+	// Version, Header and Marker are written in the header, see Generator
+	Version string
+	Header  string
+	Marker  string
+	// GoGenerate are the arguments written in a //go:generate directive, see Generator
+	GoGenerate string
+	// Template is the text/template of each wrapper, see Generator
+	Template string
+	// TypeCheck loads the type information of the package, used to accept concrete error types and
+	// to check the signatures of the hand-written wrappers
+	TypeCheck bool
+	// LineDirectives points the wrappers to the wrapped functions with //line directives, see Generator
+	LineDirectives bool
+	// PanicArgs makes the wrappers panic with an error describing the call, see Generator
+	PanicArgs bool
+	// RedactTypes are the types of the parameters hidden in the panics, see Generator
+	RedactTypes []string
+	// Stack makes the wrappers panic with an error carrying the stack, see Generator
+	Stack bool
+	// MustError makes the wrappers panic with a *MustError, see Generator
+	MustError bool
+	// Metrics calls a hook before panicking, see Generator
+	Metrics bool
+	// Tracing calls a hook with the context of the wrapper before panicking, see Generator
+	Tracing bool
+	// Factory writes a MustFactory type with a method per constructor, see Generator
+	Factory bool
+	// Targets select functions to wrap without a directive, see Target
+	Targets []Target
+	// Funcs are the only functions wrapped when not empty, tagged or not, by name: Name, or Type.Method for a method.
+	// Plan fails with ErrFuncNotFound when one of them isn't in the package
+	Funcs []string
+	// Plugins are the commands generating the wrappers of other variants, by variant, see Generator
+	Plugins map[string]string
+	// Lang is the go version of the output, eg: go1.17, see Generator. When empty it's the go version of the module
+	// of the package, the features it lacks are then disabled with a warning
+	Lang string
+	// RecvName is the name given to the blank or unnamed receivers of the wrapped methods, DefaultRecvName when
+	// empty. It's suffixed with a number when the signature already uses it
+	RecvName string
+	// MethodFuncs generates the wrappers of the methods as functions taking the receiver as their first parameter,
+	// eg: MustFooBar(f *Foo, ...), keeping them out of the method sets. The methods of generic types stay methods
+	MethodFuncs bool
+	// IfEmpty is what happens when no tagged function is found: with IfEmptyWarn, the default when empty, a warning
+	// is logged and the output only has the header, IfEmptySkip logs it too and Run doesn't write the output,
+	// IfEmptyFail makes Plan fail with ErrNoDirectives
+	IfEmpty string
+	// Lenient makes Plan skip the functions with an unsupported signature (eg: a parameter type gen_must can't
+	// write), logging a warning, instead of failing
+	Lenient bool
+	// KeepGoing makes Plan go on after a function that can't be wrapped: it returns the plan of the other ones
+	// and an ErrorList of all the errors
+	KeepGoing bool
+	// ScanFiles restricts the directives to these files of the package, and the source digest to their content.
+	// All the files of the package are scanned when empty
+	ScanFiles []string
+	// ScanIgnored scans the files guarded by //go:build ignore too, they are left out by default
+	ScanIgnored bool
+	// Layout is where the wrappers are written, LayoutPackage when empty. With LayoutInternal they are
+	// written in a package of their own, named Package or after the loaded package, importing it
+	Layout string
+	// LooseDirectives accepts the near misses of the directives: // @gen_must with spaces after the slashes,
+	// the block comments /*@gen_must*/ and the tags of another case, eg: //@Gen_Must. Without it, Vet reports them
+	LooseDirectives bool
+	// Reexport declares again the exported types, constants and variables of the wrapped package in the output,
+	// when it's another package, see Reexport
+	Reexport bool
+	// Window is the number of wrappers generated and formatted at once by Stream, 1 when zero
+	Window int
+	// OnFunction is called for each wrapper found by Plan, it can modify the wrapper or skip it
+	OnFunction func(fnDecl *ast.FuncDecl, w *FuncSpec) (skip bool, err error)
+	// AfterFile is called with the formatted output, it returns the content to be written.
+	// path is the output file, empty when it isn't known
+	AfterFile func(path string, src []byte) ([]byte, error)
+	// Files is the file system used to read the sources and to write the outputs
+	Files Files
+	// Logger receives the skipped functions, warnings and timings
+	Logger *slog.Logger
+}
+
+type Option func(*Options)
+
+func WithTag(tag string) Option { return func(o *Options) { o.Tag = tag } }
+
+func WithNaming(naming func(name string) string) Option {
+	return func(o *Options) { o.Naming = naming }
+}
+
+func WithPackage(name string) Option { return func(o *Options) { o.Package = name } }
+
+func WithFormatter(name string) Option { return func(o *Options) { o.Formatter = name } }
+
+func WithVersion(version string) Option { return func(o *Options) { o.Version = version } }
+
+func WithHeader(header string) Option { return func(o *Options) { o.Header = header } }
+
+func WithMarker(marker string) Option { return func(o *Options) { o.Marker = marker } }
+
+func WithTemplate(tmpl string) Option { return func(o *Options) { o.Template = tmpl } }
+
+func WithTypeCheck(enabled bool) Option { return func(o *Options) { o.TypeCheck = enabled } }
+
+func WithPanicArgs(enabled bool) Option { return func(o *Options) { o.PanicArgs = enabled } }
+
+func WithRedactTypes(types ...string) Option { return func(o *Options) { o.RedactTypes = types } }
+
+func WithStack(enabled bool) Option { return func(o *Options) { o.Stack = enabled } }
+
+func WithMustError(enabled bool) Option { return func(o *Options) { o.MustError = enabled } }
+
+func WithMetrics(enabled bool) Option { return func(o *Options) { o.Metrics = enabled } }
+
+func WithTracing(enabled bool) Option { return func(o *Options) { o.Tracing = enabled } }
+
+func WithFactory(enabled bool) Option { return func(o *Options) { o.Factory = enabled } }
+
+func WithFuncs(names ...string) Option { return func(o *Options) { o.Funcs = names } }
+
+func WithTargets(targets ...Target) Option { return func(o *Options) { o.Targets = targets } }
+
+// WithPlugin generates the wrappers of variant with the plugin command, see Generator.Plugins.
+func WithPlugin(variant, command string) Option {
+	return func(o *Options) {
+		if o.Plugins == nil {
+			o.Plugins = make(map[string]string)
+		}
+		o.Plugins[variant] = command
+	}
+}
+
+func WithLang(version string) Option { return func(o *Options) { o.Lang = version } }
+
+func WithWindow(n int) Option { return func(o *Options) { o.Window = n } }
+
+func WithRecvName(name string) Option { return func(o *Options) { o.RecvName = name } }
+
+func WithMethodFuncs(enabled bool) Option { return func(o *Options) { o.MethodFuncs = enabled } }
+
+func WithIfEmpty(mode string) Option { return func(o *Options) { o.IfEmpty = mode } }
+
+func WithLenient(enabled bool) Option { return func(o *Options) { o.Lenient = enabled } }
+
+func WithKeepGoing(enabled bool) Option { return func(o *Options) { o.KeepGoing = enabled } }
+
+func WithScanFiles(files ...string) Option { return func(o *Options) { o.ScanFiles = files } }
+
+func WithScanIgnored(enabled bool) Option { return func(o *Options) { o.ScanIgnored = enabled } }
+
+func WithLayout(layout string) Option { return func(o *Options) { o.Layout = layout } }
+
+func WithLooseDirectives(enabled bool) Option {
+	return func(o *Options) { o.LooseDirectives = enabled }
+}
+
+func WithReexport(enabled bool) Option { return func(o *Options) { o.Reexport = enabled } }
+
+func WithGoGenerate(args string) Option { return func(o *Options) { o.GoGenerate = args } }
+
+func WithLineDirectives(enabled bool) Option { return func(o *Options) { o.LineDirectives = enabled } }
+
+// WithFS reads files from fsys instead of the OS file system, see Files.
+func WithFS(fsys fs.FS) Option { return func(o *Options) { o.Files.FS = fsys } }
+
+// WithWriteFile writes the files with fn instead of os.WriteFile.
+func WithWriteFile(fn func(name string, data []byte, perm fs.FileMode) error) Option {
+	return func(o *Options) { o.Files.WriteFile = fn }
+}
+
+func WithOnFunction(fn func(fnDecl *ast.FuncDecl, w *FuncSpec) (skip bool, err error)) Option {
+	return func(o *Options) { o.OnFunction = fn }
+}
+
+func WithAfterFile(fn func(path string, src []byte) ([]byte, error)) Option {
+	return func(o *Options) { o.AfterFile = fn }
+}
+
+// WithLogger reports skipped functions, warnings and timings to logger. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option { return func(o *Options) { o.Logger = logger } }
+
+// Gen generates the wrappers of packages, according to its options.
+type Gen struct{ opts Options }
+
+// New returns a Gen with the default options (DefaultTag, Must* names, goimports formatting),
+// modified by opts.
+func New(opts ...Option) *Gen {
+	g := &Gen{opts: Options{
+		Tag:       DefaultTag,
+		Naming:    mustName,
+		Formatter: "goimports",
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	return g
+}
+
+func (g *Gen) Options() Options { return g.opts }
+
+// LoadMode returns the mode needed to plan the wrappers: the syntax of the package, and its type
+// information only when TypeCheck is set.
+func (g *Gen) LoadMode() packages.LoadMode {
+	if g.opts.TypeCheck {
+		return typesMode
+	}
+	return syntaxMode
+}
+
+// Load loads the package matching patterns with LoadMode.
+func (g *Gen) Load(ctx context.Context, patterns []string, buildFlags ...string) (*packages.Package, error) {
+	return loadPackage(ctx, g.LoadMode(), patterns, buildFlags)
+}
+
+// packageScope returns the scope of pkg, or one holding the names declared at package level when it
+// was loaded without its types.
+func packageScope(pkg *packages.Package) *types.Scope {
+	if pkg.Types != nil {
+		return pkg.Types.Scope()
+	}
+	scope := types.NewScope(nil, token.NoPos, token.NoPos, pkg.Name)
+	declare := func(name *ast.Ident) {
+		if name.Name != "_" {
+			scope.Insert(types.NewTypeName(name.Pos(), nil, name.Name, nil))
+		}
+	}
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					declare(decl.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						declare(spec.Name)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							declare(name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return scope
+}
+
+// Plan scans pkg for tagged functions. It stops between files when ctx is done.
+func (g *Gen) Plan(ctx context.Context, pkg *packages.Package) (*Plan, error) {
+	start := time.Now()
+	digest, err := g.sourceDigest(g.digestFiles(pkg.GoFiles))
+	if err != nil {
+		return nil, err
+	}
+	scanned, err := g.scanned(pkg)
+	if err != nil {
+		return nil, err
+	}
+	g.warnNearMisses(pkg.Fset, scanned.Syntax)
+	plan := &Plan{Package: pkg.Name, Digest: digest, Funcs: []*FuncSpec{}}
+	var qual string
+	if g.opts.Layout == LayoutInternal || g.opts.Package != "" && g.opts.Package != pkg.Name {
+		if g.opts.Package != "" {
+			plan.Package = g.opts.Package
+		}
+		imp := Import{Path: pkg.PkgPath}
+		if path.Base(pkg.PkgPath) != pkg.Name {
+			imp.Name = pkg.Name
+		}
+		plan.Imports = append(plan.Imports, imp)
+		qual = pkg.Name
+	}
+	constraints := make(map[string]string, len(pkg.Syntax))
+	for _, file := range pkg.Syntax {
+		if tf := pkg.Fset.File(file.Pos()); tf != nil {
+			constraints[tf.Name()] = fileConstraint(file, tf.Name())
+		}
+	}
+	// the scope tells the names of the package from the ones of the dot-imports, and from outside of it
+	scope := packageScope(pkg)
+	lang, autoLang := g.lang(pkg)
+	var hand *handWritten
+	if qual == "" {
+		hand = newHandWritten(pkg)
+	}
+	var errs ErrorList
+	// skipped counts the tagged functions left out with a warning
+	skipped := 0
+	report := func(err error) error {
+		if !g.opts.KeepGoing {
+			return err
+		}
+		errs = append(errs, err)
+		return nil
+	}
+	targets, found := g.funcTargets()
+	err = walkPackage(ctx, scanned, g.directiveTag(), g.opts.Naming, targets, func(d *directive, fnDecl *ast.FuncDecl) error {
+		if found != nil {
+			// only the functions of Funcs are wrapped, tagged or not
+			if _, ok := found[funcName(fnDecl)]; !ok {
+				return nil
+			}
+			found[funcName(fnDecl)] = true
+		}
+		p := &planner{fset: pkg.Fset, fn: fnDecl, qual: qual, recvName: g.opts.RecvName, file: fileOf(scanned.Syntax, fnDecl), variants: pluginVariants(g.opts.Plugins)}
+		p.scope = scope
+		p.info = pkg.TypesInfo
+		w, err := p.planWrapper(d)
+		if err == nil {
+			var skip bool
+			if skip, err = g.fitLang(w, lang, autoLang); skip {
+				skipped++
+				return nil
+			}
+			if err != nil {
+				err = &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+			}
+		}
+		if g.opts.Lenient && (errors.Is(err, ErrNoErrorReturn) || errors.Is(err, ErrNoReturnValues)) {
+			// most likely a stray directive, the position tells where
+			pos := p.position(fnDecl)
+			var posErr *PosError
+			if errors.As(err, &posErr) {
+				pos = posErr.Pos
+			}
+			g.opts.Logger.Warn("directive on function without error result", "func", fnDecl.Name.Name, "pos", pos.String())
+			skipped++
+			return nil
+		}
+		// the functions needing a newer go version than Lang aren't skipped, the sources don't fit the output
+		if errors.Is(err, ErrCgoType) || g.opts.Lenient && isUnsupported(err) && !errors.Is(err, ErrLanguageVersion) {
+			g.opts.Logger.Warn("function not wrapped", "func", fnDecl.Name.Name, "err", err)
+			skipped++
+			return nil
+		}
+		if err != nil {
+			return report(err)
+		}
+		w.Constraint = constraints[w.Pos.Filename]
+		switch {
+		case !g.opts.MethodFuncs || !w.isMethod() || w.recvOption() != "":
+		case strings.Contains(w.Recv.Type, "["):
+			// the type parameters of the receiver would need their constraints
+			g.opts.Logger.Warn("wrapper of a method of a generic type kept as a method", "func", w.Name, "recv", w.Recv.Type)
+		default:
+			w.RecvParam = true
+		}
+		// the default name of the wrapper gets the name of the receiver type, eg: MustFooBar for Foo.Bar
+		if w.RecvParam && d.name == g.opts.Naming(fnDecl.Name.Name) {
+			w.NewName = g.opts.Naming(methodFuncName(w.recvTypeName(), w.Name))
+		}
+		if hand != nil {
+			found, err := hand.check(fnDecl, w)
+			if err != nil {
+				return report(err)
+			}
+			if found {
+				g.opts.Logger.Info("hand-written wrapper found", "func", w.Name, "wrapper", w.NewName)
+				return nil
+			}
+		}
+		if g.opts.OnFunction != nil {
+			skip, err := g.opts.OnFunction(fnDecl, w)
+			if err != nil {
+				return err
+			}
+			if skip {
+				g.opts.Logger.Info("function skipped", "func", w.Name, "pos", w.Pos.String())
+				return nil
+			}
+		}
+		if err = plan.addImports(p.imports); err != nil {
+			return report(p.errAt(fnDecl, err))
+		}
+		g.opts.Logger.Debug("function wrapped", "func", w.Name, "wrapper", w.NewName, "pos", w.Pos.String())
+		plan.Funcs = append(plan.Funcs, w)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = notFound(found); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		if err = g.planDecorators(pkg, scanned, plan, qual, constraints, report); err != nil {
+			return nil, err
+		}
+	}
+	for _, err := range checkNames(plan) {
+		if err = report(err); err != nil {
+			return nil, err
+		}
+	}
+	if g.opts.Reexport && qual != "" {
+		g.planReexports(plan, syntaxReexports(pkg, qual))
+	}
+	if plan.empty() && len(errs) == 0 && skipped == 0 {
+		if g.opts.IfEmpty == IfEmptyFail {
+			return nil, fmt.Errorf("%w: package %s, tag %s", ErrNoDirectives, pkg.PkgPath, g.opts.Tag)
+		}
+		g.opts.Logger.Warn("no tagged functions found", "package", pkg.PkgPath, "tag", g.opts.Tag)
+	}
+	sort.Slice(plan.Imports, func(i, j int) bool { return plan.Imports[i].Path < plan.Imports[j].Path })
+	plan.Sort()
+	g.opts.Logger.Debug("package planned", "package", pkg.PkgPath, "funcs", len(plan.Funcs), "duration", time.Since(start))
+	if len(errs) > 0 {
+		return plan, errs
+	}
+	return plan, nil
+}
+
+// Generator returns a Generator writing to w, with the header options of g.
+func (g *Gen) Generator(w io.Writer) *Generator {
+	return &Generator{
+		Writer:         w,
+		Version:        g.opts.Version,
+		Header:         g.opts.Header,
+		Marker:         g.opts.Marker,
+		GoGenerate:     g.opts.GoGenerate,
+		Template:       g.opts.Template,
+		LineDirectives: g.opts.LineDirectives,
+		PanicArgs:      g.opts.PanicArgs,
+		RedactTypes:    g.opts.RedactTypes,
+		Stack:          g.opts.Stack,
+		MustError:      g.opts.MustError,
+		Metrics:        g.opts.Metrics,
+		Tracing:        g.opts.Tracing,
+		Factory:        g.opts.Factory,
+		Lang:           g.opts.Lang,
+		Plugins:        g.opts.Plugins,
+	}
+}
+
+// Format formats src with the formatter of g and runs the AfterFile hook on the result.
+// filename is the path of the output file.
+func (g *Gen) Format(filename string, src io.Reader, dst io.Writer) error {
+	if g.opts.AfterFile == nil {
+		return g.format(filename, src, dst)
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err := g.format(filename, src, buffer); err != nil {
+		return err
+	}
+	b, err := g.opts.AfterFile(filename, buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(b)
+	return err
+}
+
+func (g *Gen) format(filename string, src io.Reader, dst io.Writer) error {
+	if g.opts.Formatter == "" {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	start := time.Now()
+	if err := Format(g.opts.Formatter, filename, src, dst); err != nil {
+		return err
+	}
+	g.opts.Logger.Debug("output formatted", "file", filename, "formatter", g.opts.Formatter, "duration", time.Since(start))
+	return nil
+}
+
+// WriteFile writes an output file.
+func (g *Gen) WriteFile(path string, data []byte) error { return g.opts.Files.Write(path, data, 0o644) }
+
+// ReadFile reads a file, eg: the current content of an output file.
+func (g *Gen) ReadFile(path string) ([]byte, error) { return g.opts.Files.ReadFile(path) }
+
+// Generate writes the formatted wrappers of pkg to w.
+func (g *Gen) Generate(ctx context.Context, w io.Writer, pkg *packages.Package) error {
+	plan, err := g.Plan(ctx, pkg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pkg.PkgPath, err)
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err = g.Generator(buffer).Emit(plan); err != nil {
+		return err
+	}
+	return g.Format("", buffer, w)
+}
@@ -0,0 +1,622 @@
+package mustgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Plan is the serializable list of wrappers to be generated for a package.
+type Plan struct {
+	Package string      `json:"package"`
+	Digest  string      `json:"digest,omitempty"`
+	Imports []Import    `json:"imports,omitempty"`
+	Funcs   []*FuncSpec `json:"funcs"`
+	// Decorators are the decorators of the tagged structs
+	Decorators []*DecoratorSpec `json:"decorators,omitempty"`
+	// Parts name the other files of the output, with the wrappers of a receiver type (see LayoutReceiver) or of a
+	// build constraint (see ConstraintFiles): the file of the plan declares what their wrappers share (see
+	// Options.Stack). Part names the file of such a plan
+	Parts []string `json:"parts,omitempty"`
+	Part  string   `json:"part,omitempty"`
+	// Reexports are the names of the wrapped package declared again in the output, see Options.Reexport
+	Reexports []Reexport `json:"reexports,omitempty"`
+}
+
+type Import struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+}
+
+// FuncSpec describes a tagged function and the wrapper to be generated for it.
+type FuncSpec struct {
+	// Pkg is the name of the package of the function, when the wrapper is generated outside of it
+	Pkg string `json:"pkg,omitempty"`
+	// Name is the name of the function, NewName the name of the wrapper
+	Name       string  `json:"name"`
+	NewName    string  `json:"newName"`
+	Recv       *Field  `json:"recv,omitempty"`
+	TypeParams []Field `json:"typeParams,omitempty"`
+	Params     []Field `json:"params,omitempty"`
+	// Results are the types of the results, the last one is the error. When Iter is set the function returns an
+	// iter.Seq2 of Results, and the wrapper an iter.Seq of Results[0]
+	Results []string `json:"results"`
+	Iter    bool     `json:"iter,omitempty"`
+	// RecvParam makes the wrapper of a method a function taking the receiver as its first parameter
+	RecvParam bool `json:"recvParam,omitempty"`
+	// ResultNames are the names of the results in the signature of the function, empty when they aren't named.
+	// The wrapper names its variables after them
+	ResultNames []string `json:"resultNames,omitempty"`
+	// Options are the key=value options of the directive
+	Options map[string]string `json:"options,omitempty"`
+	// Pos is the position of the function
+	Pos token.Position `json:"pos"`
+	// Constraint is the build constraint of the file of the function, the wrapper must carry it
+	Constraint string `json:"constraint,omitempty"`
+	// directivePos is the position of the directive, unknown for a target or a plan read from JSON
+	directivePos token.Position
+}
+
+// variant returns the variant of the wrapper, set by the variant option of the directive.
+func (w *FuncSpec) variant() string {
+	if v := w.Options["variant"]; v != "" {
+		return v
+	}
+	return VariantMust
+}
+
+// recvOption returns the type the wrapper is a method of, set by the recv option of the directive on a function.
+func (w *FuncSpec) recvOption() string { return w.Options["recv"] }
+
+// methodFuncName returns the name of the function wrapping the method of recv: the method name prefixed by the
+// type name, exported like the method, eg: FooBar for Foo.Bar, fooBar for Foo.bar.
+func methodFuncName(recv, method string) string {
+	r, size := utf8.DecodeRuneInString(recv)
+	m, msize := utf8.DecodeRuneInString(method)
+	if unicode.IsUpper(m) {
+		r = unicode.ToUpper(r)
+	} else {
+		r = unicode.ToLower(r)
+	}
+	return string(r) + recv[size:] + string(unicode.ToUpper(m)) + method[msize:]
+}
+
+// isMethod reports whether the wrapper is a method of Recv.
+func (w *FuncSpec) isMethod() bool { return w.Recv != nil && !w.RecvParam }
+
+// callRecv reports whether the wrapped function is called through Recv, its receiver.
+func (w *FuncSpec) callRecv() bool { return w.Recv != nil && w.recvOption() == "" }
+
+// wrapperParams returns the parameters of the wrapper: Params, preceded by Recv with RecvParam.
+func (w *FuncSpec) wrapperParams() []Field {
+	if w.RecvParam {
+		return append([]Field{*w.Recv}, w.Params...)
+	}
+	return w.Params
+}
+
+// redacted returns the parameters named by the redact option of the directive.
+func (w *FuncSpec) redacted() []string {
+	if w.Options["redact"] == "" {
+		return nil
+	}
+	return strings.Split(w.Options["redact"], ",")
+}
+
+// recvTypeName returns the name of the receiver type, without pointer, package and type parameters.
+func (w *FuncSpec) recvTypeName() string {
+	if w.Recv == nil {
+		return ""
+	}
+	name := strings.TrimPrefix(w.Recv.Type, "*")
+	if i := strings.IndexByte(name, '['); i != -1 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// BuildPlan scans pkg for tagged functions. outPackage is the package clause of the output,
+// when it isn't pkg.Name the wrapped package is imported and its identifiers qualified.
+func BuildPlan(pkg *packages.Package, outPackage string) (*Plan, error) {
+	return New(WithPackage(outPackage)).Plan(context.Background(), pkg)
+}
+
+// Sort orders the wrappers by receiver type, free functions first, then by name.
+func (p *Plan) Sort() {
+	sort.SliceStable(p.Funcs, func(i, j int) bool {
+		ri, rj := p.Funcs[i].recvTypeName(), p.Funcs[j].recvTypeName()
+		if ri != rj {
+			return ri < rj
+		}
+		return p.Funcs[i].NewName < p.Funcs[j].NewName
+	})
+}
+
+// empty reports whether p has no wrapper to generate.
+func (p *Plan) empty() bool { return len(p.Funcs) == 0 && len(p.Decorators) == 0 }
+
+// declaresSupport reports whether the declarations used by the wrappers, eg: the error type of Options.Stack, are
+// written to the file of p: it has wrappers, or the other files of the output do.
+func (p *Plan) declaresSupport() bool { return p.Part == "" && (!p.empty() || len(p.Parts) > 0) }
+
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var plan Plan
+	if err := json.NewDecoder(r).Decode(&plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (p *Plan) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(p)
+}
+
+type planner struct {
+	fset *token.FileSet
+	fn   *ast.FuncDecl
+	// qual is the name of the wrapped package when generating outside of it, scope is its scope
+	qual  string
+	scope *types.Scope
+	// info is the type information of the package, when available
+	info *types.Info
+	// recvName is the name of a blank or unnamed receiver, DefaultRecvName when empty
+	recvName string
+	// file is the file of fn, imports the imports of the qualified types of its signature
+	file    *ast.File
+	imports []Import
+	// variants are the variants of the plugins, accepted like the built-in ones
+	variants []string
+}
+
+func (p *planner) position(node ast.Node) token.Position {
+	if p.fset == nil {
+		return token.Position{}
+	}
+	return p.fset.Position(node.Pos())
+}
+
+func (p *planner) errAt(node ast.Node, err error) error {
+	return &PosError{Pos: p.position(node), Func: p.fn.Name.Name, Err: err}
+}
+
+// isErrorType reports whether t can be returned as the error of a wrapped function: it implements error
+// and can be compared to nil.
+func isErrorType(t types.Type) bool {
+	if t == nil || t == types.Typ[types.Invalid] {
+		return false
+	}
+	errorType := types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+	if !types.Implements(t, errorType) {
+		return false
+	}
+	switch t.Underlying().(type) {
+	case *types.Interface, *types.Pointer, *types.Map, *types.Slice, *types.Chan, *types.Signature:
+		return true
+	default:
+		return false
+	}
+}
+
+// WrapperResults returns the results of the wrapper of a function with signature sig: its results but the error,
+// or an iter.Seq[T] when it returns an iter.Seq2[T, error].
+func WrapperResults(sig *types.Signature) *types.Tuple {
+	res := sig.Results()
+	if seq := iterSeq(res); seq != nil {
+		return types.NewTuple(types.NewVar(token.NoPos, nil, "", seq))
+	}
+	vars := make([]*types.Var, 0, res.Len())
+	for i := 0; i < res.Len()-1; i++ {
+		vars = append(vars, res.At(i))
+	}
+	return types.NewTuple(vars...)
+}
+
+// TupleString renders the types of tuple, without the names, relative to pkg, eg: (string, ...int). variadic
+// writes the last one as a variadic parameter.
+func TupleString(pkg *types.Package, tuple *types.Tuple, variadic bool) string {
+	qual := types.RelativeTo(pkg)
+	typs := make([]string, 0, tuple.Len())
+	for i := 0; i < tuple.Len(); i++ {
+		t := tuple.At(i).Type()
+		if variadic && i == tuple.Len()-1 {
+			typs = append(typs, "..."+types.TypeString(t.(*types.Slice).Elem(), qual))
+			continue
+		}
+		typs = append(typs, types.TypeString(t, qual))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(typs, ", "))
+}
+
+// iterSeq returns iter.Seq[T] when res is a single iter.Seq2[T, error], nil otherwise.
+func iterSeq(res *types.Tuple) types.Type {
+	if res.Len() != 1 {
+		return nil
+	}
+	named, ok := res.At(0).Type().(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "iter" || named.Obj().Name() != "Seq2" {
+		return nil
+	}
+	args := named.TypeArgs()
+	if args.Len() != 2 || !types.Identical(args.At(1), types.Universe.Lookup("error").Type()) {
+		return nil
+	}
+	seq, ok := named.Obj().Pkg().Scope().Lookup("Seq").(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	t, err := types.Instantiate(nil, seq.Type(), []types.Type{args.At(0)}, false)
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+func unsupportedType(typ ast.Expr) error {
+	return &UnsupportedTypeError{Construct: types.ExprString(typ)}
+}
+
+// lookupImport returns the import of the file of the function named by ident, its name as written in the file.
+func (p *planner) lookupImport(ident *ast.Ident) (Import, bool) {
+	if ident == nil || p.file == nil {
+		return Import{}, false
+	}
+	for _, spec := range p.file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		var name string
+		switch {
+		case spec.Name != nil:
+			name = spec.Name.Name
+		case p.info != nil && p.info.Implicits[spec] != nil:
+			name = p.info.Implicits[spec].Name()
+		default:
+			name = guessPackageName(importPath)
+		}
+		if name != ident.Name {
+			continue
+		}
+		imp := Import{Path: importPath}
+		if name != path.Base(importPath) {
+			imp.Name = name
+		}
+		return imp, true
+	}
+	return Import{}, false
+}
+
+// guessPackageName returns the likely name of the package of importPath, without its type information: the last
+// element of the path, without its major version and go prefix or suffix, eg: yaml for gopkg.in/yaml.v3.
+func guessPackageName(importPath string) string {
+	elems := strings.Split(importPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.LastIndex(name, ".v"); i > 0 && isMajorVersion(name[i+1:]) {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "go-"), "-go")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+func isMajorVersion(s string) bool {
+	_, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	return strings.HasPrefix(s, "v") && err == nil
+}
+
+// importName returns the name imp is referred to by.
+func importName(imp Import) string {
+	if imp.Name != "" {
+		return imp.Name
+	}
+	return path.Base(imp.Path)
+}
+
+// addImports adds the imports of a wrapper to the ones of p, a name can't refer to different packages.
+func (p *Plan) addImports(imports []Import) error {
+	for _, imp := range imports {
+		if slices.Contains(p.Imports, imp) {
+			continue
+		}
+		for _, other := range p.Imports {
+			if importName(other) == importName(imp) {
+				return fmt.Errorf("%w: %s is %s and %s", ErrImportConflict, importName(imp), other.Path, imp.Path)
+			}
+		}
+		p.Imports = append(p.Imports, imp)
+	}
+	return nil
+}
+
+// fileOf returns the file of files containing node.
+func fileOf(files []*ast.File, node ast.Node) *ast.File {
+	for _, file := range files {
+		if file.FileStart <= node.Pos() && node.Pos() < file.FileEnd {
+			return file
+		}
+	}
+	return nil
+}
+
+// dotImport returns the import declaring ident when the file of the function dot-imports it, and the name
+// qualifying ident in the output. Only the type information tells which package declares ident, without it an
+// identifier that isn't declared by the package nor predeclared is an error.
+func (p *planner) dotImport(ident *ast.Ident) (imp Import, name string, found bool, err error) {
+	var dotPaths []string
+	if p.file != nil {
+		for _, spec := range p.file.Imports {
+			if spec.Name != nil && spec.Name.Name == "." {
+				importPath, _ := strconv.Unquote(spec.Path.Value)
+				dotPaths = append(dotPaths, importPath)
+			}
+		}
+	}
+	if len(dotPaths) == 0 {
+		return Import{}, "", false, nil
+	}
+	if p.info != nil {
+		obj := p.info.Uses[ident]
+		if obj == nil || obj.Pkg() == nil || !slices.Contains(dotPaths, obj.Pkg().Path()) {
+			return Import{}, "", false, nil
+		}
+		imp = Import{Path: obj.Pkg().Path()}
+		if obj.Pkg().Name() != path.Base(imp.Path) {
+			imp.Name = obj.Pkg().Name()
+		}
+		return imp, obj.Pkg().Name(), true, nil
+	}
+	if types.Universe.Lookup(ident.Name) != nil || slices.Contains(p.typeParamNames(), ident.Name) ||
+		p.scope != nil && p.scope.Lookup(ident.Name) != nil {
+		return Import{}, "", false, nil
+	}
+	return Import{}, "", false, p.errAt(ident, fmt.Errorf("%w: %s, use -typecheck", ErrDotImport, ident.Name))
+}
+
+// typeParamNames returns the names of the type parameters of the function and of its receiver type.
+func (p *planner) typeParamNames() []string {
+	var names []string
+	if p.fn.Type.TypeParams != nil {
+		for _, field := range p.fn.Type.TypeParams.List {
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+		}
+	}
+	if p.fn.Recv != nil && len(p.fn.Recv.List) > 0 {
+		typ := p.fn.Recv.List[0].Type
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
+		}
+		switch typ := typ.(type) {
+		case *ast.IndexExpr:
+			if ident, ok := typ.Index.(*ast.Ident); ok {
+				names = append(names, ident.Name)
+			}
+		case *ast.IndexListExpr:
+			for _, index := range typ.Indices {
+				if ident, ok := index.(*ast.Ident); ok {
+					names = append(names, ident.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// typeString renders typ with types.TypeString, the types of the other packages qualified by the names of their
+// imports in the file of the function. ok is false when typ has no type information.
+func (p *planner) typeString(typ ast.Expr) (s string, ok bool, err error) {
+	if ell, isEllipsis := typ.(*ast.Ellipsis); isEllipsis {
+		elt, ok, err := p.typeString(ell.Elt)
+		return "..." + elt, ok, err
+	}
+	t := p.info.TypeOf(typ)
+	if t == nil || t == types.Typ[types.Invalid] {
+		return "", false, nil
+	}
+	// cgo types are renamed in the package, they only exist in the files importing C. Outside of the package only
+	// its exported types can be used
+	ast.Inspect(typ, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if x, isIdent := n.X.(*ast.Ident); isIdent && x.Name == "C" && err == nil {
+				err = p.errAt(n, ErrCgoType)
+			}
+		case *ast.Ident:
+			obj, isType := p.info.Uses[n].(*types.TypeName)
+			if p.qual != "" && isType && obj.Pkg() == p.ownPkg() && !obj.Exported() && err == nil {
+				err = p.errAt(n, ErrNotExported)
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return "", true, err
+	}
+	return types.TypeString(t, p.qualifier), true, nil
+}
+
+// ownPkg returns the package of the function, nil if unknown.
+func (p *planner) ownPkg() *types.Package {
+	if obj := p.info.Defs[p.fn.Name]; obj != nil {
+		return obj.Pkg()
+	}
+	return nil
+}
+
+// qualifier returns the name of pkg in the output package, recording its import: qual for the package of the
+// function, the name of its import in the file of the function for the other ones.
+func (p *planner) qualifier(pkg *types.Package) string {
+	if pkg == p.ownPkg() {
+		return p.qual
+	}
+	name := pkg.Name()
+	if p.file != nil {
+		for _, spec := range p.file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if importPath == pkg.Path() && spec.Name != nil && spec.Name.Name != "." && spec.Name.Name != "_" {
+				name = spec.Name.Name
+				break
+			}
+		}
+	}
+	imp := Import{Path: pkg.Path()}
+	if name != path.Base(pkg.Path()) {
+		imp.Name = name
+	}
+	if !slices.Contains(p.imports, imp) {
+		p.imports = append(p.imports, imp)
+	}
+	return name
+}
+
+// qualify returns the name of ident as seen from the output package.
+func (p *planner) qualify(ident *ast.Ident) (string, error) {
+	imp, name, found, err := p.dotImport(ident)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		if !slices.Contains(p.imports, imp) {
+			p.imports = append(p.imports, imp)
+		}
+		return name + "." + ident.Name, nil
+	}
+	if p.qual == "" || p.scope == nil || p.scope.Lookup(ident.Name) == nil {
+		return ident.Name, nil
+	}
+	if !ident.IsExported() {
+		return "", p.errAt(ident, ErrNotExported)
+	}
+	return p.qual + "." + ident.Name, nil
+}
+
+func (p *planner) planWrapper(d *directive) (*FuncSpec, error) {
+	fnDecl := p.fn
+	if p.qual != "" && !fnDecl.Name.IsExported() {
+		return nil, p.errAt(fnDecl.Name, ErrNotExported)
+	}
+	typeParams, err := p.generateTypeParams(fnDecl.Type.TypeParams)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := p.generateReceiver(fnDecl.Recv)
+	if err != nil {
+		return nil, err
+	}
+	// outside of its package, a method is wrapped by a function taking the receiver, qualified like the parameters.
+	// The type parameters of a generic receiver would need their constraints
+	if p.qual != "" && recv != nil && strings.Contains(recv.Type, "[") {
+		return nil, p.errAt(fnDecl.Recv, ErrForeignReceiver)
+	}
+	params, err := p.generateParams(fnDecl.Type.Params)
+	if err != nil {
+		return nil, err
+	}
+	results, names, iter, err := p.generateReturns(fnDecl.Type.Results)
+	if err != nil {
+		return nil, err
+	}
+	w := &FuncSpec{
+		Pkg:         p.qual,
+		Name:        fnDecl.Name.Name,
+		NewName:     d.name,
+		Recv:        recv,
+		TypeParams:  typeParams,
+		Params:      params,
+		Results:     results,
+		ResultNames: names,
+		Iter:        iter,
+		Options:     d.options,
+		Pos:         p.position(fnDecl),
+		RecvParam:   p.qual != "" && recv != nil,
+	}
+	if d.comment != nil {
+		w.directivePos = p.position(d.comment)
+	}
+	if typ := w.recvOption(); typ != "" {
+		// the methods can't be declared on the types of another package
+		if recv != nil || len(typeParams) > 0 || !isRecvType(typ) || p.qual != "" {
+			return nil, p.errAt(fnDecl, fmt.Errorf("recv=%s: %w", typ, ErrRecvOption))
+		}
+		w.Recv = &Field{Name: p.placeholderRecv(), Type: typ}
+	}
+	if err := w.check(p.variants...); err != nil {
+		return nil, p.errAt(fnDecl, err)
+	}
+	return w, nil
+}
+
+// check returns why the wrapper w can't be generated with its options, nil if it can. The specs of a plan read from
+// JSON are checked again before being generated, they may not come from Plan. variants are the variants of the
+// plugins.
+func (w *FuncSpec) check(variants ...string) error {
+	if len(w.Results) == 0 {
+		return ErrNoReturnValues
+	}
+	if !token.IsIdentifier(w.NewName) || w.NewName == "_" {
+		return fmt.Errorf("%w: %s", ErrInvalidName, w.NewName)
+	}
+	if name := w.allName(); name != "" && !token.IsIdentifier(name) {
+		return fmt.Errorf("all=%s: %w", name, ErrInvalidName)
+	}
+	switch w.variant() {
+	case VariantMust:
+	case VariantOnce:
+		if len(w.Params) > 0 || len(w.TypeParams) > 0 || w.Recv != nil || w.Iter || len(w.Results) > 2 {
+			return ErrOnceVariant
+		}
+	default:
+		if !slices.Contains(variants, w.variant()) {
+			return fmt.Errorf("%w: %s", ErrUnknownVariant, w.variant())
+		}
+	}
+	if w.allName() != "" && (len(w.Params) != 1 || strings.HasPrefix(w.Params[0].Type, "...") || w.Iter || len(w.Results) != 2) {
+		return ErrAllOption
+	}
+	for _, name := range w.redacted() {
+		if !slices.ContainsFunc(w.Params, func(f Field) bool { return f.Name == name }) {
+			return fmt.Errorf("redact=%s: %w", name, ErrUnknownParam)
+		}
+	}
+	if w.RecvParam && w.Recv == nil {
+		return fmt.Errorf("%w: recvParam without recv", ErrRecvOption)
+	}
+	// a mistyped option would be ignored, eg: redcat= leaving the parameter in the panic message
+	keys := make([]string, 0, len(w.Options))
+	for key := range w.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !slices.Contains(DirectiveOptions, key) {
+			return fmt.Errorf("%w: %s", ErrUnknownOption, key)
+		}
+	}
+	return nil
+}
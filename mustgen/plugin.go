@@ -0,0 +1,107 @@
+package mustgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// PluginVersion is the version of the plugin protocol, sent in each PluginRequest.
+const PluginVersion = 1
+
+var ErrPlugin = errors.New("plugin failed")
+
+// PluginRequest is written as JSON to the standard input of a plugin, for each wrapper of its variant.
+type PluginRequest struct {
+	Version int `json:"version"`
+	// Package is the package clause of the output
+	Package string    `json:"package"`
+	Func    *FuncSpec `json:"func"`
+}
+
+// PluginResponse is read as JSON from the standard output of a plugin: the go code of the wrapper, declarations
+// only, and the imports it needs. A non-empty Error fails the generation.
+type PluginResponse struct {
+	Code    string   `json:"code"`
+	Imports []Import `json:"imports,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// pluginVariants returns the variants of plugins, sorted.
+func pluginVariants(plugins map[string]string) []string {
+	variants := make([]string, 0, len(plugins))
+	for variant := range plugins {
+		variants = append(variants, variant)
+	}
+	slices.Sort(variants)
+	return variants
+}
+
+// runPlugin runs command, a program and its space-separated arguments, with req and returns its response.
+func runPlugin(command string, req *PluginRequest) (*PluginResponse, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: empty command", ErrPlugin)
+	}
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v: %s", ErrPlugin, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	resp := &PluginResponse{}
+	if err = json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid response: %v", ErrPlugin, args[0], err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%w: %s: %s", ErrPlugin, args[0], resp.Error)
+	}
+	// the code is checked here, the formatter would report the error without naming the plugin
+	if _, err = parser.ParseFile(token.NewFileSet(), "", "package p\n"+resp.Code, 0); err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid code: %v", ErrPlugin, args[0], err)
+	}
+	return resp, nil
+}
+
+// runPlugins runs the plugins of the wrappers of plan, before the imports are written. The responses are kept
+// until the wrappers are generated.
+func (g *Generator) runPlugins(plan *Plan) error {
+	g.plugged = make(map[*FuncSpec]*PluginResponse)
+	for _, w := range plan.Funcs {
+		command, ok := g.Plugins[w.variant()]
+		if !ok {
+			continue
+		}
+		resp, err := runPlugin(command, &PluginRequest{Version: PluginVersion, Package: plan.Package, Func: w})
+		if err != nil {
+			return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+		}
+		g.plugged[w] = resp
+	}
+	return nil
+}
+
+// generatePlugin writes the code of the wrapper w returned by the plugin command.
+func (g *Generator) generatePlugin(w *FuncSpec, command string) error {
+	resp := g.plugged[w]
+	if resp == nil {
+		var err error
+		if resp, err = runPlugin(command, &PluginRequest{Version: PluginVersion, Func: w}); err != nil {
+			return &PosError{Pos: w.Pos, Func: w.Name, Err: err}
+		}
+	}
+	_, err := fmt.Fprintf(g, "%s\n\n", strings.TrimSpace(resp.Code))
+	return err
+}
@@ -0,0 +1,253 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidType = errors.New("invalid type")
+
+var printConfig = &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// printNode writes node, built without positions, with go/printer.
+func printNode(w io.Writer, node any) error {
+	return printConfig.Fprint(w, token.NewFileSet(), node)
+}
+
+// nodeString returns the code of node.
+func nodeString(node any) string {
+	var b strings.Builder
+	printNode(&b, node)
+	return b.String()
+}
+
+// stmtsString returns the code of stmts, one per line.
+func stmtsString(stmts []ast.Stmt) string {
+	lines := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		lines = append(lines, nodeString(stmt))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseType returns the expression of typ, a type of a FuncSpec: "...T" is the type of a variadic parameter.
+func parseType(typ string) (ast.Expr, error) {
+	if elt, ok := strings.CutPrefix(typ, "..."); ok {
+		expr, err := parseType(elt)
+		return &ast.Ellipsis{Elt: expr}, err
+	}
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidType, typ)
+	}
+	return expr, nil
+}
+
+// fieldList returns the declaration of fields, nil without fields.
+func fieldList(fields []Field) (*ast.FieldList, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	list := &ast.FieldList{}
+	for _, f := range fields {
+		typ, err := parseType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(f.Name)}, Type: typ})
+	}
+	return list, nil
+}
+
+// resultList returns the declaration of the unnamed results of types.
+func resultList(types []string) (*ast.FieldList, error) {
+	list := &ast.FieldList{}
+	for _, t := range types {
+		typ, err := parseType(t)
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, &ast.Field{Type: typ})
+	}
+	return list, nil
+}
+
+// funcDecl returns the declaration of the function name, with the receiver and the type parameters of the
+// wrapper w.
+func funcDecl(w *FuncSpec, name string, params []Field, results []string, body ...ast.Stmt) (*ast.FuncDecl, error) {
+	decl := &ast.FuncDecl{
+		Name: ast.NewIdent(name),
+		Type: &ast.FuncType{},
+		Body: &ast.BlockStmt{List: body},
+	}
+	var err error
+	if w.isMethod() {
+		if decl.Recv, err = fieldList([]Field{*w.Recv}); err != nil {
+			return nil, err
+		}
+	}
+	if decl.Type.TypeParams, err = fieldList(w.TypeParams); err != nil {
+		return nil, err
+	}
+	if decl.Type.Params, err = fieldList(params); err != nil {
+		return nil, err
+	}
+	if decl.Type.Params == nil {
+		decl.Type.Params = &ast.FieldList{}
+	}
+	if decl.Type.Results, err = resultList(results); err != nil {
+		return nil, err
+	}
+	return decl, nil
+}
+
+// selector returns the expression selecting the dot-separated names, eg: m.Client.Get.
+func selector(names ...string) ast.Expr {
+	var expr ast.Expr
+	for _, name := range names {
+		for _, part := range strings.Split(name, ".") {
+			if expr == nil {
+				expr = ast.NewIdent(part)
+				continue
+			}
+			expr = &ast.SelectorExpr{X: expr, Sel: ast.NewIdent(part)}
+		}
+	}
+	return expr
+}
+
+func callExpr(fun ast.Expr, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{Fun: fun, Args: args}
+}
+
+func stringLit(s string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(s)}
+}
+
+func idents(names ...string) []ast.Expr {
+	exprs := make([]ast.Expr, 0, len(names))
+	for _, name := range names {
+		exprs = append(exprs, ast.NewIdent(name))
+	}
+	return exprs
+}
+
+// ifNotNil returns the statement running body when the variable name isn't nil.
+func ifNotNil(name string, body ...ast.Stmt) *ast.IfStmt {
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{X: ast.NewIdent(name), Op: token.NEQ, Y: ast.NewIdent("nil")},
+		Body: &ast.BlockStmt{List: body},
+	}
+}
+
+// instance returns fun instantiated with the type parameters of w, fun without type parameters.
+func instance(fun ast.Expr, w *FuncSpec) ast.Expr {
+	names := make([]string, 0, len(w.TypeParams))
+	for _, tp := range w.TypeParams {
+		names = append(names, tp.Name)
+	}
+	switch len(names) {
+	case 0:
+		return fun
+	case 1:
+		return &ast.IndexExpr{X: fun, Index: ast.NewIdent(names[0])}
+	default:
+		return &ast.IndexListExpr{X: fun, Indices: idents(names...)}
+	}
+}
+
+// wrappedCall returns the call of the function wrapped by w.
+func wrappedCall(w *FuncSpec) *ast.CallExpr {
+	var fun ast.Expr
+	switch {
+	case w.callRecv():
+		fun = selector(w.Recv.Name, w.Name)
+	case w.Pkg != "":
+		fun = selector(w.Pkg, w.Name)
+	default:
+		fun = selector(w.Name)
+	}
+	call := callExpr(instance(fun, w))
+	for _, f := range w.Params {
+		call.Args = append(call.Args, ast.NewIdent(f.Name))
+		if strings.HasPrefix(f.Type, "...") {
+			// any valid position prints the ellipsis
+			call.Ellipsis = token.Pos(1)
+		}
+	}
+	return call
+}
+
+// onceDecl returns the declaration of the package variable of the wrapper w with VariantOnce, holding the results
+// of the wrapped function.
+func onceDecl(w *FuncSpec, v *WrapperView) *ast.GenDecl {
+	onceFunc := "OnceValues"
+	if len(w.Results) == 1 {
+		onceFunc = "OnceValue"
+	}
+	fn := selector(w.Name)
+	if w.Pkg != "" {
+		fn = selector(w.Pkg, w.Name)
+	}
+	return &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{&ast.ValueSpec{
+		Names:  []*ast.Ident{ast.NewIdent(v.OnceVar)},
+		Values: []ast.Expr{callExpr(selector("sync", onceFunc), fn)},
+	}}}
+}
+
+// wrapperDecl returns the declaration of the wrapper w, running onError when the wrapped function fails.
+func wrapperDecl(w *FuncSpec, v *WrapperView, call ast.Expr, onError []ast.Stmt) (*ast.FuncDecl, error) {
+	if w.Iter {
+		// seq := call
+		// return func(yield func(T) bool) {
+		// 	seq(func(v T, err error) bool { ...; return yield(v) })
+		// }
+		elem, err := parseType(w.Results[0])
+		if err != nil {
+			return nil, err
+		}
+		value := ast.NewIdent(v.ResultVars[0])
+		yield := &ast.FuncLit{
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{List: []*ast.Field{
+					{Names: []*ast.Ident{value}, Type: elem},
+					{Names: []*ast.Ident{ast.NewIdent(v.ErrVar)}, Type: ast.NewIdent("error")},
+				}},
+				Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("bool")}}},
+			},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				ifNotNil(v.ErrVar, onError...),
+				&ast.ReturnStmt{Results: []ast.Expr{callExpr(ast.NewIdent("yield"), value)}},
+			}},
+		}
+		seq := &ast.FuncLit{
+			Type: &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{{
+				Names: []*ast.Ident{ast.NewIdent("yield")},
+				Type: &ast.FuncType{
+					Params:  &ast.FieldList{List: []*ast.Field{{Type: elem}}},
+					Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("bool")}}},
+				},
+			}}}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: callExpr(ast.NewIdent(v.SeqVar), yield)}}},
+		}
+		return funcDecl(w, w.NewName, w.wrapperParams(), v.ResultTypes,
+			&ast.AssignStmt{Lhs: idents(v.SeqVar), Tok: token.DEFINE, Rhs: []ast.Expr{call}},
+			&ast.ReturnStmt{Results: []ast.Expr{seq}},
+		)
+	}
+	body := []ast.Stmt{
+		&ast.AssignStmt{Lhs: idents(append(v.ResultVars, v.ErrVar)...), Tok: token.DEFINE, Rhs: []ast.Expr{call}},
+		ifNotNil(v.ErrVar, onError...),
+	}
+	if len(v.ResultVars) > 0 {
+		body = append(body, &ast.ReturnStmt{Results: idents(v.ResultVars...)})
+	}
+	return funcDecl(w, w.NewName, w.wrapperParams(), v.ResultTypes, body...)
+}
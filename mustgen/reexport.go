@@ -0,0 +1,162 @@
+package mustgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Kinds of the re-exported names, see Reexport.
+const (
+	ReexportType  = "type"
+	ReexportConst = "const"
+	ReexportVar   = "var"
+)
+
+// Reexport is an exported name of the wrapped package declared again in the output, so the callers of a shim package
+// only import it: a type alias, a constant or a variable initialized with the one of the package.
+type Reexport struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Pkg is the name of the wrapped package in the output
+	Pkg string `json:"pkg"`
+}
+
+// reexportKinds are the kinds of the re-exported names, in the order of the output.
+var reexportKinds = []string{ReexportType, ReexportConst, ReexportVar}
+
+// sortReexports sorts the re-exports by kind, then by name.
+func sortReexports(reexports []Reexport) {
+	sort.Slice(reexports, func(i, j int) bool {
+		a, b := reexports[i], reexports[j]
+		if a.Kind != b.Kind {
+			return slices.Index(reexportKinds, a.Kind) < slices.Index(reexportKinds, b.Kind)
+		}
+		return a.Name < b.Name
+	})
+}
+
+// syntaxReexports returns the re-exports of the exported types, constants and variables of pkg, named qual in the
+// output, from its syntax. The generic types and the names declared by files with a build constraint are left out.
+func syntaxReexports(pkg *packages.Package, qual string) []Reexport {
+	var reexports []Reexport
+	add := func(kind string, name *ast.Ident) {
+		if name.IsExported() {
+			reexports = append(reexports, Reexport{Kind: kind, Name: name.Name, Pkg: qual})
+		}
+	}
+	for _, file := range pkg.Syntax {
+		tf := pkg.Fset.File(file.Pos())
+		if isGeneratedSyntax(file) || tf == nil || fileConstraint(file, tf.Name()) != "" {
+			continue
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.TypeParams == nil {
+						add(ReexportType, spec.Name)
+					}
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						add(gen.Tok.String(), name)
+					}
+				}
+			}
+		}
+	}
+	sortReexports(reexports)
+	return reexports
+}
+
+// typesReexports is syntaxReexports from the type information of pkg.
+func typesReexports(pkg *types.Package, qual string) []Reexport {
+	var reexports []Reexport
+	for _, name := range pkg.Scope().Names() {
+		obj := pkg.Scope().Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.TypeName:
+			if named, ok := obj.Type().(*types.Named); !ok || named.TypeParams().Len() == 0 {
+				reexports = append(reexports, Reexport{Kind: ReexportType, Name: name, Pkg: qual})
+			}
+		case *types.Const:
+			reexports = append(reexports, Reexport{Kind: ReexportConst, Name: name, Pkg: qual})
+		case *types.Var:
+			reexports = append(reexports, Reexport{Kind: ReexportVar, Name: name, Pkg: qual})
+		}
+	}
+	sortReexports(reexports)
+	return reexports
+}
+
+// planReexports sets the re-exports of plan, leaving out the names declared by the output: the wrappers and the
+// decorators.
+func (g *Gen) planReexports(plan *Plan, reexports []Reexport) {
+	declared := make(map[string]bool, len(plan.Funcs)+len(plan.Decorators))
+	for _, w := range plan.Funcs {
+		declared[w.NewName] = true
+		if name := w.allName(); name != "" {
+			declared[name] = true
+		}
+	}
+	for _, d := range plan.Decorators {
+		declared[d.NewName] = true
+	}
+	for _, r := range reexports {
+		if declared[r.Name] {
+			g.opts.Logger.Warn("name not re-exported, the output declares it", "name", r.Name)
+			continue
+		}
+		plan.Reexports = append(plan.Reexports, r)
+	}
+}
+
+// generateReexports writes the declarations of the re-exports of plan, a declaration per kind.
+func (g *Generator) generateReexports(plan *Plan) error {
+	if len(plan.Reexports) == 0 || plan.Part != "" {
+		return nil
+	}
+	fmt.Fprintf(g, "// the names of %s, re-exported so the callers only need this package\n", plan.Reexports[0].Pkg)
+	for _, kind := range reexportKinds {
+		decl := &ast.GenDecl{}
+		for _, r := range plan.Reexports {
+			if r.Kind != kind {
+				continue
+			}
+			switch kind {
+			case ReexportType:
+				decl.Tok = token.TYPE
+				decl.Specs = append(decl.Specs, &ast.TypeSpec{Name: ast.NewIdent(r.Name), Assign: token.Pos(1), Type: selector(r.Pkg, r.Name)})
+			default:
+				decl.Tok = token.CONST
+				if kind == ReexportVar {
+					decl.Tok = token.VAR
+				}
+				decl.Specs = append(decl.Specs, &ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(r.Name)}, Values: []ast.Expr{selector(r.Pkg, r.Name)}})
+			}
+		}
+		if len(decl.Specs) == 0 {
+			continue
+		}
+		if len(decl.Specs) > 1 {
+			decl.Lparen = token.Pos(1)
+		}
+		if err := printNode(g, decl); err != nil {
+			return err
+		}
+		fmt.Fprintf(g, "\n\n")
+	}
+	return nil
+}
@@ -0,0 +1,691 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// exit codes of Run
+const (
+	ExitOK          = 0 // success
+	ExitError       = 1 // unexpected failure (I/O, formatting)
+	ExitUsage       = 2 // invalid command line
+	ExitLoad        = 3 // the package could not be loaded
+	ExitUnsupported = 4 // a tagged function has an unsupported signature
+	ExitCheck       = 5 // -check or -diff found the output out of date
+	ExitCoverage    = 6 // the coverage of a package is below -min-coverage
+)
+
+func fail(stderr io.Writer, code int, err error) int {
+	if dw, ok := stderr.(*diagnosticWriter); ok {
+		dw.failure(code, err)
+		return code
+	}
+	fmt.Fprintln(stderr, err.Error())
+	return code
+}
+
+func generateExitCode(err error) int {
+	if isUnsupported(err) {
+		return ExitUnsupported
+	}
+	return ExitError
+}
+
+// outputPath returns the path of the output file name: a bare file name is in dir, a path is used as is.
+func outputPath(dir, name string) string {
+	if filepath.IsAbs(name) || strings.ContainsAny(name, `/`+string(filepath.Separator)) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// packageDir returns the directory of the package matching patterns, see relativeDir.
+func packageDir(ctx context.Context, patterns []string, buildFlags []string) (string, error) {
+	files, err := PackageFiles(ctx, patterns, buildFlags...)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", ErrNoPackageFound
+	}
+	return relativeDir(filepath.Dir(files[0])), nil
+}
+
+// relativeDir returns dir relative to the working directory when it's below it, dir otherwise.
+func relativeDir(dir string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, dir); err == nil && filepath.IsLocal(rel) {
+			return rel
+		}
+	}
+	return dir
+}
+
+// pathFlags are the flags whose value is a path.
+var pathFlags = map[string]bool{
+	"out": true, "outdir": true, "header-file": true, "template": true, "cache": true, "plan-in": true, "plan-out": true,
+	"modfile": true, "wrap-file": true, "manifest": true, "doc": true, "sarif": true, "tests": true, "bench": true,
+}
+
+// repeatedFlag is a flag that can be given several times, each value is checked by parse.
+type repeatedFlag struct {
+	values []string
+	parse  func(value string) error
+}
+
+func (f *repeatedFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ", ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	if err := f.parse(value); err != nil {
+		return err
+	}
+	f.values = append(f.values, value)
+	return nil
+}
+
+// goGenerateArgs returns the command line arguments of flags for a //go:generate directive of a file of dir: go
+// generate runs it from dir, the paths are made relative to it. The flags are sorted by name.
+func goGenerateArgs(flags *flag.FlagSet, dir string) string {
+	var args []string
+	flags.Visit(func(f *flag.Flag) {
+		if r, ok := f.Value.(*repeatedFlag); ok {
+			for _, value := range r.values {
+				args = append(args, "-"+f.Name+"="+quoteArg(value))
+			}
+			return
+		}
+		value := f.Value.String()
+		switch {
+		case f.Name == "out" && value != "-" && strings.ContainsAny(value, `/`+string(filepath.Separator)):
+			// still a path, not a name in the directory of the package
+			if value = relativeTo(dir, value); !strings.Contains(value, "/") {
+				value = "./" + value
+			}
+		case f.Name != "out" && pathFlags[f.Name]:
+			value = relativeTo(dir, value)
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() && value == "true" {
+			args = append(args, "-"+f.Name)
+			return
+		}
+		args = append(args, "-"+f.Name+"="+quoteArg(value))
+	})
+	for _, arg := range flags.Args() {
+		// the local files and directories, not the import paths
+		if _, err := os.Stat(arg); err == nil {
+			arg = relativeTo(dir, arg)
+			if !filepath.IsAbs(arg) && !strings.HasPrefix(arg, ".") {
+				arg = "./" + arg
+			}
+		}
+		args = append(args, quoteArg(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+// relativeTo returns the path name relative to dir, with forward slashes. - (stdout) and absolute paths are kept.
+func relativeTo(dir, name string) string {
+	if name == "" || name == "-" || filepath.IsAbs(name) {
+		return name
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return name
+	}
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	rel, err := filepath.Rel(absDir, absName)
+	if err != nil {
+		return name
+	}
+	return filepath.ToSlash(rel)
+}
+
+// quoteArg quotes arg for go generate when it's empty or holds spaces or quotes.
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\"'`") {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
+
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "usage: gen_must %s file_0.go file_1.go ... file_n.go\n", synopsis(flags))
+	fmt.Fprintf(out, "       gen_must module [flags] [packages]\n")
+	fmt.Fprintf(out, "       gen_must clean [-n] packages\n")
+	fmt.Fprintf(out, "       gen_must vet-directives [-json] [-typecheck] [-loose-directives] [-tags tags] packages\n")
+	fmt.Fprintf(out, "       gen_must suggest [-v] [-min n] [-tags tags] packages\n")
+	fmt.Fprintf(out, "       gen_must coverage [-v] [-min-coverage percent] [-tags tags] packages\n")
+	fmt.Fprintf(out, "       gen_must config [-check] [-diff] file\n\n")
+	flags.PrintDefaults()
+	fmt.Fprintf(out, `
+exit codes:
+  %d  success
+  %d  unexpected failure (I/O, formatting)
+  %d  invalid command line
+  %d  the package could not be loaded
+  %d  a tagged function has an unsupported signature
+  %d  -check or -diff found the output out of date
+  %d  the coverage of a package is below -min-coverage
+`, ExitOK, ExitError, ExitUsage, ExitLoad, ExitUnsupported, ExitCheck, ExitCoverage)
+}
+
+func readPlan(fsys Files, name string) (*Plan, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPlan(f)
+}
+
+func writeJSON(fsys Files, stdout io.Writer, name string, v interface{ Write(io.Writer) error }) error {
+	if name == "-" {
+		return v.Write(stdout)
+	}
+	return fsys.writeWith(name, v.Write)
+}
+
+func clean(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("clean", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dryRun := flags.Bool("n", false, "print the files that would be removed, without removing them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gen_must clean [-n] packages\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	files, err := GeneratedFiles(ctx, flags.Args())
+	if err != nil {
+		return fail(stderr, ExitLoad, err)
+	}
+	for _, name := range files {
+		if *dryRun {
+			fmt.Fprintln(stdout, name)
+			continue
+		}
+		if err = os.Remove(name); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+	}
+	return ExitOK
+}
+
+func vetDirectives(ctx context.Context, args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vet-directives", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	jsonDiag := flags.Bool("json", false, "write the findings to stderr as JSON objects, one per line")
+	typeChk := flags.Bool("typecheck", false, "type-check the packages, to accept concrete error types and qualify the dot-imports")
+	loose := flags.Bool("loose-directives", false, "accept the near misses of the directives instead of reporting them")
+	tags := flags.String("tags", "", "comma-separated list of build tags used to load the packages")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gen_must vet-directives [-json] [-typecheck] [-loose-directives] [-tags tags] packages\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	if _, ok := stderr.(*diagnosticWriter); *jsonDiag && !ok {
+		stderr = &diagnosticWriter{w: stderr}
+	}
+	var buildFlags []string
+	if *tags != "" {
+		buildFlags = append(buildFlags, "-tags="+*tags)
+	}
+	g := New(WithTypeCheck(*typeChk), WithLooseDirectives(*loose))
+	pkgs, err := g.LoadPackages(ctx, flags.Args(), buildFlags...)
+	if err != nil {
+		return fail(stderr, ExitLoad, err)
+	}
+	var errs ErrorList
+	for _, pkg := range pkgs {
+		err := g.Vet(ctx, pkg)
+		var list ErrorList
+		switch {
+		case errors.As(err, &list):
+			errs = append(errs, list...)
+		case err != nil:
+			return fail(stderr, ExitError, err)
+		}
+	}
+	if len(errs) == 0 {
+		return ExitOK
+	}
+	if dw, ok := stderr.(*diagnosticWriter); ok {
+		// the code of each finding tells an unsupported signature from a mistake in a directive
+		for _, err := range errs {
+			dw.failure(generateExitCode(err), err)
+		}
+		return generateExitCode(errs)
+	}
+	return fail(stderr, generateExitCode(errs), errs)
+}
+
+func suggest(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("suggest", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	verbose := flags.Bool("v", false, "list the calls panicking on the error of each function")
+	minCalls := flags.Int("min", 3, "minimum number of calls panicking on the error of a function to suggest it")
+	tags := flags.String("tags", "", "comma-separated list of build tags used to load the packages")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gen_must suggest [-v] [-min n] [-tags tags] packages\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	var buildFlags []string
+	if *tags != "" {
+		buildFlags = append(buildFlags, "-tags="+*tags)
+	}
+	g := New()
+	pkgs, err := g.LoadPackages(ctx, flags.Args(), buildFlags...)
+	if err != nil {
+		return fail(stderr, ExitLoad, err)
+	}
+	for _, s := range g.Suggest(pkgs, *minCalls) {
+		fmt.Fprintf(stdout, "%s: %s.%s: %d calls panic on its error\n", s.Pos, s.PkgPath, s.Func, len(s.Calls))
+		if *verbose {
+			for _, pos := range s.Calls {
+				fmt.Fprintf(stdout, "\t%s\n", pos)
+			}
+		}
+	}
+	return ExitOK
+}
+
+// runConfig generates the wrappers of the packages of a Config, each with Run.
+func runConfig(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	check := flags.Bool("check", false, "check that the outputs are up to date instead of writing them")
+	diffOut := flags.Bool("diff", false, "print the diff of the out of date outputs instead of writing them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gen_must config [-check] [-diff] file\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fail(stderr, ExitUsage, err)
+	}
+	c, err := ReadConfig(f)
+	f.Close()
+	if err != nil {
+		return fail(stderr, ExitUsage, fmt.Errorf("%s: %w", flags.Arg(0), err))
+	}
+	code := ExitOK
+	for i := range c.Packages {
+		pkgArgs, err := c.Args(i, filepath.Dir(flags.Arg(0)))
+		if err != nil {
+			return fail(stderr, ExitUsage, err)
+		}
+		if *check {
+			pkgArgs = append([]string{"-check"}, pkgArgs...)
+		}
+		if *diffOut {
+			pkgArgs = append([]string{"-diff"}, pkgArgs...)
+		}
+		if pkgCode := Run(ctx, pkgArgs, stdout, stderr); pkgCode != ExitOK && code == ExitOK {
+			code = pkgCode
+		}
+	}
+	return code
+}
+
+// coverage reports the coverage of the packages matching the arguments, see Gen.Coverage.
+func coverage(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("coverage", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	verbose := flags.Bool("v", false, "list the functions without Must counterpart")
+	minCoverage := flags.Float64("min-coverage", 0, "minimum percentage of the functions having a Must counterpart, in each package")
+	tags := flags.String("tags", "", "comma-separated list of build tags used to load the packages")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gen_must coverage [-v] [-min-coverage percent] [-tags tags] packages\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	if flags.NArg() == 0 || *minCoverage < 0 || *minCoverage > 100 {
+		flags.Usage()
+		return ExitUsage
+	}
+	var buildFlags []string
+	if *tags != "" {
+		buildFlags = append(buildFlags, "-tags="+*tags)
+	}
+	g := New()
+	pkgs, err := g.LoadPackages(ctx, flags.Args(), buildFlags...)
+	if err != nil {
+		return fail(stderr, ExitLoad, err)
+	}
+	var below []string
+	for _, pkg := range pkgs {
+		cov, err := g.Coverage(ctx, pkg)
+		if err != nil {
+			return fail(stderr, ExitError, err)
+		}
+		fmt.Fprintf(stdout, "%s: %d/%d functions with a Must counterpart (%.1f%%)\n", cov.PkgPath, cov.Covered, cov.Funcs, cov.Percent())
+		if *verbose {
+			for _, name := range cov.Missing {
+				fmt.Fprintf(stdout, "\t%s\n", name)
+			}
+		}
+		if cov.Percent() < *minCoverage {
+			below = append(below, cov.PkgPath)
+		}
+	}
+	if len(below) > 0 {
+		return fail(stderr, ExitCoverage, fmt.Errorf("coverage below %g%%: %s", *minCoverage, strings.Join(below, ", ")))
+	}
+	return ExitOK
+}
+
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
+
+// writeSideOutputs writes the manifest and the markdown summary of the declarations of plan, written to files.
+func writeSideOutputs(g *Gen, stdout io.Writer, manifest, docFile string, plan *Plan, files []PlanFile) error {
+	if manifest != "" {
+		gen := g.Generator(io.Discard)
+		m := &Manifest{Package: plan.Package}
+		for _, f := range files {
+			fm, err := gen.Manifest(f.Plan, f.Path)
+			if err != nil {
+				return err
+			}
+			m.Wrappers = append(m.Wrappers, fm.Wrappers...)
+		}
+		if err := writeJSON(g.opts.Files, stdout, manifest, m); err != nil {
+			return err
+		}
+	}
+	if docFile == "" {
+		return nil
+	}
+	return g.opts.Files.writeWith(docFile, func(w io.Writer) error { return g.Generator(w).EmitDoc(docFile, files) })
+}
+
+// staleFindings returns a finding for each stale wrapper of plan, or one for outPath if the wrappers are up to date
+// but the file isn't.
+func staleFindings(g *Gen, plan *Plan, current []byte, outPath string) ([]Finding, error) {
+	stale, err := g.StaleWrappers(plan, current)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, w := range stale {
+		findings = append(findings, Finding{
+			Rule:    RuleStaleWrapper,
+			Message: fmt.Sprintf("%s is missing or out of date in %s, run gen_must", w.NewName, outPath),
+			Pos:     w.Pos,
+		})
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{
+			Rule:    RuleStaleWrapper,
+			Message: fmt.Sprintf("%s is out of date, run gen_must", outPath),
+			Pos:     token.Position{Filename: outPath, Line: 1},
+		})
+	}
+	return findings, nil
+}
+
+// writeSkeletons writes the test and the benchmark files of the wrappers of plan, when requested. - writes them to
+// stdout.
+func writeSkeletons(g *Gen, stdout io.Writer, dir, tests, benches string, plan *Plan) error {
+	if tests != "" {
+		if err := writeSkeleton(g, stdout, skeletonPath(dir, tests), plan, (*Generator).EmitTests); err != nil {
+			return err
+		}
+	}
+	if benches != "" {
+		return writeSkeleton(g, stdout, skeletonPath(dir, benches), plan, (*Generator).EmitBenchmarks)
+	}
+	return nil
+}
+
+// skeletonPath returns the path of a skeleton file named name, in dir unless it's a path. It's empty for -, stdout.
+func skeletonPath(dir, name string) string {
+	if name == "-" {
+		return ""
+	}
+	return outputPath(dir, name)
+}
+
+// writeSkeleton writes a file emitted by emit, unless it already exists: it's edited by hand. An empty path writes
+// it to stdout.
+func writeSkeleton(g *Gen, stdout io.Writer, path string, plan *Plan, emit func(*Generator, *Plan) error) error {
+	if path != "" {
+		_, err := g.ReadFile(path)
+		if err == nil {
+			g.opts.Logger.Warn("file already exists, not overwritten", "file", path)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err := emit(g.Generator(buffer), plan); err != nil {
+		return err
+	}
+	fmtCode := bytes.NewBuffer(make([]byte, 0, buffer.Len()))
+	if err := g.Format(path, buffer, fmtCode); err != nil {
+		return err
+	}
+	if path == "" {
+		_, err := stdout.Write(fmtCode.Bytes())
+		return err
+	}
+	return g.WriteFile(path, fmtCode.Bytes())
+}
+
+// streamOutput streams the code of plan to outPath, or to stdout if outPath is empty.
+func streamOutput(g *Gen, stdout io.Writer, outPath string, plan *Plan) error {
+	if outPath == "" {
+		return g.Stream(stdout, plan)
+	}
+	f, err := g.opts.Files.Create(outPath, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = g.Stream(f, plan); err != nil {
+		// the output is left as it was
+		discardFile(f)
+		return err
+	}
+	return f.Close()
+}
+
+func writeSARIF(fsys Files, name string, findings []Finding) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return fsys.writeWith(name, func(w io.Writer) error { return WriteSARIF(w, toolVersion(), wd, findings) })
+}
+
+// unsupportedFindings returns a finding for each function of err that can't be wrapped.
+func unsupportedFindings(err error) []Finding {
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	var list ErrorList
+	if errors.As(err, &list) {
+		errs = list
+	}
+	var findings []Finding
+	for _, err := range errs {
+		var posErr *PosError
+		if errors.As(err, &posErr) {
+			findings = append(findings, Finding{Rule: RuleUnsupportedFunction, Message: posErr.Error(), Pos: posErr.Pos})
+		}
+	}
+	return findings
+}
+
+// writeDiff writes the unified diff from current, the content of the file name, to generated.
+func writeDiff(w io.Writer, name string, current, generated []byte) error {
+	return difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(current)),
+		B:        difflib.SplitLines(string(generated)),
+		FromFile: name,
+		ToFile:   name + " (generated)",
+		Context:  3,
+	})
+}
+
+// containsFiles tells whether all the names are files of files.
+func containsFiles(files, names []string) bool {
+	for _, name := range names {
+		if !slices.ContainsFunc(files, func(f string) bool { return sameFile(f, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// runPackages runs gen_must with run for each package of paths, each output going to the directory of its
+// package. It returns the first failed exit code, after running all of them.
+func runPackages(paths []string, run func(path string) int) int {
+	code := ExitOK
+	for _, path := range paths {
+		if c := run(path); c != ExitOK && code == ExitOK {
+			code = c
+		}
+	}
+	return code
+}
+
+// runModule runs gen_must with run for each package of paths, like runPackages, then writes a summary of the
+// outcome of each one to stderr, away from the code written to stdout.
+func runModule(paths []string, run func(path string) int, stderr io.Writer) int {
+	code := ExitOK
+	failed := 0
+	summary := make([]string, 0, len(paths))
+	for _, path := range paths {
+		c := run(path)
+		if c != ExitOK {
+			failed++
+			if code == ExitOK {
+				code = c
+			}
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", path, exitStatus(c)))
+	}
+	summary = append(summary, fmt.Sprintf("packages with directives: %d, failed: %d", len(paths), failed))
+	dw, isJSON := stderr.(*diagnosticWriter)
+	for _, line := range summary {
+		if isJSON {
+			dw.write(Diagnostic{Level: "info", Code: "summary", Message: line})
+			continue
+		}
+		fmt.Fprintln(stderr, line)
+	}
+	return code
+}
+
+// exitStatus describes the exit code of the run of a package in the summary of runModule.
+func exitStatus(code int) string {
+	switch code {
+	case ExitOK:
+		return "ok"
+	case ExitUsage:
+		return "invalid command line"
+	case ExitLoad:
+		return "not loaded"
+	case ExitUnsupported:
+		return "unsupported signature"
+	case ExitCheck:
+		return "out of date"
+	}
+	return "failed"
+}
+
+// Run runs gen_must with the command line arguments args (without the program name), writing the generated code
+// and the messages to stdout and stderr. It returns the exit code.
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "clean" {
+		return clean(ctx, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "vet-directives" {
+		return vetDirectives(ctx, args[1:], stderr)
+	}
+	if len(args) > 0 && args[0] == "suggest" {
+		return suggest(ctx, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "coverage" {
+		return coverage(ctx, args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		return runConfig(ctx, args[1:], stdout, stderr)
+	}
+	c, code := parseCommand(args, stdout, stderr)
+	if c == nil {
+		return code
+	}
+	return c.run(ctx, stdout, stderr)
+}
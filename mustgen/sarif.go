@@ -0,0 +1,120 @@
+package mustgen
+
+import (
+	"encoding/json"
+	"go/token"
+	"io"
+	"path/filepath"
+)
+
+// Rules of the findings reported in SARIF logs.
+const (
+	RuleStaleWrapper        = "stale-wrapper"
+	RuleUnsupportedFunction = "unsupported-function"
+)
+
+var sarifRules = []sarifRule{
+	{ID: RuleStaleWrapper, ShortDescription: sarifMessage{Text: "The generated wrapper is missing or out of date, run gen_must"}},
+	{ID: RuleUnsupportedFunction, ShortDescription: sarifMessage{Text: "The tagged function can't be wrapped"}},
+}
+
+// Finding is a problem found by gen_must, reported at Pos.
+type Finding struct {
+	Rule    string
+	Message string
+	Pos     token.Position
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// WriteSARIF writes findings as a SARIF 2.1.0 log, for code scanning UIs. The paths are made relative to baseDir
+// when possible.
+func WriteSARIF(w io.Writer, version string, baseDir string, findings []Finding) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "gen_must",
+			Version:        version,
+			InformationURI: "https://github.com/heliorosa/gen_must",
+			Rules:          sarifRules,
+		}},
+		Results: make([]sarifResult, 0, len(findings)),
+	}
+	for _, f := range findings {
+		res := sarifResult{RuleID: f.Rule, Level: "error", Message: sarifMessage{Text: f.Message}}
+		if f.Pos.Filename != "" {
+			uri := f.Pos.Filename
+			if rel, err := filepath.Rel(baseDir, uri); err == nil && filepath.IsAbs(uri) {
+				uri = rel
+			}
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(uri)},
+			}}
+			if f.Pos.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Pos.Line, StartColumn: f.Pos.Column}
+			}
+			res.Locations = append(res.Locations, loc)
+		}
+		run.Results = append(run.Results, res)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,118 @@
+package mustgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	ErrFilesSpanPackages = errors.New("the files must be in the directory of a single package")
+	ErrFileNotInPackage  = errors.New("not a file of the package, excluded by its build constraints?")
+)
+
+// FilesPackage returns the pattern of the package of files, the directory holding them, when patterns are .go
+// files. isFiles is false when they aren't, eg: for a package pattern.
+func FilesPackage(patterns []string) (pattern string, isFiles bool, err error) {
+	if len(patterns) == 0 {
+		return "", false, nil
+	}
+	for _, name := range patterns {
+		if !strings.HasSuffix(name, ".go") {
+			return "", false, nil
+		}
+	}
+	dir, err := filepath.Abs(filepath.Dir(patterns[0]))
+	if err != nil {
+		return "", true, err
+	}
+	for _, name := range patterns[1:] {
+		d, err := filepath.Abs(filepath.Dir(name))
+		if err != nil {
+			return "", true, err
+		}
+		if d != dir {
+			return "", true, fmt.Errorf("%w: %s and %s", ErrFilesSpanPackages, patterns[0], name)
+		}
+	}
+	return dir, true, nil
+}
+
+// scanned returns pkg with only the syntax of the files of the ScanFiles option, all of them when it's empty.
+// The files guarded by //go:build ignore are left out, unless ScanIgnored is set.
+func (g *Gen) scanned(pkg *packages.Package) (*packages.Package, error) {
+	files := pkg.Syntax
+	if len(g.opts.ScanFiles) > 0 {
+		byName := make(map[string]*ast.File, len(pkg.Syntax))
+		for _, file := range pkg.Syntax {
+			if tf := pkg.Fset.File(file.Pos()); tf != nil {
+				byName[tf.Name()] = file
+			}
+		}
+		files = make([]*ast.File, 0, len(g.opts.ScanFiles))
+		for _, name := range g.opts.ScanFiles {
+			abs, err := filepath.Abs(name)
+			if err != nil {
+				return nil, err
+			}
+			file, ok := byName[abs]
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", name, ErrFileNotInPackage)
+			}
+			files = append(files, file)
+		}
+	}
+	scanned := *pkg
+	scanned.Syntax = make([]*ast.File, 0, len(files))
+	for _, file := range files {
+		if !g.opts.ScanIgnored && isIgnored(file) {
+			g.opts.Logger.Warn("file guarded by //go:build ignore skipped", "file", pkg.Fset.Position(file.Pos()).Filename)
+			continue
+		}
+		scanned.Syntax = append(scanned.Syntax, file)
+	}
+	return &scanned, nil
+}
+
+// isIgnored reports whether file is guarded by the ignore build tag, eg: //go:build ignore, the convention of the
+// programs living in the directory of a package, run by go run or go generate.
+func isIgnored(file *ast.File) bool {
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+			expr, err := constraint.Parse(c.Text)
+			if err != nil {
+				return false
+			}
+			mentioned := false
+			// the other tags are satisfied: the file is ignored if it builds only with ignore
+			builds := expr.Eval(func(tag string) bool {
+				if tag == "ignore" {
+					mentioned = true
+					return false
+				}
+				return true
+			})
+			return mentioned && !builds
+		}
+	}
+	return false
+}
+
+// digestFiles returns the files of the source digest: the ones of the ScanFiles option, or files.
+func (g *Gen) digestFiles(files []string) []string {
+	if len(g.opts.ScanFiles) > 0 {
+		return g.opts.ScanFiles
+	}
+	return files
+}
@@ -0,0 +1,79 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
+	"testing/fstest"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var ErrMixedPackages = errors.New("files of different packages")
+
+// ParseSources returns the package of sources, its go files by name, parsed without go/packages nor the file
+// system, eg: in a browser or a sandboxed service. pkgPath is its import path, the package name when empty. The
+// package has no type information, like the ones of Load without TypeCheck, and the test files are left out.
+func ParseSources(pkgPath string, sources map[string]string) (*packages.Package, error) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		if strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, ErrNoPackageFound
+	}
+	slices.Sort(names)
+	pkg := &packages.Package{ID: pkgPath, PkgPath: pkgPath, Fset: token.NewFileSet(), GoFiles: names, CompiledGoFiles: names}
+	for _, name := range names {
+		file, err := parser.ParseFile(pkg.Fset, name, sources[name], parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case pkg.Name == "":
+			pkg.Name = file.Name.Name
+		case pkg.Name != file.Name.Name:
+			return nil, fmt.Errorf("%w: %s and %s", ErrMixedPackages, pkg.Name, file.Name.Name)
+		}
+		pkg.Syntax = append(pkg.Syntax, file)
+	}
+	if pkg.PkgPath == "" {
+		pkg.ID, pkg.PkgPath = pkg.Name, pkg.Name
+	}
+	return pkg, nil
+}
+
+// GenerateSources returns the formatted wrappers of the package of sources, see ParseSources. The sources are read
+// from memory, whatever Options.Files, and nothing is written.
+func (g *Gen) GenerateSources(ctx context.Context, pkgPath string, sources map[string]string) ([]byte, error) {
+	pkg, err := ParseSources(pkgPath, sources)
+	if err != nil {
+		return nil, err
+	}
+	fsys := make(fstest.MapFS, len(sources))
+	for name, src := range sources {
+		fsys[fsPath(name)] = &fstest.MapFile{Data: []byte(src)}
+	}
+	mem := &Gen{opts: g.opts}
+	mem.opts.Files = Files{FS: fsys}
+	plan, err := mem.Plan(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err = mem.Generator(buffer).Emit(plan); err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(make([]byte, 0, buffer.Len()))
+	if err = mem.Format("", buffer, out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package mustgen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+)
+
+// StaleWrappers returns the wrappers of plan that are missing from current, the content of the output file,
+// or that differ from the code g would generate for them.
+func (g *Gen) StaleWrappers(plan *Plan, current []byte) ([]*FuncSpec, error) {
+	existing := map[string]string{}
+	if file, fset, err := parseDecls(current); err == nil {
+		existing = printDecls(file, fset)
+	}
+	var stale []*FuncSpec
+	for _, w := range plan.Funcs {
+		buffer := bytes.NewBufferString("package " + plan.Package + "\n\n")
+		gen := g.Generator(buffer)
+		gen.planImports = plan.Imports
+		if err := gen.GenerateWrapper(w); err != nil {
+			return nil, err
+		}
+		file, fset, err := parseDecls(buffer.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		for key, decl := range printDecls(file, fset) {
+			if existing[key] != decl {
+				stale = append(stale, w)
+				break
+			}
+		}
+	}
+	return stale, nil
+}
+
+func parseDecls(src []byte) (*ast.File, *token.FileSet, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	return file, fset, err
+}
+
+// printDecls returns the normalized source of the functions declared in file, by receiver and name.
+func printDecls(file *ast.File, fset *token.FileSet) map[string]string {
+	decls := make(map[string]string)
+	for _, decl := range file.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		buffer := &bytes.Buffer{}
+		if err := printer.Fprint(buffer, fset, fd); err != nil {
+			continue
+		}
+		decls[funcKey(recvName(fd.Recv), fd.Name.Name)] = buffer.String()
+	}
+	return decls
+}
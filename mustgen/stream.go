@@ -0,0 +1,98 @@
+package mustgen
+
+import (
+	"bytes"
+	"go/format"
+	"io"
+
+	"golang.org/x/tools/imports"
+)
+
+// CanStream reports whether Stream can be used with the options of g: the formatter must be gofmt, or goimports
+// (the imports of a plan are complete), and there must be no AfterFile hook, which needs the whole file.
+func (g *Gen) CanStream() bool {
+	switch g.opts.Formatter {
+	case "", "gofmt", "goimports":
+		return g.opts.AfterFile == nil
+	default:
+		return false
+	}
+}
+
+// Stream writes the formatted code of plan to w a window of wrappers at a time (see Options.Window), instead of
+// formatting the whole file at once, so large packages don't need the whole output in memory. See CanStream.
+func (g *Gen) Stream(w io.Writer, plan *Plan) error {
+	buildConstraint, err := planConstraint(plan)
+	if err != nil {
+		return err
+	}
+	chunk := bytes.NewBuffer(make([]byte, 0, 1024))
+	gen := g.Generator(chunk)
+	gen.planImports = plan.Imports
+	if err = gen.runPlugins(plan); err != nil {
+		return err
+	}
+	if err = gen.generateHead(plan.Package, plan.Digest, buildConstraint); err != nil {
+		return err
+	}
+	gen.GenerateImports(gen.imports(plan))
+	gen.generateSupport(plan)
+	if err = gen.generateReexports(plan); err != nil {
+		return err
+	}
+	head := chunk.Bytes()
+	if g.opts.Formatter == "goimports" {
+		// the imports are grouped like goimports does, they are complete
+		if head, err = imports.Process("", head, &imports.Options{FormatOnly: true, Comments: true, TabIndent: true, TabWidth: 8}); err != nil {
+			return err
+		}
+	}
+	if err = g.writeChunk(w, head, ""); err != nil {
+		return err
+	}
+	const pkgClause = "package p\n"
+	window := max(g.opts.Window, 1)
+	for start := 0; start < len(plan.Funcs); start += window {
+		chunk.Reset()
+		chunk.WriteString(pkgClause + "\n")
+		for _, spec := range plan.Funcs[start:min(start+window, len(plan.Funcs))] {
+			if err = gen.GenerateWrapper(spec); err != nil {
+				return err
+			}
+		}
+		if err = g.writeChunk(w, chunk.Bytes(), pkgClause); err != nil {
+			return err
+		}
+	}
+	for _, d := range plan.Decorators {
+		chunk.Reset()
+		chunk.WriteString(pkgClause + "\n")
+		if err = gen.generateDecorator(d); err != nil {
+			return err
+		}
+		if err = g.writeChunk(w, chunk.Bytes(), pkgClause); err != nil {
+			return err
+		}
+	}
+	if gen.Factory && len(factoryFuncs(plan)) > 0 {
+		chunk.Reset()
+		chunk.WriteString(pkgClause + "\n")
+		if err = gen.generateFactory(plan); err != nil {
+			return err
+		}
+		return g.writeChunk(w, chunk.Bytes(), pkgClause)
+	}
+	return nil
+}
+
+// writeChunk formats src, a go file, and writes it to w without prefix.
+func (g *Gen) writeChunk(w io.Writer, src []byte, prefix string) error {
+	if g.opts.Formatter != "" {
+		var err error
+		if src, err = format.Source(src); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(bytes.TrimPrefix(src, []byte(prefix)))
+	return err
+}
@@ -0,0 +1,224 @@
+package mustgen
+
+import (
+	"go/ast"
+	"go/token"
+	"slices"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Suggestion is an exported function returning an error whose callers panic on its error, a candidate for a
+// directive.
+type Suggestion struct {
+	PkgPath string `json:"pkgPath"`
+	Func    string `json:"func"`
+	// Pos is the position of the function, Calls the ones of the calls panicking on its error
+	Pos   token.Position   `json:"pos"`
+	Calls []token.Position `json:"calls"`
+}
+
+// calleeKey identifies a function of a package.
+type calleeKey struct{ pkgPath, name string }
+
+// fatalFuncs are the functions of the log package ending the program, like panic.
+var fatalFuncs = []string{"Fatal", "Fatalf", "Fatalln", "Panic", "Panicf", "Panicln"}
+
+// Suggest returns the exported functions of pkgs returning an error, without a directive, called at least minCalls
+// times in pkgs by a call followed by if err != nil { panic(err) }, or log.Fatal. The calls are found from the
+// syntax, the methods aren't suggested. The suggestions are sorted by decreasing number of calls.
+func (g *Gen) Suggest(pkgs []*packages.Package, minCalls int) []Suggestion {
+	candidates := make(map[calleeKey]*Suggestion)
+	names := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		names[pkg.PkgPath] = pkg.Name
+		for _, file := range pkg.Syntax {
+			if isGeneratedSyntax(file) {
+				continue
+			}
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv != nil || !fn.Name.IsExported() || !returnsError(fn) {
+					continue
+				}
+				if c := bodyComment(file, fn); c != nil {
+					if _, tagged := g.directiveTag().parse(c.Text); tagged {
+						continue
+					}
+				}
+				candidates[calleeKey{pkg.PkgPath, fn.Name.Name}] = &Suggestion{
+					PkgPath: pkg.PkgPath,
+					Func:    fn.Name.Name,
+					Pos:     pkg.Fset.Position(fn.Pos()),
+				}
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			if isGeneratedSyntax(file) {
+				continue
+			}
+			imports := fileImports(file, names)
+			for _, call := range panickingCalls(file, imports) {
+				key, ok := callee(call, pkg.PkgPath, imports)
+				if s := candidates[key]; ok && s != nil {
+					s.Calls = append(s.Calls, pkg.Fset.Position(call.Pos()))
+				}
+			}
+		}
+	}
+	var suggestions []Suggestion
+	for _, s := range candidates {
+		if len(s.Calls) >= max(minCalls, 1) {
+			suggestions = append(suggestions, *s)
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if len(a.Calls) != len(b.Calls) {
+			return len(a.Calls) > len(b.Calls)
+		}
+		if a.PkgPath != b.PkgPath {
+			return a.PkgPath < b.PkgPath
+		}
+		return a.Func < b.Func
+	})
+	return suggestions
+}
+
+// returnsError reports whether the last result of fn is an error.
+func returnsError(fn *ast.FuncDecl) bool {
+	results := fn.Type.Results
+	if results == nil || len(results.List) == 0 {
+		return false
+	}
+	ident, ok := results.List[len(results.List)-1].Type.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// fileImports returns the import paths of file by name: the name of the loaded packages, guessed from the path for
+// the other ones.
+func fileImports(file *ast.File, names map[string]string) map[string]string {
+	imports := make(map[string]string, len(file.Imports))
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name, ok := names[importPath]
+		switch {
+		case spec.Name != nil:
+			name = spec.Name.Name
+		case !ok:
+			name = guessPackageName(importPath)
+		}
+		imports[name] = importPath
+	}
+	return imports
+}
+
+// callee returns the function called by call, a function of the package pkgPath or of an import.
+func callee(call *ast.CallExpr, pkgPath string, imports map[string]string) (calleeKey, bool) {
+	fun := call.Fun
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return calleeKey{pkgPath, f.Name}, true
+	case *ast.SelectorExpr:
+		if x, ok := f.X.(*ast.Ident); ok && imports[x.Name] != "" {
+			return calleeKey{imports[x.Name], f.Sel.Name}, true
+		}
+	}
+	return calleeKey{}, false
+}
+
+// panickingCalls returns the calls of file whose error is checked by if err != nil { panic(err) }, right after
+// the call or in the same if statement.
+func panickingCalls(file *ast.File, imports map[string]string) []*ast.CallExpr {
+	var calls []*ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		var list []ast.Stmt
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			list = n.List
+		case *ast.CaseClause:
+			list = n.Body
+		case *ast.CommClause:
+			list = n.Body
+		}
+		for i, stmt := range list {
+			if ifStmt, ok := stmt.(*ast.IfStmt); ok && ifStmt.Init != nil {
+				if call, errVar := errCall(ifStmt.Init); call != nil && panicsOn(ifStmt, errVar, imports) {
+					calls = append(calls, call)
+				}
+				continue
+			}
+			if i+1 == len(list) {
+				continue
+			}
+			ifStmt, ok := list[i+1].(*ast.IfStmt)
+			if !ok || ifStmt.Init != nil {
+				continue
+			}
+			if call, errVar := errCall(stmt); call != nil && panicsOn(ifStmt, errVar, imports) {
+				calls = append(calls, call)
+			}
+		}
+		return true
+	})
+	return calls
+}
+
+// errCall returns the call of stmt, an assignment of the results of a call, and the variable of its last result.
+func errCall(stmt ast.Stmt) (*ast.CallExpr, string) {
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || len(assign.Rhs) != 1 || len(assign.Lhs) == 0 {
+		return nil, ""
+	}
+	call, ok := assign.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return nil, ""
+	}
+	errVar, ok := assign.Lhs[len(assign.Lhs)-1].(*ast.Ident)
+	if !ok || errVar.Name == "_" {
+		return nil, ""
+	}
+	return call, errVar.Name
+}
+
+// panicsOn reports whether ifStmt is if errVar != nil { panic(...) }, or log.Fatal(...), without else.
+func panicsOn(ifStmt *ast.IfStmt, errVar string, imports map[string]string) bool {
+	cond, ok := ifStmt.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.NEQ || ifStmt.Else != nil || len(ifStmt.Body.List) != 1 {
+		return false
+	}
+	x, okX := cond.X.(*ast.Ident)
+	y, okY := cond.Y.(*ast.Ident)
+	if !okX || !okY || x.Name != errVar || y.Name != "nil" {
+		return false
+	}
+	expr, ok := ifStmt.Body.List[0].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name == "panic"
+	case *ast.SelectorExpr:
+		x, ok := fun.X.(*ast.Ident)
+		return ok && imports[x.Name] == "log" && slices.Contains(fatalFuncs, fun.Sel.Name)
+	}
+	return false
+}
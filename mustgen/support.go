@@ -0,0 +1,74 @@
+package mustgen
+
+import "io"
+
+// stackSupport is the code used by the wrappers when Stack is set: the error they panic with carries the stack of
+// the failed call.
+const stackSupport = `// mustStackError is the error the wrappers panic with, it carries the stack of the failed call.
+type mustStackError struct {
+	err   error
+	stack []uintptr
+}
+
+func (e *mustStackError) Error() string { return e.err.Error() }
+
+func (e *mustStackError) Unwrap() error { return e.err }
+
+// StackTrace returns the program counters of the failed call, starting from the wrapper. See runtime.CallersFrames.
+func (e *mustStackError) StackTrace() []uintptr { return e.stack }
+
+func mustStack(err error) error {
+	pc := make([]uintptr, 64)
+	return &mustStackError{err: err, stack: pc[:runtime.Callers(2, pc)]}
+}
+
+`
+
+// mustErrorSupport is the error type the wrappers panic with when MustError is set.
+const mustErrorSupport = `// MustError is the error the wrappers panic with.
+type MustError struct {
+	// Func is the wrapper that failed, Err the error of the wrapped function
+	Func string
+	Err  error
+}
+
+func (e *MustError) Error() string { return e.Func + ": " + e.Err.Error() }
+
+func (e *MustError) Unwrap() error { return e.Err }
+
+`
+
+// metricsSupport is the hook called by the wrappers when Metrics is set.
+const metricsSupport = `// OnMustFailure is called by the wrappers before panicking, with the name of the failed wrapper and the error.
+// Set it to count the failures, eg: to increment a metric.
+var OnMustFailure func(wrapper string, err error)
+
+`
+
+// tracingSupport is the hook called by the wrappers with a context.Context parameter when Tracing is set.
+const tracingSupport = `// RecordMustError is called by the wrappers with a context.Context parameter before panicking, with the context
+// and the error. Set it to record the error on the span of the context, eg: with OpenTelemetry
+//
+//	RecordMustError = func(ctx context.Context, err error) { trace.SpanFromContext(ctx).RecordError(err) }
+var RecordMustError func(ctx context.Context, err error)
+
+`
+
+// generateSupport writes the declarations used by the wrappers of plan, after the imports.
+func (g *Generator) generateSupport(plan *Plan) {
+	if !plan.declaresSupport() {
+		return
+	}
+	if g.MustError {
+		io.WriteString(g, mustErrorSupport)
+	}
+	if g.Stack {
+		io.WriteString(g, stackSupport)
+	}
+	if g.Metrics {
+		io.WriteString(g, metricsSupport)
+	}
+	if g.Tracing {
+		io.WriteString(g, tracingSupport)
+	}
+}
@@ -0,0 +1,169 @@
+package mustgen
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"maps"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid target, expected a pattern like Get* or Queries.Get*, and key=value options")
+	ErrFuncNotFound  = errors.New("function not found")
+)
+
+// Target selects functions to wrap without a directive, eg: in the code generated by sqlc or protoc, which can't
+// be annotated.
+type Target struct {
+	// Pattern matches the name of the functions, or Recv.Name for the methods, with the syntax of path.Match. A
+	// pattern matches only the functions returning an error, a plain name matches its function whatever its results
+	Pattern string
+	// Name is the name of the wrapper, like the name of a directive. Only a plain name can set it
+	Name string
+	// Options are the key=value options of the directive the functions are wrapped with
+	Options map[string]string
+}
+
+// ParseTarget parses a target written as its pattern followed by the name of the wrapper, for a plain name, and its
+// options, eg: "Queries.Get* variant=once" or "ParseConfig MustConfig".
+func ParseTarget(s string) (Target, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || strings.Contains(fields[0], "=") {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, s)
+	}
+	if _, err := path.Match(fields[0], ""); err != nil {
+		return Target{}, fmt.Errorf("%w: %q: %v", ErrInvalidTarget, s, err)
+	}
+	t := Target{Pattern: fields[0]}
+	for _, field := range fields[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			if t.Name != "" || len(t.Options) > 0 || !ValidFuncName(t.Pattern) || !token.IsIdentifier(field) {
+				return Target{}, fmt.Errorf("%w: %q: only a plain name can name its wrapper, before the options", ErrInvalidTarget, s)
+			}
+			t.Name = field
+			continue
+		}
+		if t.Options == nil {
+			t.Options = make(map[string]string)
+		}
+		t.Options[k] = v
+	}
+	return t, nil
+}
+
+// ReadTargets reads the targets of r, one per line. The blank lines and the lines starting with # are skipped.
+func ReadTargets(r io.Reader) ([]Target, error) {
+	var targets []Target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		t, err := ParseTarget(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
+
+func (t Target) String() string {
+	var b strings.Builder
+	b.WriteString(t.Pattern)
+	if t.Name != "" {
+		b.WriteString(" " + t.Name)
+	}
+	keys := make([]string, 0, len(t.Options))
+	for key := range t.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%s", key, t.Options[key])
+	}
+	return b.String()
+}
+
+// targetDirective returns the directive of fn from the first of targets matching it.
+func targetDirective(targets []Target, fn *ast.FuncDecl) (directive, bool) {
+	if len(targets) == 0 {
+		return directive{}, false
+	}
+	t, ok := matchTarget(targets, funcName(fn))
+	if ok && t.Pattern != funcName(fn) && !resultsError(fn) {
+		return directive{}, false
+	}
+	return directive{name: t.Name, options: t.Options}, ok
+}
+
+// resultsError reports whether fn returns an error or an iter.Seq2[T, error], from its syntax.
+func resultsError(fn *ast.FuncDecl) bool {
+	return fn.Type.Results != nil && (returnsError(fn) || iterSeq2Elem(fn.Type.Results) != nil)
+}
+
+// funcName returns the name of fn matched by the targets and Options.Funcs: Recv.Name for a method.
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv != nil {
+		return recvName(fn.Recv) + "." + fn.Name.Name
+	}
+	return fn.Name.Name
+}
+
+// ValidFuncName reports whether name is a name of Options.Funcs: a function name, or Type.Method.
+func ValidFuncName(name string) bool {
+	recv, method, ok := strings.Cut(name, ".")
+	if !ok {
+		return token.IsIdentifier(name)
+	}
+	return token.IsIdentifier(recv) && token.IsIdentifier(method)
+}
+
+// funcTargets returns the targets of Options.Funcs, and the map of their names telling whether they are found.
+func (g *Gen) funcTargets() ([]Target, map[string]bool) {
+	if len(g.opts.Funcs) == 0 {
+		return g.opts.Targets, nil
+	}
+	targets := make([]Target, 0, len(g.opts.Funcs))
+	found := make(map[string]bool, len(g.opts.Funcs))
+	for _, name := range g.opts.Funcs {
+		targets = append(targets, Target{Pattern: name})
+		found[name] = false
+	}
+	return targets, found
+}
+
+// notFound returns an ErrFuncNotFound error listing the names of found that aren't, nil if they all are.
+func notFound(found map[string]bool) error {
+	var missing []string
+	for name, ok := range found {
+		if !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("%w: %s", ErrFuncNotFound, strings.Join(missing, ", "))
+}
+
+// matchTarget returns the first of targets matching name, with a copy of its options: the options of a wrapper may
+// be changed, eg: by fitLang.
+func matchTarget(targets []Target, name string) (Target, bool) {
+	for _, t := range targets {
+		if ok, _ := path.Match(t.Pattern, name); ok {
+			t.Options = maps.Clone(t.Options)
+			return t, true
+		}
+	}
+	return Target{}, false
+}
@@ -0,0 +1,293 @@
+package mustgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WrapperView is the data the wrapper template is executed with: the FuncSpec and its pieces of code.
+type WrapperView struct {
+	*FuncSpec
+	// RecvDecl is the receiver of the wrapper, eg: "(t *T)"
+	RecvDecl string
+	// TypeParamsDecl are the type parameters of the wrapper, eg: "[T any]"
+	TypeParamsDecl string
+	// ParamsDecl are the parameters of the wrapper, eg: "a int,b string"
+	ParamsDecl string
+	// Call is the call of the wrapped function, eg: "t.fn[T](a,b)"
+	Call string
+	// ResultTypes and ResultVars are the types and the variables of the results, except the error. When Iter is set
+	// ResultTypes is the iter.Seq returned by the wrapper and ResultVars the variable of the yielded value
+	ResultTypes []string
+	ResultVars  []string
+	// SeqVar is the variable of the iter.Seq2 returned by the wrapped function, when Iter is set
+	SeqVar string
+	// OnceVar is the package variable holding the sync.OnceValues of the wrapped function, with VariantOnce.
+	// Call is then the call of OnceVar
+	OnceVar string
+	// ErrVar is the variable of the error, Panic the value the wrapper panics with: ErrVar, or an error describing
+	// the call when PanicArgs is set
+	ErrVar string
+	Panic  string
+	// OnError are the statements run when the wrapped function fails: the hooks and the panic
+	OnError string
+	// LineDirective is the //line directive pointing to the wrapped function, when enabled
+	LineDirective string
+}
+
+var templateFuncs = template.FuncMap{"join": strings.Join}
+
+func newWrapperView(w *FuncSpec) (*WrapperView, error) {
+	if len(w.Results) == 0 {
+		return nil, ErrNoReturnValues
+	}
+	// the local variables must not collide with the receiver, the type parameters or the parameters
+	used := make(map[string]bool, len(w.Params)+len(w.TypeParams)+1)
+	if w.Recv != nil {
+		used[w.Recv.Name] = true
+	}
+	for _, f := range append(append([]Field{}, w.TypeParams...), w.Params...) {
+		used[f.Name] = true
+	}
+	v := &WrapperView{FuncSpec: w, ErrVar: "err"}
+	for i := 1; used[v.ErrVar]; i++ {
+		v.ErrVar = fmt.Sprintf("err%d", i)
+	}
+	used[v.ErrVar] = true
+	v.Panic = v.ErrVar
+	v.OnError = "panic(" + v.Panic + ")"
+	if w.isMethod() {
+		v.RecvDecl = fmt.Sprintf("(%s %s)", w.Recv.Name, w.Recv.Type)
+	}
+	if typeParamsDecl, _ := joinFields(w.TypeParams); typeParamsDecl != "" {
+		v.TypeParamsDecl = "[" + typeParamsDecl + "]"
+	}
+	v.ParamsDecl, _ = joinFields(w.wrapperParams())
+	v.Call = nodeString(wrappedCall(w))
+	v.ResultTypes = w.Results[:len(w.Results)-1]
+	if w.variant() == VariantOnce {
+		r, size := utf8.DecodeRuneInString(w.NewName)
+		v.OnceVar = string(unicode.ToLower(r)) + w.NewName[size:] + "Once"
+	}
+	if w.Iter {
+		v.ResultTypes = []string{"iter.Seq[" + w.Results[0] + "]"}
+		v.SeqVar = "seq"
+		for i := 1; used[v.SeqVar]; i++ {
+			v.SeqVar = fmt.Sprintf("seq%d", i)
+		}
+	}
+	// the names of the results must not shadow the names used by the types, nor the ones of the generated code
+	for _, name := range reservedNames {
+		used[name] = true
+	}
+	for _, typ := range append(append(append([]string{w.Pkg}, w.Results...), fieldTypes(w.Params)...), fieldTypes(w.TypeParams)...) {
+		for _, name := range typeIdents(typ) {
+			used[name] = true
+		}
+	}
+	if w.Recv != nil {
+		for _, name := range typeIdents(w.Recv.Type) {
+			used[name] = true
+		}
+	}
+	results := w.Results[:len(w.Results)-1]
+	if w.Iter {
+		results = w.Results[:1]
+	}
+	v.ResultVars = make([]string, 0, len(results))
+	for i, typ := range results {
+		base := resultName(typ)
+		if !w.Iter && i < len(w.ResultNames) && w.ResultNames[i] != "" && w.ResultNames[i] != "_" {
+			base = w.ResultNames[i]
+		}
+		name := base
+		for n := 1; used[name] || token.IsKeyword(name) || types.Universe.Lookup(name) != nil; n++ {
+			name = fmt.Sprintf("%s%d", base, n)
+		}
+		used[name] = true
+		v.ResultVars = append(v.ResultVars, name)
+	}
+	return v, nil
+}
+
+// reservedNames are the names used by the generated code.
+var reservedNames = []string{"fmt", "runtime", "context", "sync", "iter", "yield", "mustStack"}
+
+// renameReserved returns w with the receiver and the parameters named like reservedNames renamed in the wrapper,
+// eg: fmt becomes fmt1, so they don't shadow the names used by the generated code. w is returned as is when none is.
+func renameReserved(w *FuncSpec) *FuncSpec {
+	reserved := func(f Field) bool { return slices.Contains(reservedNames, f.Name) }
+	if !slices.ContainsFunc(w.Params, reserved) && (w.Recv == nil || !reserved(*w.Recv)) {
+		return w
+	}
+	used := make(map[string]bool, len(w.Params)+len(w.TypeParams)+len(reservedNames)+1)
+	for _, name := range reservedNames {
+		used[name] = true
+	}
+	fields := append(append([]Field{}, w.TypeParams...), w.Params...)
+	if w.Recv != nil {
+		fields = append(fields, *w.Recv)
+	}
+	for _, f := range fields {
+		used[f.Name] = true
+		for _, name := range typeIdents(f.Type) {
+			used[name] = true
+		}
+	}
+	renamed := make(map[string]string)
+	rename := func(f Field) Field {
+		if !reserved(f) {
+			return f
+		}
+		name := f.Name
+		for n := 1; used[name]; n++ {
+			name = fmt.Sprintf("%s%d", f.Name, n)
+		}
+		used[name] = true
+		renamed[f.Name] = name
+		f.Name = name
+		return f
+	}
+	spec := *w
+	spec.Params = make([]Field, 0, len(w.Params))
+	for _, f := range w.Params {
+		spec.Params = append(spec.Params, rename(f))
+	}
+	if w.Recv != nil {
+		recv := rename(*w.Recv)
+		spec.Recv = &recv
+	}
+	if redacted := w.redacted(); len(redacted) > 0 {
+		spec.Options = maps.Clone(w.Options)
+		for i, name := range redacted {
+			if r, ok := renamed[name]; ok {
+				redacted[i] = r
+			}
+		}
+		spec.Options["redact"] = strings.Join(redacted, ",")
+	}
+	return &spec
+}
+
+// basicResultNames are the names of the results of the predeclared types.
+var basicResultNames = map[string]string{
+	"bool": "ok", "string": "s", "byte": "b", "rune": "r", "any": "v", "error": "e",
+	"int": "n", "int8": "n", "int16": "n", "int32": "n", "int64": "n",
+	"uint": "n", "uint8": "n", "uint16": "n", "uint32": "n", "uint64": "n", "uintptr": "n",
+	"float32": "f", "float64": "f", "complex64": "c", "complex128": "c",
+}
+
+// resultName returns the name of the variable of a result of type typ, eg: config for *pkg.Config, items for []Item,
+// buf for []byte, n for int.
+func resultName(typ string) string {
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return "v"
+	}
+	return exprName(expr)
+}
+
+func exprName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if name, ok := basicResultNames[e.Name]; ok {
+			return name
+		}
+		return lowerInitial(e.Name)
+	case *ast.SelectorExpr:
+		return exprName(e.Sel)
+	case *ast.StarExpr:
+		return exprName(e.X)
+	case *ast.IndexExpr:
+		return exprName(e.X)
+	case *ast.IndexListExpr:
+		return exprName(e.X)
+	case *ast.ParenExpr:
+		return exprName(e.X)
+	case *ast.ArrayType:
+		if ident, ok := e.Elt.(*ast.Ident); ok && (ident.Name == "byte" || ident.Name == "uint8") {
+			return "buf"
+		}
+		if name := exprName(e.Elt); len(name) > 1 {
+			return plural(name)
+		}
+		return "values"
+	case *ast.MapType:
+		return "m"
+	case *ast.ChanType:
+		return "ch"
+	case *ast.FuncType:
+		return "fn"
+	default:
+		return "v"
+	}
+}
+
+// lowerInitial returns name with its leading initialism or first letter in lower case, eg: HTTPClient -> httpClient.
+func lowerInitial(name string) string {
+	runes := []rune(name)
+	n := 0
+	for n < len(runes) && unicode.IsUpper(runes[n]) {
+		n++
+	}
+	if n > 1 && n < len(runes) && unicode.IsLower(runes[n]) {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+func plural(name string) string {
+	switch {
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "sh"), strings.HasSuffix(name, "ch"):
+		return name + "es"
+	default:
+		return name + "s"
+	}
+}
+
+// typeIdents returns the identifiers of the type typ, eg: pkg and T for map[string]pkg.T.
+func typeIdents(typ string) []string {
+	expr, err := parser.ParseExpr(strings.TrimPrefix(typ, "..."))
+	if err != nil {
+		return nil
+	}
+	var idents []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			idents = append(idents, ident.Name)
+		}
+		return true
+	})
+	return idents
+}
+
+func fieldTypes(fields []Field) []string {
+	list := make([]string, 0, len(fields))
+	for _, f := range fields {
+		list = append(list, f.Type)
+	}
+	return list
+}
+
+func (g *Generator) executeTemplate(v *WrapperView) error {
+	if g.tmpl == nil {
+		tmpl, err := template.New("wrapper").Funcs(templateFuncs).Parse(g.Template)
+		if err != nil {
+			return err
+		}
+		g.tmpl = tmpl
+	}
+	return g.tmpl.Execute(g, v)
+}
@@ -0,0 +1,16 @@
+package aliaspkg
+
+import (
+	"net/url"
+	tm "time"
+)
+
+func Parse(raw string) (*url.URL, error) {
+	//@gen_must
+	return url.Parse(raw)
+}
+
+func Wait(d tm.Duration) (tm.Time, error) {
+	//@gen_must
+	return tm.Now().Add(d), nil
+}
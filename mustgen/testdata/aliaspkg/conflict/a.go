@@ -0,0 +1,8 @@
+package conflict
+
+import tm "time"
+
+func Wait(d tm.Duration) (tm.Time, error) {
+	//@gen_must
+	return tm.Now().Add(d), nil
+}
@@ -0,0 +1,8 @@
+package conflict
+
+import tm "text/template"
+
+func Parse(text string) (*tm.Template, error) {
+	//@gen_must
+	return tm.New("t").Parse(text)
+}
@@ -0,0 +1,14 @@
+package cgopkg
+
+// #include <stdlib.h>
+import "C"
+
+func random() (int, error) {
+	//@gen_must
+	return int(C.rand()), nil
+}
+
+func size(n C.size_t) (int, error) {
+	//@gen_must
+	return int(n), nil
+}
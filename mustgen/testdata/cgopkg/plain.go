@@ -0,0 +1,6 @@
+package cgopkg
+
+func plain(s string) (string, error) {
+	//@gen_must
+	return s, nil
+}
@@ -0,0 +1,6 @@
+package cleanpkg
+
+func doThing() (int, error) {
+	//@gen_must
+	return 0, nil
+}
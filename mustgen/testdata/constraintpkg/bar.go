@@ -0,0 +1,8 @@
+//go:build bar && !baz
+
+package constraintpkg
+
+func Bar(n int) (int, error) {
+	//@gen_must
+	return n, nil
+}
@@ -0,0 +1,8 @@
+//go:build foo
+
+package constraintpkg
+
+func Foo() (string, error) {
+	//@gen_must
+	return "foo", nil
+}
@@ -0,0 +1,6 @@
+package constraintpkg
+
+func Open(name string) (int, error) {
+	//@gen_must
+	return len(name), nil
+}
@@ -0,0 +1,44 @@
+package coveragepkg
+
+import "errors"
+
+type Client struct{}
+
+type conn struct{}
+
+func Open(name string) (*Client, error) {
+	//@gen_must
+	return &Client{}, nil
+}
+
+func Dial(addr string) (*conn, error) {
+	return &conn{}, nil
+}
+
+func MustDial(addr string) *conn {
+	c, err := Dial(addr)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *Client) Get(key string) (string, error) {
+	return "", errors.New("not found")
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *conn) Read() ([]byte, error) {
+	return nil, nil
+}
+
+func Name() string {
+	return "coverage"
+}
+
+func parse(s string) (int, error) {
+	return 0, nil
+}
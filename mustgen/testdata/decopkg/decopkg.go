@@ -0,0 +1,30 @@
+package decopkg
+
+type Client struct {
+	//@gen_must
+	addr string
+}
+
+func (c *Client) Get(path string) (string, error) {
+	return "", nil
+}
+
+func (c Client) Close() error {
+	return nil
+}
+
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+func (c *Client) Set(m map[string]int, n int) error {
+	return nil
+}
+
+func (c *Client) Put(m int) error {
+	return nil
+}
+
+func (c *Client) Merge(s struct{ n int }) error {
+	return nil
+}
@@ -0,0 +1,10 @@
+package decopkg
+
+type Hooks struct {
+	//@gen_must
+	OnStart    func(name string) error
+	OnStop     func() error
+	Load, Save func(string, int) (int, error)
+	Notify     func()
+	Name       string
+}
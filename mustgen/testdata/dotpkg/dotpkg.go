@@ -0,0 +1,15 @@
+package dotpkg
+
+import . "time"
+
+type Clock struct{ d Duration }
+
+func Wait(d Duration) (Time, error) {
+	//@gen_must
+	return Now().Add(d), nil
+}
+
+func (t Clock) Start(at Time) (*Clock, error) {
+	//@gen_must
+	return &t, nil
+}
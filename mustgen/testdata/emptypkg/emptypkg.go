@@ -0,0 +1,5 @@
+package emptypkg
+
+func Open(name string) (int, error) {
+	return len(name), nil
+}
@@ -0,0 +1,6 @@
+package errpkg
+
+func noError() int {
+	//@gen_must
+	return 0
+}
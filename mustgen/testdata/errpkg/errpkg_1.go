@@ -0,0 +1,6 @@
+package errpkg
+
+func structParam(s struct{ n int }) (int, error) {
+	//@gen_must
+	return s.n, nil
+}
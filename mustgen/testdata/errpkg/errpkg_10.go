@@ -0,0 +1,6 @@
+package errpkg
+
+func signIn(user, password string) (string, error) {
+	//@gen_must redcat=password
+	return user, nil
+}
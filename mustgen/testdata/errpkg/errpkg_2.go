@@ -0,0 +1,5 @@
+package errpkg
+
+func noResults() {
+	//@gen_must
+}
@@ -0,0 +1,14 @@
+package errpkg
+
+func drifted(s string, base int) (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+func mustDrifted(s string) int {
+	n, err := drifted(s, 10)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,10 @@
+package errpkg
+
+type valueError struct{}
+
+func (valueError) Error() string { return "value error" }
+
+func valueErr() (int, valueError) {
+	//@gen_must
+	return 0, valueError{}
+}
@@ -0,0 +1,6 @@
+package errpkg
+
+func login(user string, password string) error {
+	//@gen_must redact=pasword
+	return nil
+}
@@ -0,0 +1,6 @@
+package errpkg
+
+func open(name string) (int, error) {
+	//@gen_must variant=once
+	return 0, nil
+}
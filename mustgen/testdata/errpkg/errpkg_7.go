@@ -0,0 +1,6 @@
+package errpkg
+
+func join(a string, b string) (string, error) {
+	//@gen_must all=true
+	return a + b, nil
+}
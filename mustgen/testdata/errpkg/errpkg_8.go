@@ -0,0 +1,6 @@
+package errpkg
+
+func parse(s string) (int, error) {
+	//@gen_must
+	return len(s), nil
+}
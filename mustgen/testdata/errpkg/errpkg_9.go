@@ -0,0 +1,8 @@
+package errpkg
+
+type service struct{}
+
+func (s *service) stop() error {
+	//@gen_must recv=*service
+	return nil
+}
@@ -0,0 +1,10 @@
+package extpkg
+
+type Config struct{}
+
+type Source[T any] interface{ Get() T }
+
+func Load[T ~string](name T, src Source[T]) (*Config, error) {
+	//@gen_must
+	return &Config{}, nil
+}
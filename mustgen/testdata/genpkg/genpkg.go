@@ -0,0 +1,6 @@
+package genpkg
+
+func Open(name string) (bool, error) {
+	//@gen_must
+	return true, nil
+}
@@ -0,0 +1,20 @@
+package handpkg
+
+func Parse(s string) (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+// MustParse is written by hand, it isn't generated again
+func MustParse(s string) int {
+	n, err := Parse(s)
+	if err != nil {
+		panic("parse: " + err.Error())
+	}
+	return n
+}
+
+func Open(name string) (bool, error) {
+	//@gen_must
+	return true, nil
+}
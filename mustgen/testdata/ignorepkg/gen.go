@@ -0,0 +1,8 @@
+//go:build ignore
+
+package ignorepkg
+
+func Generate(name string) (int, error) {
+	//@gen_must
+	return len(name), nil
+}
@@ -0,0 +1,15 @@
+package iterpkg
+
+import "iter"
+
+func records(path string) iter.Seq2[string, error] {
+	//@gen_must
+	return nil
+}
+
+func (t *TypeA) pairs(seq int) iter.Seq2[*TypeA, error] {
+	//@gen_must
+	return nil
+}
+
+type TypeA struct{}
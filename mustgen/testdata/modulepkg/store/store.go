@@ -0,0 +1,8 @@
+package store
+
+type Store struct{}
+
+func Open(name string) (*Store, error) {
+	//@gen_must
+	return &Store{}, nil
+}
@@ -0,0 +1,5 @@
+package util
+
+func Parse(s string) (int, error) {
+	return 0, nil
+}
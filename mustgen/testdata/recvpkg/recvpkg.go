@@ -0,0 +1,32 @@
+package recvpkg
+
+type Client struct{ addr string }
+
+func NewClient(addr string) (*Client, error) {
+	//@gen_must
+	return &Client{addr: addr}, nil
+}
+
+func (c *Client) Get(path string) (string, error) {
+	//@gen_must
+	return c.addr + path, nil
+}
+
+func (c *Client) Close() error {
+	//@gen_must
+	return nil
+}
+
+type Server struct {
+	//@gen_must
+	port int
+}
+
+func (s *Server) Listen() (int, error) {
+	return s.port, nil
+}
+
+func (s Server) Port() (int, error) {
+	//@gen_must
+	return s.port, nil
+}
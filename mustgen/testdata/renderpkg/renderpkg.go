@@ -0,0 +1,11 @@
+package renderpkg
+
+import (
+	"io"
+	str "strings"
+)
+
+func Copy(m map[string][]int, read func(io.Reader) (int, error), done <-chan struct{}, b *str.Builder, opts ...func()) ([]io.Writer, error) {
+	//@gen_must
+	return nil, nil
+}
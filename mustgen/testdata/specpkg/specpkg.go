@@ -0,0 +1,6 @@
+package specpkg
+
+func Parse(s string) (int, error) {
+	//@gen_must: MustParseInt redact=s
+	return 0, nil
+}
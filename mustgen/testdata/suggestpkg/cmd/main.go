@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	config "github.com/heliorosa/gen_must/mustgen/testdata/suggestpkg/conf"
+)
+
+func main() {
+	m, err := config.Load("a")
+	if err != nil {
+		panic(err)
+	}
+	if err := config.Check("b"); err != nil {
+		log.Fatalf("check: %v", err)
+	}
+	n, err := config.Parse(m["n"])
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch n {
+	case 1:
+		if _, err = config.Parse("c"); err != nil {
+			panic(err)
+		}
+	default:
+		k, err := config.Parse("d")
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println(k)
+	}
+	v, err := config.Tagged("e")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(v)
+}
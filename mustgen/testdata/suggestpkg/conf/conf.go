@@ -0,0 +1,19 @@
+package conf
+
+func Load(path string) (map[string]string, error) { return nil, nil }
+
+func Parse(s string) (int, error) { return len(s), nil }
+
+func Tagged(s string) (int, error) {
+	//@gen_must
+	return len(s), nil
+}
+
+func lookup(key string) (string, error) { return key, nil }
+
+func Check(s string) error {
+	if _, err := lookup(s); err != nil {
+		panic(err)
+	}
+	return nil
+}
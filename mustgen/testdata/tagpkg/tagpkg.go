@@ -0,0 +1,8 @@
+//go:build integration
+
+package tagpkg
+
+func DoThing() (int, error) {
+	//@gen_must
+	return 0, nil
+}
@@ -0,0 +1,10 @@
+package testpkg
+
+type ParseError struct{ Line int }
+
+func (e *ParseError) Error() string { return "parse error" }
+
+func parseLine(s string) (int, *ParseError) {
+	//@gen_must
+	return 0, nil
+}
@@ -0,0 +1,6 @@
+package testpkg
+
+func retry(err error, var0 int) (int, string, error) {
+	//@gen_must
+	return var0, "", err
+}
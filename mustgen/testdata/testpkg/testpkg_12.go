@@ -0,0 +1,23 @@
+package testpkg
+
+func lookup(name string, ids ...int) (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+func (t *TypeA) rename(name string) error {
+	//@gen_must
+	return nil
+}
+
+type Token string
+
+func login(user string, password string, tok Token) error {
+	//@gen_must redact=password
+	return nil
+}
+
+func render(fmt string, width int) (string, error) {
+	//@gen_must
+	return fmt, nil
+}
@@ -0,0 +1,11 @@
+package testpkg
+
+func newTypeA() (*TypeA, error) {
+	//@gen_must variant=once
+	return &TypeA{}, nil
+}
+
+func setup() error {
+	//@gen_must: mustSetupOnce variant=once
+	return nil
+}
@@ -0,0 +1,21 @@
+package testpkg
+
+func parse(s string) (*TypeA, error) {
+	//@gen_must all=true
+	return &TypeA{}, nil
+}
+
+func parseRes(res string) (int, error) {
+	//@gen_must: mustParseRes all=mustParseEach
+	return len(res), nil
+}
+
+func (t *TypeA) lookup(key string) (string, error) {
+	//@gen_must all=true
+	return key, nil
+}
+
+func wrap[T any](v T) (TypeB[T], error) {
+	//@gen_must all=true
+	return TypeB[T]{T: v}, nil
+}
@@ -0,0 +1,11 @@
+package testpkg
+
+func (_ *TypeA) apply(t int) (int, error) {
+	//@gen_must
+	return t, nil
+}
+
+func (*TypeA) reset() (bool, error) {
+	//@gen_must
+	return true, nil
+}
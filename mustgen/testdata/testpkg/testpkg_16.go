@@ -0,0 +1,21 @@
+package testpkg
+
+func load(name string) (cfg *TypeA, n int, err error) {
+	//@gen_must
+	return &TypeA{}, len(name), nil
+}
+
+func split(s string) (head, tail string, err error) {
+	//@gen_must
+	return s, s, nil
+}
+
+func (t *TypeA) find(typeA int) (*TypeA, error) {
+	//@gen_must
+	return t, nil
+}
+
+func join(a, b string, _ int) (string, error) {
+	//@gen_must
+	return a + b, nil
+}
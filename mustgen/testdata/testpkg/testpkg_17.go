@@ -0,0 +1,11 @@
+package testpkg
+
+func fetch(t string) (int, error) {
+	//@gen_must: mustFetch recv=*TypeA
+	return len(t), nil
+}
+
+func count() (int, error) {
+	//@gen_must recv=TypeA
+	return 0, nil
+}
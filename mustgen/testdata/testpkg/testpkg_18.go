@@ -0,0 +1,21 @@
+package testpkg
+
+func (t *TypeA) find(key string) (string, error) {
+	//@gen_must all=true
+	return key, nil
+}
+
+func (TypeA) Close() error {
+	//@gen_must
+	return nil
+}
+
+func (t *TypeA) get(id int) (string, error) {
+	//@gen_must: mustGetByID
+	return "", nil
+}
+
+func (t *TypeB[T]) value() (T, error) {
+	//@gen_must
+	return t.T, nil
+}
@@ -0,0 +1,21 @@
+package testpkg
+
+func (t TypeA) second() (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+func zed() (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+func (t *TypeA) first() (int, error) {
+	//@gen_must
+	return 0, nil
+}
+
+func alpha() (int, error) {
+	//@gen_must
+	return 0, nil
+}
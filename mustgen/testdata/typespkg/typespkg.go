@@ -0,0 +1,29 @@
+package typespkg
+
+type Config struct{}
+
+type Source[T any] interface{ Get() T }
+
+func Load[T ~string](name T, src Source[T]) (*Config, error) {
+	return &Config{}, nil
+}
+
+func Read(src Source[[]byte], opts ...string) (*Config, int, error) {
+	return &Config{}, 0, nil
+}
+
+func Close(*Config) error {
+	return nil
+}
+
+func (c *Config) Validate() (bool, error) {
+	return true, nil
+}
+
+func Count() int {
+	return 0
+}
+
+func parse(s string) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,28 @@
+package vetpkg
+
+//@gen_must
+
+type Client struct { //@gen_must 1Client
+	Get func(key string) (string, error)
+}
+
+func open(name string) (int, error) {
+	//@gen_must mustOpen varaint=once
+	return 0, nil
+}
+
+func close(fd int) error {
+	//@gen_must must-close
+	return nil
+}
+
+func size(name string) int {
+	//@gen_must
+	return 0
+}
+
+func parse(s string) (int, error) {
+	n := len(s)
+	//@gen_must
+	return n, nil
+}
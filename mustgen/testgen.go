@@ -0,0 +1,226 @@
+package mustgen
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EmitTests writes a test file for the wrappers of plan: for each of them a table of test cases, with a case using
+// zero values to be completed by hand, checks that the wrapper returns the values of the wrapped function when it
+// doesn't fail, and panics with its error when it does. Both functions are called with the same arguments.
+// Generic wrappers and iterators aren't tested. The file is meant to be edited, so it doesn't carry the generated
+// code marker.
+func (g *Generator) EmitTests(plan *Plan) error {
+	fmt.Fprintf(g, "package %s\n\n", plan.Package)
+	var imports []Import
+	tested, results := false, false
+	for _, w := range plan.Funcs {
+		if untested(w) == "" {
+			tested = true
+			results = results || len(w.Results) > 1
+		}
+	}
+	if tested {
+		imports = append(imports, Import{Path: "errors"})
+		if results {
+			imports = append(imports, Import{Path: "reflect"})
+		}
+		imports = append(imports, Import{Path: "testing"})
+		imports = append(imports, plan.Imports...)
+	}
+	g.GenerateImports(imports)
+	for _, w := range plan.Funcs {
+		if reason := untested(w); reason != "" {
+			fmt.Fprintf(g, "// %s %s, it isn't tested\n\n", w.NewName, reason)
+			continue
+		}
+		g.generateTest(w)
+	}
+	return nil
+}
+
+// untested returns why the wrapper w can't be tested by the skeletons, empty if it can.
+func untested(w *FuncSpec) string {
+	switch {
+	case len(w.TypeParams) > 0 || w.Recv != nil && strings.Contains(w.Recv.Type, "["):
+		return "is generic"
+	case w.Iter:
+		return "returns an iterator"
+	default:
+		return ""
+	}
+}
+
+func (g *Generator) generateTest(w *FuncSpec) {
+	var prefix, wrapperPrefix string
+	fields := []string{"name string"}
+	zero := []string{`name: "zero values"`}
+	if w.Recv != nil {
+		fields = append(fields, "recv "+w.Recv.Type)
+		if strings.HasPrefix(w.Recv.Type, "*") {
+			zero = append(zero, fmt.Sprintf("recv: new(%s)", w.Recv.Type[1:]))
+		}
+	}
+	if w.callRecv() {
+		prefix = "tt.recv."
+	} else if w.Pkg != "" {
+		prefix = w.Pkg + "."
+	}
+	if w.isMethod() {
+		wrapperPrefix = "tt.recv."
+	}
+	args := make([]string, 0, len(w.Params))
+	for _, p := range w.Params {
+		name := p.Name
+		if name == "name" || name == "recv" {
+			name += "_"
+		}
+		typ := p.Type
+		arg := "tt." + name
+		if strings.HasPrefix(typ, "...") {
+			typ = "[]" + typ[3:]
+			arg += "..."
+		}
+		fields = append(fields, name+" "+typ)
+		args = append(args, arg)
+	}
+	n := len(w.Results) - 1
+	wants := make([]string, 0, n+1)
+	gots := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		wants = append(wants, fmt.Sprintf("want%d", i))
+		gots = append(gots, fmt.Sprintf("got%d", i))
+	}
+	wants = append(wants, "wantErr")
+	wrapperArgs := args
+	if w.RecvParam {
+		wrapperArgs = append([]string{"tt.recv"}, args...)
+	}
+	wrapperCall := fmt.Sprintf("%s%s(%s)", wrapperPrefix, w.NewName, strings.Join(wrapperArgs, ", "))
+	fmt.Fprintf(g, "func %s(t *testing.T) {\n", testName(w))
+	fmt.Fprintf(g, "tests := []struct {\n%s\n}{\n{%s},\n}\n", strings.Join(fields, "\n"), strings.Join(zero, ", "))
+	fmt.Fprintf(g, "for _, tt := range tests {\nt.Run(tt.name, func(t *testing.T) {\n")
+	fmt.Fprintf(g, "%s := %s%s(%s)\n", strings.Join(wants, ", "), prefix, w.Name, strings.Join(args, ", "))
+	fmt.Fprintf(g, `defer func() {
+r := recover()
+switch {
+case wantErr == nil && r != nil:
+t.Fatalf("%[1]s panicked: %%v", r)
+case wantErr != nil && r != nil:
+if err, _ := r.(error); !errors.Is(err, wantErr) {
+t.Fatalf("%[1]s panicked with %%v, want %%v", r, wantErr)
+}
+}
+}()
+`, w.NewName)
+	if n > 0 {
+		fmt.Fprintf(g, "%s := %s\n", strings.Join(gots, ", "), wrapperCall)
+	} else {
+		fmt.Fprintf(g, "%s\n", wrapperCall)
+	}
+	fmt.Fprintf(g, "if wantErr != nil {\nt.Fatalf(\"%s didn't panic with %%v\", wantErr)\n}\n", w.NewName)
+	for i := range gots {
+		fmt.Fprintf(g, "if !reflect.DeepEqual(%s, %s) {\nt.Errorf(\"%s result %d = %%v, want %%v\", %s, %s)\n}\n",
+			gots[i], wants[i], w.NewName, i, gots[i], wants[i])
+	}
+	fmt.Fprintf(g, "})\n}\n}\n\n")
+}
+
+// testName returns the name of the test of the wrapper w, eg: TestMustParse or TestClient_MustGet.
+func testName(w *FuncSpec) string {
+	if w.isMethod() {
+		return "Test" + w.recvTypeName() + "_" + w.NewName
+	}
+	r, size := utf8.DecodeRuneInString(w.NewName)
+	return "Test" + string(unicode.ToUpper(r)) + w.NewName[size:]
+}
+
+// EmitBenchmarks writes a benchmark file comparing, for each wrapper of plan, the call of the wrapper to the direct
+// call of the wrapped function, with zero values as arguments to be edited by hand. Generic wrappers and iterators
+// are skipped. Like EmitTests, the file is meant to be edited.
+func (g *Generator) EmitBenchmarks(plan *Plan) error {
+	fmt.Fprintf(g, "package %s\n\n", plan.Package)
+	var imports []Import
+	for _, w := range plan.Funcs {
+		if untested(w) == "" {
+			imports = append([]Import{{Path: "testing"}}, plan.Imports...)
+			break
+		}
+	}
+	g.GenerateImports(imports)
+	for _, w := range plan.Funcs {
+		if reason := untested(w); reason != "" {
+			fmt.Fprintf(g, "// %s %s, it isn't benchmarked\n\n", w.NewName, reason)
+			continue
+		}
+		g.generateBenchmark(w)
+	}
+	return nil
+}
+
+func (g *Generator) generateBenchmark(w *FuncSpec) {
+	used := map[string]bool{"b": true, "i": true, "err": true}
+	local := func(name string) string {
+		for used[name] {
+			name += "_"
+		}
+		used[name] = true
+		return name
+	}
+	var prefix, wrapperPrefix, recv string
+	if w.Recv != nil {
+		recv = local("recv")
+	}
+	if w.callRecv() {
+		prefix = recv + "."
+	} else if w.Pkg != "" {
+		prefix = w.Pkg + "."
+	}
+	if w.isMethod() {
+		wrapperPrefix = recv + "."
+	} else if w.Pkg != "" {
+		wrapperPrefix = w.Pkg + "."
+	}
+	vars := make([]string, 0, len(w.Params))
+	args := make([]string, 0, len(w.Params))
+	for _, p := range w.Params {
+		name := local(p.Name)
+		typ := p.Type
+		arg := name
+		if strings.HasPrefix(typ, "...") {
+			typ = "[]" + typ[3:]
+			arg += "..."
+		}
+		vars = append(vars, name+" "+typ)
+		args = append(args, arg)
+	}
+	n := len(w.Results) - 1
+	direct := fmt.Sprintf("%s%s(%s)", prefix, w.Name, strings.Join(args, ", "))
+	wrapperArgs := args
+	if w.RecvParam {
+		wrapperArgs = append([]string{recv}, args...)
+	}
+	wrapper := fmt.Sprintf("%s%s(%s)", wrapperPrefix, w.NewName, strings.Join(wrapperArgs, ", "))
+	fmt.Fprintf(g, "func %s(b *testing.B) {\n", "Benchmark"+strings.TrimPrefix(testName(w), "Test"))
+	if len(vars) > 0 {
+		fmt.Fprintf(g, "var (\n%s\n)\n", strings.Join(vars, "\n"))
+	}
+	if w.Recv != nil {
+		if strings.HasPrefix(w.Recv.Type, "*") {
+			fmt.Fprintf(g, "%s := new(%s)\n", recv, w.Recv.Type[1:])
+		} else {
+			fmt.Fprintf(g, "var %s %s\n", recv, w.Recv.Type)
+		}
+	}
+	fmt.Fprintf(g, "if %serr := %s; err != nil {\nb.Skipf(\"%s fails, edit the arguments: %%v\", err)\n}\n",
+		strings.Repeat("_, ", n), direct, w.Name)
+	fmt.Fprintf(g, "b.Run(\"direct\", func(b *testing.B) {\nfor i := 0; i < b.N; i++ {\n%s = %s\n}\n})\n",
+		strings.TrimSuffix(strings.Repeat("_, ", n+1), ", "), direct)
+	wrapperStmt := wrapper
+	if n > 0 {
+		wrapperStmt = strings.TrimSuffix(strings.Repeat("_, ", n), ", ") + " = " + wrapper
+	}
+	fmt.Fprintf(g, "b.Run(\"wrapper\", func(b *testing.B) {\nfor i := 0; i < b.N; i++ {\n%s\n}\n})\n}\n\n", wrapperStmt)
+}
@@ -0,0 +1,190 @@
+package mustgen
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"path"
+	"slices"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// LoadTypes loads the type information of the package matching patterns, without parsing its source.
+// It's enough for PlanTypes, and it works for packages whose source isn't available, eg: dependencies.
+func LoadTypes(ctx context.Context, patterns []string, buildFlags ...string) (*packages.Package, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{
+			Context:    ctx,
+			Mode:       packages.NeedName | packages.NeedTypes | packages.NeedModule,
+			BuildFlags: buildFlags,
+		},
+		patterns...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 || pkgs[0].Types == nil {
+		return nil, ErrNoPackageFound
+	}
+	if len(pkgs[0].Errors) > 0 {
+		return nil, pkgs[0].Errors[0]
+	}
+	return pkgs[0], nil
+}
+
+// PlanTypes plans the wrappers of the exported functions of pkg whose last result is an error, using only the
+// type information of pkg, directives aren't needed. names restricts the wrapped functions, and so do Funcs, or
+// else Targets, giving their options to the wrappers: all of them are wrapped when they are empty. The OnFunction
+// hook is called with a nil *ast.FuncDecl.
+func (g *Gen) PlanTypes(pkg *packages.Package, names ...string) (*Plan, error) {
+	if pkg.Types == nil {
+		return nil, ErrNoPackageFound
+	}
+	plan := &Plan{Package: pkg.Name, Funcs: []*FuncSpec{}}
+	if g.opts.Package != "" {
+		plan.Package = g.opts.Package
+	}
+	q := &typesQualifier{self: plan.Package == pkg.Name, pkg: pkg.Types, imports: map[string]Import{}}
+	names = append(slices.Clip(names), g.opts.Funcs...)
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	targets, found := g.funcTargets()
+	scope := pkg.Types.Scope()
+	lang, autoLang := g.lang(pkg)
+	for _, name := range scope.Names() {
+		fn, ok := scope.Lookup(name).(*types.Func)
+		if !ok || !fn.Exported() || (len(names) > 0 && !wanted[name]) {
+			continue
+		}
+		var target Target
+		if len(targets) > 0 {
+			if target, ok = matchTarget(targets, name); !ok {
+				continue
+			}
+		}
+		if found != nil {
+			found[name] = true
+		}
+		w, ok := q.planFunc(fn, g.opts.Naming(name))
+		if !ok {
+			continue
+		}
+		w.Options = target.Options
+		if target.Name != "" {
+			w.NewName = target.Name
+		}
+		if err := w.check(pluginVariants(g.opts.Plugins)...); err != nil {
+			g.opts.Logger.Warn("function not wrapped", "func", w.Name, "err", err)
+			continue
+		}
+		skip, err := g.fitLang(w, lang, autoLang)
+		if err != nil {
+			g.opts.Logger.Warn("function not wrapped", "func", w.Name, "err", err)
+			continue
+		}
+		if skip {
+			continue
+		}
+		if pkg.Fset != nil {
+			w.Pos = pkg.Fset.Position(fn.Pos())
+		}
+		if g.opts.OnFunction != nil {
+			skip, err := g.opts.OnFunction(nil, w)
+			if err != nil {
+				return nil, err
+			}
+			if skip {
+				g.opts.Logger.Info("function skipped", "func", w.Name)
+				continue
+			}
+		}
+		g.opts.Logger.Debug("function wrapped", "func", w.Name, "wrapper", w.NewName)
+		plan.Funcs = append(plan.Funcs, w)
+	}
+	if err := notFound(found); err != nil {
+		return nil, err
+	}
+	if errs := checkNames(plan); len(errs) > 0 {
+		return nil, errs
+	}
+	if g.opts.Reexport && !q.self {
+		g.planReexports(plan, typesReexports(pkg.Types, q.qualifier(pkg.Types)))
+	}
+	if len(plan.Funcs) == 0 {
+		g.opts.Logger.Warn("no functions returning an error found", "package", pkg.PkgPath)
+	}
+	for _, imp := range q.imports {
+		plan.Imports = append(plan.Imports, imp)
+	}
+	sort.Slice(plan.Imports, func(i, j int) bool { return plan.Imports[i].Path < plan.Imports[j].Path })
+	plan.Sort()
+	return plan, nil
+}
+
+// typesQualifier renders types as seen from the output package, collecting the imports they need.
+type typesQualifier struct {
+	// self is true when the output is in pkg
+	self    bool
+	pkg     *types.Package
+	imports map[string]Import
+}
+
+func (q *typesQualifier) qualifier(pkg *types.Package) string {
+	if q.self && pkg == q.pkg {
+		return ""
+	}
+	imp := Import{Path: pkg.Path()}
+	if path.Base(pkg.Path()) != pkg.Name() {
+		imp.Name = pkg.Name()
+	}
+	q.imports[imp.Path] = imp
+	return pkg.Name()
+}
+
+func (q *typesQualifier) typeString(t types.Type) string { return types.TypeString(t, q.qualifier) }
+
+// planFunc returns the wrapper of fn, ok is false if fn doesn't return an error or an iter.Seq2[T, error].
+func (q *typesQualifier) planFunc(fn *types.Func, newName string) (w *FuncSpec, ok bool) {
+	sig := fn.Type().(*types.Signature)
+	res := sig.Results()
+	iter := iterSeq(res) != nil
+	if !iter && (res.Len() == 0 || !isErrorType(res.At(res.Len()-1).Type())) {
+		return nil, false
+	}
+	w = &FuncSpec{Name: fn.Name(), NewName: newName}
+	if !q.self {
+		w.Pkg = q.qualifier(q.pkg)
+	}
+	for i := 0; i < sig.TypeParams().Len(); i++ {
+		tp := sig.TypeParams().At(i)
+		w.TypeParams = append(w.TypeParams, Field{Name: tp.Obj().Name(), Type: q.typeString(tp.Constraint())})
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		param := sig.Params().At(i)
+		name := param.Name()
+		if name == "" || name == "_" {
+			name = fmt.Sprintf("p%d", i)
+		}
+		typ := q.typeString(param.Type())
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			typ = "..." + q.typeString(param.Type().(*types.Slice).Elem())
+		}
+		w.Params = append(w.Params, Field{Name: name, Type: typ})
+	}
+	if iter {
+		elem := res.At(0).Type().(*types.Named).TypeArgs().At(0)
+		w.Results, w.Iter = []string{q.typeString(elem), "error"}, true
+		return w, true
+	}
+	for i := 0; i < res.Len(); i++ {
+		w.Results = append(w.Results, q.typeString(res.At(i).Type()))
+		if name := res.At(i).Name(); name != "" {
+			w.ResultNames = append(w.ResultNames, name)
+		}
+	}
+	return w, true
+}
@@ -0,0 +1,82 @@
+package mustgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var ErrInvalidOutput = errors.New("the generated code doesn't compile")
+
+// verifyMode type-checks the package from its source, with the generated files, and its dependencies.
+const verifyMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedImports | packages.NeedDeps |
+	packages.NeedTypes
+
+// VerifyFiles type-checks the generated files, by path, with the package of their directory: the files on disk
+// are replaced by the generated ones, or completed with them. It returns an ErrorList of the errors of the
+// generated files, as *PosError wrapping ErrInvalidOutput with the wrapper they are in. The errors of the other
+// files of the package are ignored, the package may not build yet for other reasons.
+func VerifyFiles(ctx context.Context, files map[string][]byte, buildFlags ...string) error {
+	overlay := make(map[string][]byte, len(files))
+	var dir string
+	for name, src := range files {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+		overlay[abs] = src
+		dir = filepath.Dir(abs)
+	}
+	if dir == "" {
+		return nil
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Context:    ctx,
+		Mode:       verifyMode,
+		Dir:        dir,
+		BuildFlags: buildFlags,
+		Overlay:    overlay,
+	}, ".")
+	if err != nil {
+		return err
+	}
+	var errs ErrorList
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			pos := parsePosition(e.Pos)
+			src, ok := overlay[pos.Filename]
+			if !ok {
+				continue
+			}
+			errs = append(errs, &PosError{Pos: pos, Func: enclosingFunc(pos, src), Err: fmt.Errorf("%w: %s", ErrInvalidOutput, e.Msg)})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// enclosingFunc returns the name of the function of src at pos, the base name of the file outside of functions.
+func enclosingFunc(pos token.Position, src []byte) string {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, pos.Filename, src, parser.SkipObjectResolution)
+	if file != nil {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if start, end := fset.Position(fn.Pos()), fset.Position(fn.End()); start.Line <= pos.Line && pos.Line <= end.Line {
+				return fn.Name.Name
+			}
+		}
+	}
+	return filepath.Base(pos.Filename)
+}
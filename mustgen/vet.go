@@ -0,0 +1,179 @@
+package mustgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	ErrUnknownOption  = errors.New("unknown directive option")
+	ErrStrayDirective = errors.New("directive neither at the start of a function body nor of a struct")
+	// ErrNearMissDirective is a comment written like a directive, read only with Options.LooseDirectives
+	ErrNearMissDirective = errors.New("not a directive, only read with loose directives")
+)
+
+// DirectiveOptions are the key=value options of the directives.
+var DirectiveOptions = []string{"all", "recv", "redact", "variant"}
+
+// LoadPackages loads the packages matching patterns with LoadMode, eg: ./... for Vet.
+func (g *Gen) LoadPackages(ctx context.Context, patterns []string, buildFlags ...string) ([]*packages.Package, error) {
+	pkgs, err := packages.Load(&packages.Config{Context: ctx, Mode: g.LoadMode(), BuildFlags: buildFlags}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, ErrNoPackageFound
+	}
+	for _, pkg := range pkgs {
+		if err = originalSyntax(pkg); err != nil {
+			return nil, err
+		}
+	}
+	return pkgs, nil
+}
+
+// Vet validates the directives of pkg without generating anything. It returns an ErrorList of *PosError, in the
+// order of the sources: the directives that aren't where they are read (ErrStrayDirective), the near misses of the
+// directives (ErrNearMissDirective), the unknown options (ErrUnknownOption), the invalid names (ErrInvalidName) and
+// the tagged functions that can't be wrapped.
+func (g *Gen) Vet(ctx context.Context, pkg *packages.Package) error {
+	scanned, err := g.scanned(pkg)
+	if err != nil {
+		return err
+	}
+	var errs ErrorList
+	for _, file := range scanned.Syntax {
+		if !isGeneratedSyntax(file) {
+			errs = append(errs, g.vetFile(pkg.Fset, file)...)
+		}
+	}
+	lang, _ := g.lang(pkg)
+	scope := packageScope(pkg)
+	err = walkPackage(ctx, scanned, g.directiveTag(), g.opts.Naming, g.opts.Targets, func(d *directive, fnDecl *ast.FuncDecl) error {
+		p := &planner{fset: pkg.Fset, fn: fnDecl, recvName: g.opts.RecvName, file: fileOf(scanned.Syntax, fnDecl), scope: scope, info: pkg.TypesInfo, variants: pluginVariants(g.opts.Plugins)}
+		keys := make([]string, 0, len(d.options))
+		for key := range d.options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !slices.Contains(DirectiveOptions, key) {
+				errs = append(errs, p.errAt(fnDecl, fmt.Errorf("%w: %s", ErrUnknownOption, key)))
+			}
+		}
+		w, err := p.planWrapper(d)
+		if err == nil {
+			if err = w.checkLang(lang); err != nil {
+				err = p.errAt(fnDecl, err)
+			}
+		}
+		// the unknown options are all reported above
+		if err != nil && !errors.Is(err, ErrUnknownOption) {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errorPos(errs[i]), errorPos(errs[j])
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	return errs
+}
+
+// vetFile returns the errors of the directives of file that aren't read: the stray ones and the near misses, and the
+// invalid names of the structs.
+func (g *Gen) vetFile(fset *token.FileSet, file *ast.File) []error {
+	var errs []error
+	read := make(map[*ast.Comment]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			_, comments := funcDirectives(file, n, g.directiveTag())
+			for _, c := range comments {
+				read[c] = true
+			}
+		case *ast.TypeSpec:
+			c := structComment(file, n)
+			if c == nil {
+				break
+			}
+			read[c] = true
+			if d, ok := g.directiveTag().parse(c.Text); ok && d.name != "" && !token.IsIdentifier(d.name) {
+				errs = append(errs, &PosError{Pos: fset.Position(c.Pos()), Func: n.Name.Name, Err: fmt.Errorf("%w: %s", ErrInvalidName, d.name)})
+			}
+		}
+		return true
+	})
+	tag := g.directiveTag()
+	near := directiveTag{tag: tag.tag, loose: true}
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			var err error
+			_, ok := tag.parse(c.Text)
+			switch {
+			case ok && !read[c]:
+				err = ErrStrayDirective
+			case !ok && !tag.loose:
+				if _, ok = near.parse(c.Text); ok {
+					err = ErrNearMissDirective
+				}
+			}
+			if err == nil {
+				continue
+			}
+			pos := fset.Position(c.Pos())
+			name := enclosingDecl(file, c.Pos())
+			if name == "" {
+				name = filepath.Base(pos.Filename)
+			}
+			errs = append(errs, &PosError{Pos: pos, Func: name, Err: err})
+		}
+	}
+	return errs
+}
+
+// enclosingDecl returns the name of the function or of the type of file at pos, empty outside of them.
+func enclosingDecl(file *ast.File, pos token.Pos) string {
+	for _, decl := range file.Decls {
+		if pos < decl.Pos() || pos >= decl.End() {
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			return decl.Name.Name
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok && pos >= ts.Pos() && pos < ts.End() {
+					return ts.Name.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// errorPos returns the position of err, a *PosError.
+func errorPos(err error) token.Position {
+	var posErr *PosError
+	if errors.As(err, &posErr) {
+		return posErr.Pos
+	}
+	return token.Position{}
+}
@@ -0,0 +1,167 @@
+package mustgen
+
+import (
+	"bytes"
+	"context"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PackagePaths returns the import paths of the packages matching patterns.
+func PackagePaths(ctx context.Context, patterns []string, buildFlags ...string) ([]string, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{Context: ctx, Mode: packages.NeedName, BuildFlags: buildFlags},
+		patterns...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, ErrNoPackageFound
+	}
+	paths := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.PkgPath)
+	}
+	return paths, nil
+}
+
+// DirectivePackages returns the import paths of the packages matching patterns with a directive in one of their
+// files, the generated ones left out. The files are parsed, not type-checked.
+func (g *Gen) DirectivePackages(ctx context.Context, patterns []string, buildFlags ...string) ([]string, error) {
+	pkgs, err := packages.Load(
+		&packages.Config{Context: ctx, Mode: packages.NeedName | packages.NeedFiles, BuildFlags: buildFlags},
+		patterns...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, ErrNoPackageFound
+	}
+	var paths []string
+	for _, pkg := range pkgs {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		found, err := g.opts.Files.hasDirectives(pkg.GoFiles, g.directiveTag())
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			paths = append(paths, pkg.PkgPath)
+		}
+	}
+	return paths, nil
+}
+
+// hasDirectives reports whether one of files, not generated, has a directive for tag.
+func (fsys Files) hasDirectives(files []string, tag directiveTag) (bool, error) {
+	fset := token.NewFileSet()
+	for _, name := range files {
+		src, err := fsys.ReadFile(name)
+		if err != nil {
+			return false, err
+		}
+		if !tag.mayContain(src) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			// the package is generated anyway, to report the error
+			return true, nil
+		}
+		if isGeneratedSyntax(file) {
+			continue
+		}
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if _, ok := tag.parse(c.Text); ok {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// WorkspacePatterns replaces the local recursive patterns (eg: ./...) of a directory holding modules of a go.work
+// workspace, without being in one of them, with the patterns of these modules: the go command doesn't match
+// them from the root of a workspace. The other patterns are returned as they are.
+func WorkspacePatterns(ctx context.Context, patterns []string) ([]string, error) {
+	var dirs []string
+	res := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		dir, ok := strings.CutSuffix(pattern, "/...")
+		if !ok || !build.IsLocalImport(dir) && !filepath.IsAbs(dir) {
+			res = append(res, pattern)
+			continue
+		}
+		if dirs == nil {
+			var err error
+			if dirs, err = workspaceModules(ctx); err != nil {
+				return nil, err
+			}
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		var below []string
+		for _, mod := range dirs {
+			if within(abs, mod) {
+				// the go command matches it
+				below = nil
+				break
+			}
+			if within(mod, abs) {
+				rel, err := filepath.Rel(abs, mod)
+				if err != nil {
+					return nil, err
+				}
+				modDir := filepath.Join(dir, rel)
+				if !filepath.IsAbs(modDir) {
+					modDir = "./" + filepath.ToSlash(modDir)
+				}
+				below = append(below, modDir+"/...")
+			}
+		}
+		if len(below) == 0 {
+			res = append(res, pattern)
+			continue
+		}
+		res = append(res, below...)
+	}
+	return res, nil
+}
+
+// workspaceModules returns the directories of the modules of the go.work workspace of the working directory,
+// none when it isn't in a workspace.
+func workspaceModules(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "go", "env", "GOWORK").Output()
+	if err != nil {
+		return nil, err
+	}
+	if work := strings.TrimSpace(string(out)); work == "" || work == "off" {
+		return []string{}, nil
+	}
+	if out, err = exec.CommandContext(ctx, "go", "list", "-m", "-f", "{{.Dir}}").Output(); err != nil {
+		return nil, err
+	}
+	if out = bytes.TrimSpace(out); len(out) == 0 {
+		return []string{}, nil
+	}
+	return strings.Split(string(out), "\n"), nil
+}
+
+// within tells whether path is dir or is below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}